@@ -94,6 +94,9 @@ var benchReply *Reply
 //goland:noinspection GoUnusedGlobalVariable
 var benchConnectRequest *ConnectRequest
 
+//goland:noinspection GoUnusedGlobalVariable
+var benchFrame []byte
+
 func BenchmarkReplyMarshalProtobuf(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		d, r, err := marshalProtobuf()
@@ -422,3 +425,89 @@ func unmarshalJSON(b *testing.B, data []byte) *ConnectRequest {
 	}
 	return cmd.Connect
 }
+
+func benchCompressedFrame(b *testing.B) (*DeflateFrameCodec, []byte, int) {
+	c := NewDeflateFrameCodec("v1", []byte(`{"push":{"channel":"","pub":{"data":`))
+	msg := []byte(`{"push":{"channel":"prices:EURUSD","pub":{"data":` + string(preparedPayload) + `,"offset":42}}}`)
+	frame := c.Compress(nil, msg)
+	if frame[0] != FrameCodecCompressed {
+		b.Fatal("frame not compressed")
+	}
+	return c, frame, len(msg)
+}
+
+func BenchmarkFrameCodecDecompress(b *testing.B) {
+	c, frame, _ := benchCompressedFrame(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out, err := c.Decompress(nil, frame, 1<<20)
+		if err != nil {
+			b.Fatal(err)
+		}
+		benchFrame = out
+	}
+}
+
+func BenchmarkFrameCodecDecompressTo(b *testing.B) {
+	c, frame, size := benchCompressedFrame(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bb := GetByteBuffer(size)
+		if err := c.DecompressTo(bb, frame, 1<<20); err != nil {
+			b.Fatal(err)
+		}
+		benchFrame = bb.B
+		PutByteBuffer(bb)
+	}
+}
+
+func BenchmarkFrameCodecDecompressToParallel(b *testing.B) {
+	c, frame, size := benchCompressedFrame(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			bb := GetByteBuffer(size)
+			if err := c.DecompressTo(bb, frame, 1<<20); err != nil {
+				b.Fatal(err)
+			}
+			PutByteBuffer(bb)
+		}
+	})
+}
+
+func benchJSONReplyFrame(b *testing.B) []byte {
+	encoder := NewJSONDataEncoder()
+	for i := 0; i < 10; i++ {
+		data, err := DefaultJsonReplyEncoder.Encode(&Reply{Push: &Push{Channel: "test", Pub: &Publication{Data: preparedPayload, Offset: uint64(i)}}})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := encoder.Encode(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return encoder.Finish()
+}
+
+func BenchmarkReplyJSONDecode(b *testing.B) {
+	frame := benchJSONReplyFrame(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decoder := GetReplyDecoder(TypeJSON, frame)
+		for {
+			reply, err := decoder.Decode()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+			benchReply = reply
+		}
+		PutReplyDecoder(TypeJSON, decoder)
+	}
+}
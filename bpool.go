@@ -83,3 +83,16 @@ func putByteBuffer(bb *ByteBuffer) {
 	bb.Reset()
 	pools[idx].Put(bb)
 }
+
+// GetByteBuffer returns an empty buffer with at least the given capacity from
+// the size-class pools the package uses internally. Release it with
+// PutByteBuffer once its content is no longer referenced.
+func GetByteBuffer(length int) *ByteBuffer {
+	return getByteBuffer(length)
+}
+
+// PutByteBuffer releases a buffer obtained from GetByteBuffer. Neither bb nor
+// anything sliced from bb.B may be used afterwards.
+func PutByteBuffer(bb *ByteBuffer) {
+	putByteBuffer(bb)
+}
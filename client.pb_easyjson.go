@@ -511,7 +511,7 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild4(out *writer, in Su
 		} else {
 			out.RawString(prefix)
 		}
-		out.Raw((in.Data).MarshalJSON())
+		out.RawPayload(in.Data)
 	}
 	if in.WasRecovering {
 		const prefix string = ",\"was_recovering\":"
@@ -749,7 +749,7 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild5(out *writer, in Su
 		} else {
 			out.RawString(prefix)
 		}
-		out.Raw((in.Data).MarshalJSON())
+		out.RawPayload(in.Data)
 	}
 	if in.Positioned {
 		const prefix string = ",\"positioned\":"
@@ -962,7 +962,7 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild6(out *writer, in Su
 		} else {
 			out.RawString(prefix)
 		}
-		out.Raw((in.Data).MarshalJSON())
+		out.RawPayload(in.Data)
 	}
 	out.RawByte('}')
 }
@@ -1374,7 +1374,7 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild10(out *writer, in S
 		const prefix string = ",\"data\":"
 		first = false
 		out.RawString(prefix[1:])
-		out.Raw((in.Data).MarshalJSON())
+		out.RawPayload(in.Data)
 	}
 	out.RawByte('}')
 }
@@ -1949,7 +1949,7 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild15(out *writer, in R
 		const prefix string = ",\"data\":"
 		first = false
 		out.RawString(prefix[1:])
-		out.Raw((in.Data).MarshalJSON())
+		out.RawPayload(in.Data)
 	}
 	out.RawByte('}')
 }
@@ -2006,7 +2006,7 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild16(out *writer, in R
 		const prefix string = ",\"data\":"
 		first = false
 		out.RawString(prefix[1:])
-		out.Raw((in.Data).MarshalJSON())
+		out.RawPayload(in.Data)
 	}
 	if in.Method != "" {
 		const prefix string = ",\"method\":"
@@ -2379,7 +2379,7 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild19(out *writer, in P
 		} else {
 			out.RawString(prefix)
 		}
-		out.Raw((in.Data).MarshalJSON())
+		out.RawPayload(in.Data)
 	}
 	if in.Type != 0 {
 		const prefix string = ",\"type\":"
@@ -2516,7 +2516,7 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild20(out *writer, in P
 		const prefix string = ",\"data\":"
 		first = false
 		out.RawString(prefix[1:])
-		out.Raw((in.Data).MarshalJSON())
+		out.RawPayload(in.Data)
 	}
 	if in.Info != nil {
 		const prefix string = ",\"info\":"
@@ -2640,7 +2640,7 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild20(out *writer, in P
 		} else {
 			out.RawString(prefix)
 		}
-		out.Raw((in.PrevData).MarshalJSON())
+		out.RawPayload(in.PrevData)
 	}
 	if in.Version != 0 {
 		const prefix string = ",\"version\":"
@@ -3054,7 +3054,7 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild27(out *writer, in M
 		const prefix string = ",\"data\":"
 		first = false
 		out.RawString(prefix[1:])
-		out.Raw((in.Data).MarshalJSON())
+		out.RawPayload(in.Data)
 	}
 	out.RawByte('}')
 }
@@ -3789,7 +3789,7 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild35(out *writer, in E
 		} else {
 			out.RawString(prefix)
 		}
-		out.Raw((in.Data).MarshalJSON())
+		out.RawPayload(in.Data)
 	}
 	out.RawByte('}')
 }
@@ -3935,7 +3935,7 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild37(out *writer, in D
 		} else {
 			out.RawString(prefix)
 		}
-		out.Raw((in.Data).MarshalJSON())
+		out.RawPayload(in.Data)
 	}
 	if in.DataB64 != "" {
 		const prefix string = ",\"data_b64\":"
@@ -4098,7 +4098,7 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild38(out *writer, in C
 		} else {
 			out.RawString(prefix)
 		}
-		out.Raw((in.Data).MarshalJSON())
+		out.RawPayload(in.Data)
 	}
 	if len(in.Subs) != 0 {
 		const prefix string = ",\"subs\":"
@@ -4321,7 +4321,7 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild39(out *writer, in C
 		} else {
 			out.RawString(prefix)
 		}
-		out.Raw((in.Data).MarshalJSON())
+		out.RawPayload(in.Data)
 	}
 	if len(in.Subs) != 0 {
 		const prefix string = ",\"subs\":"
@@ -4544,7 +4544,7 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild40(out *writer, in C
 		} else {
 			out.RawString(prefix)
 		}
-		out.Raw((in.Data).MarshalJSON())
+		out.RawPayload(in.Data)
 	}
 	if len(in.Subs) != 0 {
 		const prefix string = ",\"subs\":"
@@ -5007,12 +5007,12 @@ func easyjson19c08265EncodeGithubComCentrifugalProtocolBuild42(out *writer, in C
 	if len(in.ConnInfo) != 0 {
 		const prefix string = ",\"conn_info\":"
 		out.RawString(prefix)
-		out.Raw((in.ConnInfo).MarshalJSON())
+		out.RawPayload(in.ConnInfo)
 	}
 	if len(in.ChanInfo) != 0 {
 		const prefix string = ",\"chan_info\":"
 		out.RawString(prefix)
-		out.Raw((in.ChanInfo).MarshalJSON())
+		out.RawPayload(in.ChanInfo)
 	}
 	out.RawByte('}')
 }
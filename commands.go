@@ -0,0 +1,28 @@
+package protocol
+
+import "sync"
+
+var commandPool sync.Pool
+
+// AcquireCommand takes an empty Command from the pool, meant to be passed to
+// the DecodeInto method of a CommandIntoDecoder or a StreamCommandIntoDecoder,
+// which the decoder pools return. Once the command is handled, pass it to
+// ReleaseCommand.
+//
+// A pooled Command saves the envelope only: requests set on it by decoding are
+// allocated as usual, and are dropped on release.
+func AcquireCommand() *Command {
+	c := commandPool.Get()
+	if c == nil {
+		return &Command{}
+	}
+	return c.(*Command)
+}
+
+// ReleaseCommand clears c and returns it to the pool. Neither c nor the request
+// it referenced must be used after this call - in particular, with a zero-copy
+// decoder, the request must not be handed over to another goroutine.
+func ReleaseCommand(c *Command) {
+	*c = Command{}
+	commandPool.Put(c)
+}
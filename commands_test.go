@@ -0,0 +1,19 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandPool(t *testing.T) {
+	c := AcquireCommand()
+	c.Id = 1
+	c.Publish = &PublishRequest{Channel: "a"}
+	ReleaseCommand(c)
+	require.Zero(t, c.Id)
+	require.Nil(t, c.Publish)
+	c = AcquireCommand()
+	require.Zero(t, c.Id)
+	require.Nil(t, c.Publish)
+}
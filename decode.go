@@ -3,11 +3,44 @@ package protocol
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 
 	"github.com/segmentio/encoding/json"
 )
 
+// DecodeError is the error decoders return when a message can not be decoded,
+// wrapping the cause - io.ErrShortBuffer, ErrMessageTooLarge, a JSON or
+// Protobuf syntax error - with where in the frame or stream it happened. Use
+// errors.Is to test for the cause and errors.As to get the position:
+//
+//	var decodeErr *DecodeError
+//	if errors.As(err, &decodeErr) {
+//		log.Printf("bad message %d at offset %d: %v", decodeErr.Index, decodeErr.Offset, decodeErr.Err)
+//	}
+//
+// io.EOF, which marks the regular end of a frame or stream, is never wrapped.
+type DecodeError struct {
+	// Type is the protocol type the decoder was reading.
+	Type Type
+	// Index is the position of the failing message among the messages of the
+	// frame, or of the stream for stream decoders, starting from zero.
+	Index int
+	// Offset is the byte offset the failing message starts at, in the frame or
+	// in the stream.
+	Offset int64
+	// Err is the cause.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("centrifugal: decode %s message %d at offset %d: %v", e.Type, e.Index, e.Offset, e.Err)
+}
+
+// Unwrap returns the cause, for errors.Is and errors.As.
+func (e *DecodeError) Unwrap() error { return e.Err }
+
 // CommandDecoder decodes commands from a transport frame which may contain
 // several of them, see DataEncoder for the framing used.
 //
@@ -25,6 +58,8 @@ import (
 //		}
 //	}
 //
+// Any other error is a DecodeError.
+//
 // A CommandDecoder is not safe for concurrent use. Use GetCommandDecoder and
 // PutCommandDecoder to take one from a pool and return it back when done.
 type CommandDecoder interface {
@@ -34,6 +69,23 @@ type CommandDecoder interface {
 	Decode() (*Command, error)
 }
 
+// CommandIntoDecoder is a CommandDecoder which can also decode into a Command
+// owned by the caller, see AcquireCommand. The decoders GetCommandDecoder
+// returns are.
+type CommandIntoDecoder interface {
+	CommandDecoder
+	// DecodeInto is Decode decoding into c rather than into a new Command. c is
+	// cleared first. It returns c whenever Decode would return a Command, and
+	// nil otherwise - in which case the content of c is undefined.
+	DecodeInto(c *Command) (*Command, error)
+}
+
+var (
+	_ CommandIntoDecoder = (*JSONCommandDecoder)(nil)
+	_ CommandIntoDecoder = (*ProtobufCommandDecoder)(nil)
+	_ CommandIntoDecoder = (*JSONLengthPrefixedCommandDecoder)(nil)
+)
+
 // JSONCommandDecoder is a CommandDecoder for commands separated by a `\n`
 // delimiter.
 //
@@ -42,12 +94,14 @@ type CommandDecoder interface {
 // The frame must therefore stay unmodified for as long as the decoded commands
 // are used – do not hand decoded commands to another goroutine while reusing the
 // read buffer they came from. Raw payload fields are copied and are not affected.
-// The Protobuf decoders copy everything, so this applies to JSON only.
+// The Protobuf decoders copy everything unless created with
+// NewProtobufCommandDecoderUnsafe, which follows the same contract.
 type JSONCommandDecoder struct {
 	data            []byte
 	messageCount    int
 	prevNewLine     int
 	numMessagesRead int
+	limits          DecodeLimits
 }
 
 // NewJSONCommandDecoder creates a new JSONCommandDecoder for the given frame.
@@ -81,19 +135,48 @@ func (d *JSONCommandDecoder) Reset(data []byte) error {
 	return nil
 }
 
+// SetLimits makes the decoder enforce the given limits, from the next Decode
+// on. They are kept across Reset.
+func (d *JSONCommandDecoder) SetLimits(limits DecodeLimits) {
+	d.limits = limits
+}
+
+// framePosition returns the offset of the next command in the frame.
+func (d *JSONCommandDecoder) framePosition() int {
+	return min(d.prevNewLine, len(d.data))
+}
+
+func (d *JSONCommandDecoder) decodeError(err error) error {
+	return &DecodeError{Type: TypeJSON, Index: d.numMessagesRead, Offset: int64(d.prevNewLine), Err: err}
+}
+
 // Decode returns the next Command in the frame. The last Command is returned
 // together with io.EOF, see the CommandDecoder interface.
 func (d *JSONCommandDecoder) Decode() (*Command, error) {
+	return d.DecodeInto(&Command{})
+}
+
+// DecodeInto is Decode decoding into c rather than into a new Command, so a
+// server can reuse command envelopes, see AcquireCommand. c is cleared first. It
+// returns c whenever Decode would return a Command, and nil otherwise - in which
+// case the content of c is undefined.
+func (d *JSONCommandDecoder) DecodeInto(c *Command) (*Command, error) {
+	*c = Command{}
 	if d.messageCount == 0 {
-		return nil, io.ErrUnexpectedEOF
+		return nil, d.decodeError(io.ErrUnexpectedEOF)
+	}
+	if err := d.limits.checkCommandCount(d.numMessagesRead); err != nil {
+		return nil, d.decodeError(err)
 	}
-	var c Command
 	if d.messageCount == 1 {
-		_, err := json.Parse(d.data, &c, json.ZeroCopy)
+		if err := d.limits.checkEncodedCommand(TypeJSON, d.data); err != nil {
+			return nil, d.decodeError(err)
+		}
+		_, err := json.Parse(d.data, c, json.ZeroCopy)
 		if err != nil {
-			return nil, err
+			return nil, d.decodeError(err)
 		}
-		return &c, io.EOF
+		return c, io.EOF
 	}
 	var nextNewLine int
 	if d.numMessagesRead == d.messageCount-1 {
@@ -102,32 +185,42 @@ func (d *JSONCommandDecoder) Decode() (*Command, error) {
 	} else if len(d.data) > d.prevNewLine {
 		nextNewLine = bytes.Index(d.data[d.prevNewLine:], []byte("\n"))
 		if nextNewLine < 0 {
-			return nil, io.ErrShortBuffer
+			return nil, d.decodeError(io.ErrShortBuffer)
 		}
 	} else {
-		return nil, io.ErrShortBuffer
+		return nil, d.decodeError(io.ErrShortBuffer)
 	}
 	if len(d.data) >= d.prevNewLine+nextNewLine {
-		_, err := json.Parse(d.data[d.prevNewLine:d.prevNewLine+nextNewLine], &c, json.ZeroCopy)
+		cmdBytes := d.data[d.prevNewLine : d.prevNewLine+nextNewLine]
+		if err := d.limits.checkEncodedCommand(TypeJSON, cmdBytes); err != nil {
+			return nil, d.decodeError(err)
+		}
+		_, err := json.Parse(cmdBytes, c, json.ZeroCopy)
 		if err != nil {
-			return nil, err
+			return nil, d.decodeError(err)
 		}
 		d.numMessagesRead++
 		d.prevNewLine = d.prevNewLine + nextNewLine + 1
 		if d.numMessagesRead == d.messageCount {
-			return &c, io.EOF
+			return c, io.EOF
 		}
-		return &c, nil
+		return c, nil
 	} else {
-		return nil, io.ErrShortBuffer
+		return nil, d.decodeError(io.ErrShortBuffer)
 	}
 }
 
 // ProtobufCommandDecoder is a CommandDecoder for commands prefixed with their
 // length encoded as a varint.
+//
+// By default every string and bytes field of a decoded Command is a copy. See
+// NewProtobufCommandDecoderUnsafe for a decoder which avoids copying strings.
 type ProtobufCommandDecoder struct {
 	data   []byte
 	offset int
+	index  int
+	unsafe bool
+	limits DecodeLimits
 }
 
 // NewProtobufCommandDecoder creates a new ProtobufCommandDecoder for the given
@@ -138,37 +231,133 @@ func NewProtobufCommandDecoder(data []byte) *ProtobufCommandDecoder {
 	}
 }
 
+// NewProtobufCommandDecoderUnsafe creates a new ProtobufCommandDecoder for the
+// given frame which decodes with UnmarshalVTUnsafe.
+//
+// Decoding is then zero-copy under the same contract as JSONCommandDecoder:
+// string fields of the returned Command, map keys and values included, point
+// into the frame rather than into copies of it. The frame must therefore stay
+// unmodified for as long as the decoded commands are used. Raw payload fields
+// are still copied, so a payload handed over to another goroutine - a
+// publication on its way to a broker - is not affected by reusing the frame.
+func NewProtobufCommandDecoderUnsafe(data []byte) *ProtobufCommandDecoder {
+	return &ProtobufCommandDecoder{
+		data:   data,
+		unsafe: true,
+	}
+}
+
 // Reset makes the decoder ready to decode commands from the given frame.
 func (d *ProtobufCommandDecoder) Reset(data []byte) error {
 	d.data = data
 	d.offset = 0
+	d.index = 0
 	return nil
 }
 
+// SetLimits makes the decoder enforce the given limits, from the next Decode
+// on. They are kept across Reset.
+func (d *ProtobufCommandDecoder) SetLimits(limits DecodeLimits) {
+	d.limits = limits
+}
+
+// framePosition returns the offset of the next command in the frame.
+func (d *ProtobufCommandDecoder) framePosition() int {
+	return d.offset
+}
+
+func (d *ProtobufCommandDecoder) decodeError(err error) error {
+	return &DecodeError{Type: TypeProtobuf, Index: d.index, Offset: int64(d.offset), Err: err}
+}
+
+// unmarshal decodes one command, honouring the decoder mode.
+func (d *ProtobufCommandDecoder) unmarshal(c *Command, cmdBytes []byte) error {
+	if !d.unsafe {
+		return c.UnmarshalVT(cmdBytes)
+	}
+	if err := c.UnmarshalVTUnsafe(cmdBytes); err != nil {
+		return err
+	}
+	detachRaw(c)
+	return nil
+}
+
+// detachRaw replaces the Raw payloads of a command decoded with
+// UnmarshalVTUnsafe, which alias the frame, with copies.
+//
+// Payloads are what an application passes on - to a broker, to other
+// connections - so they commonly outlive the frame, while strings are mostly
+// looked at while handling the command. Copying payloads only keeps most of the
+// gain of decoding in place without making its contract any wider than that of
+// JSONCommandDecoder, whose Raw fields are copies too.
+func detachRaw(c *Command) {
+	if c.Connect != nil {
+		c.Connect.Data = cloneRaw(c.Connect.Data)
+		for _, sub := range c.Connect.Subs {
+			if sub != nil {
+				sub.Data = cloneRaw(sub.Data)
+			}
+		}
+	}
+	if c.Subscribe != nil {
+		c.Subscribe.Data = cloneRaw(c.Subscribe.Data)
+	}
+	if c.Publish != nil {
+		c.Publish.Data = cloneRaw(c.Publish.Data)
+	}
+	if c.Rpc != nil {
+		c.Rpc.Data = cloneRaw(c.Rpc.Data)
+	}
+	if c.Send != nil {
+		c.Send.Data = cloneRaw(c.Send.Data)
+	}
+}
+
+// cloneRaw returns a copy of r, keeping the difference between nil and empty.
+func cloneRaw(r Raw) Raw {
+	if r == nil {
+		return nil
+	}
+	return append(make(Raw, 0, len(r)), r...)
+}
+
 // Decode returns the next Command in the frame. The last Command is returned
 // together with io.EOF, see the CommandDecoder interface.
 func (d *ProtobufCommandDecoder) Decode() (*Command, error) {
+	return d.DecodeInto(&Command{})
+}
+
+// DecodeInto is Decode decoding into c rather than into a new Command, see
+// JSONCommandDecoder.DecodeInto.
+func (d *ProtobufCommandDecoder) DecodeInto(c *Command) (*Command, error) {
+	*c = Command{}
 	if d.offset < len(d.data) {
-		var c Command
 		l, n := binary.Uvarint(d.data[d.offset:])
 		if n <= 0 {
 			return nil, io.EOF
 		}
+		if err := d.limits.checkCommandCount(d.index); err != nil {
+			return nil, d.decodeError(err)
+		}
 		from := d.offset + n
 		to := d.offset + n + int(l)
 		if from <= to && to <= len(d.data) {
 			cmdBytes := d.data[from:to]
-			err := c.UnmarshalVT(cmdBytes) // Check whether UnmarshalVTUnsafe here is OK.
+			if err := d.limits.checkEncodedCommand(TypeProtobuf, cmdBytes); err != nil {
+				return nil, d.decodeError(err)
+			}
+			err := d.unmarshal(c, cmdBytes)
 			if err != nil {
-				return nil, err
+				return nil, d.decodeError(err)
 			}
 			d.offset = to
+			d.index++
 			if d.offset == len(d.data) {
 				err = io.EOF
 			}
-			return &c, err
+			return c, err
 		} else {
-			return nil, io.ErrShortBuffer
+			return nil, d.decodeError(io.ErrShortBuffer)
 		}
 	}
 	return nil, io.EOF
@@ -178,9 +367,10 @@ func (d *ProtobufCommandDecoder) Decode() (*Command, error) {
 // of them. It's the client-side counterpart of ReplyEncoder.
 //
 // Unlike CommandDecoder, Decode here returns io.EOF on its own once the frame is
-// fully processed – with a nil Reply.
+// fully processed – with a nil Reply. Any other error is a DecodeError.
 //
-// A ReplyDecoder is not safe for concurrent use.
+// A ReplyDecoder is not safe for concurrent use. Use GetReplyDecoder and
+// PutReplyDecoder to take one from a pool and return it back when done.
 type ReplyDecoder interface {
 	// Reset makes the decoder ready to decode replies from the given frame.
 	Reset([]byte) error
@@ -191,34 +381,65 @@ type ReplyDecoder interface {
 
 var _ ReplyDecoder = NewJSONReplyDecoder(nil)
 
-// JSONReplyDecoder is a ReplyDecoder which reads a stream of JSON replies, such
-// as the `\n` separated frame produced by JSONDataEncoder.
+// JSONReplyDecoder is a ReplyDecoder for replies separated by a `\n` delimiter,
+// such as the frame produced by JSONDataEncoder. Empty lines are skipped.
+//
+// Decoding is zero-copy, as with JSONCommandDecoder: string fields of the
+// returned Reply point into the frame passed to NewJSONReplyDecoder or Reset
+// rather than into copies of it. The frame must therefore stay unmodified for as
+// long as the decoded replies are used - a client reusing its read buffer must
+// be done with the replies of a frame before reading the next one into it, or
+// copy what it keeps. Raw payload fields are copied and are not affected.
 type JSONReplyDecoder struct {
-	decoder *json.Decoder
+	data   []byte
+	offset int
+	index  int
 }
 
 // NewJSONReplyDecoder creates a new JSONReplyDecoder for the given frame.
 func NewJSONReplyDecoder(data []byte) *JSONReplyDecoder {
 	return &JSONReplyDecoder{
-		decoder: json.NewDecoder(bytes.NewReader(data)),
+		data: data,
 	}
 }
 
 // Reset makes the decoder ready to decode replies from the given frame.
 func (d *JSONReplyDecoder) Reset(data []byte) error {
-	d.decoder = json.NewDecoder(bytes.NewReader(data))
+	d.data = data
+	d.offset = 0
+	d.index = 0
 	return nil
 }
 
+// framePosition returns the offset of the next reply in the frame.
+func (d *JSONReplyDecoder) framePosition() int {
+	return d.offset
+}
+
 // Decode returns the next Reply in the frame, or io.EOF if there are no replies
 // left.
 func (d *JSONReplyDecoder) Decode() (*Reply, error) {
-	var c Reply
-	err := d.decoder.Decode(&c)
-	if err != nil {
-		return nil, err
+	for d.offset < len(d.data) {
+		start := d.offset
+		replyBytes := d.data[start:]
+		if i := bytes.IndexByte(replyBytes, '\n'); i >= 0 {
+			replyBytes = replyBytes[:i]
+			d.offset += i + 1
+		} else {
+			d.offset = len(d.data)
+		}
+		if len(replyBytes) == 0 {
+			continue
+		}
+		var c Reply
+		_, err := json.Parse(replyBytes, &c, json.ZeroCopy)
+		if err != nil {
+			return nil, &DecodeError{Type: TypeJSON, Index: d.index, Offset: int64(start), Err: err}
+		}
+		d.index++
+		return &c, nil
 	}
-	return &c, nil
+	return nil, io.EOF
 }
 
 var _ ReplyDecoder = NewProtobufReplyDecoder(nil)
@@ -228,6 +449,7 @@ var _ ReplyDecoder = NewProtobufReplyDecoder(nil)
 type ProtobufReplyDecoder struct {
 	data   []byte
 	offset int
+	index  int
 }
 
 // NewProtobufReplyDecoder creates a new ProtobufReplyDecoder for the given frame.
@@ -241,12 +463,22 @@ func NewProtobufReplyDecoder(data []byte) *ProtobufReplyDecoder {
 func (d *ProtobufReplyDecoder) Reset(data []byte) error {
 	d.data = data
 	d.offset = 0
+	d.index = 0
 	return nil
 }
 
+// framePosition returns the offset of the next reply in the frame.
+func (d *ProtobufReplyDecoder) framePosition() int {
+	return d.offset
+}
+
+func (d *ProtobufReplyDecoder) decodeError(err error) error {
+	return &DecodeError{Type: TypeProtobuf, Index: d.index, Offset: int64(d.offset), Err: err}
+}
+
 // Decode returns the next Reply in the frame, or io.EOF if there are no replies
-// left. It returns io.ErrShortBuffer if a length prefix does not match the data
-// which follows it.
+// left. It returns a DecodeError wrapping io.ErrShortBuffer if a length prefix
+// does not match the data which follows it.
 func (d *ProtobufReplyDecoder) Decode() (*Reply, error) {
 	if d.offset < len(d.data) {
 		var c Reply
@@ -260,14 +492,15 @@ func (d *ProtobufReplyDecoder) Decode() (*Reply, error) {
 		to := d.offset + n + int(l)
 		// The from <= to part also catches an int overflow of the addition above.
 		if from > to || to > len(d.data) {
-			return nil, io.ErrShortBuffer
+			return nil, d.decodeError(io.ErrShortBuffer)
 		}
 		replyBytes := d.data[from:to]
 		err := c.UnmarshalVT(replyBytes)
 		if err != nil {
-			return nil, err
+			return nil, d.decodeError(err)
 		}
 		d.offset = to
+		d.index++
 		return &c, nil
 	}
 	return nil, io.EOF
@@ -0,0 +1,604 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/segmentio/encoding/json"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DecodeLimits bounds the work a single frame or stream can cause, on top of
+// the size limit stream decoders already enforce.
+//
+// A size limit alone does not make decoding cheap: a frame of a few kilobytes
+// can carry hundreds of commands, a connect with hundreds of subscriptions, or a
+// deeply nested publication filter, each of which costs the server far more to
+// decode and handle than to receive. Decoders given DecodeLimits fail with a
+// LimitError as soon as one of them is crossed, so such a frame is rejected
+// before any of its commands reach a handler.
+//
+// The number of commands is checked before decoding the command crossing it.
+// Limits on the content of a command are checked on the encoded command, before
+// it is decoded: a pass over its bytes counts map entries, filter nodes and
+// track items without allocating them, and stops at the first limit crossed.
+// What is counted is what was sent – a map key or a request sent twice, which
+// decoding merges, counts twice. The pass skips whatever no limit is about, and
+// is not made at all when no content limit is set.
+//
+// A zero field means no limit. The zero DecodeLimits is what decoders use
+// unless told otherwise.
+type DecodeLimits struct {
+	// MaxCommands is the maximum number of commands in a frame. A stream has no
+	// frames, so stream decoders apply it to the whole stream, counting from the
+	// last Reset: it is a budget for the life of a connection, which a healthy
+	// connection exhausts too. Leave it zero for stream decoders unless that is
+	// what is wanted, and rate limit commands instead.
+	MaxCommands int
+	// MaxSubs is the maximum number of entries in ConnectRequest.subs.
+	MaxSubs int
+	// MaxHeaders is the maximum number of entries in ConnectRequest.headers.
+	MaxHeaders int
+	// MaxFilterDepth is the maximum depth of a publication filter, see
+	// SubscribeRequest.tf. A filter made of a single leaf node has depth one.
+	MaxFilterDepth int
+	// MaxFilterNodes is the maximum number of nodes in a publication filter.
+	MaxFilterNodes int
+	// MaxTrackBatches is the maximum number of batches in SubRefreshRequest.track.
+	MaxTrackBatches int
+	// MaxTrackItems is the maximum number of items in a SubRefreshRequest.track,
+	// summed over its batches.
+	MaxTrackItems int
+}
+
+// ErrDecodeLimitExceeded is what every LimitError matches with errors.Is.
+var ErrDecodeLimitExceeded = errors.New("centrifugal: decode limit exceeded")
+
+// LimitError is the error decoders return, wrapped in a DecodeError, when a
+// frame or stream crosses one of its DecodeLimits.
+type LimitError struct {
+	// Limit is the name of the DecodeLimits field crossed, such as "MaxSubs".
+	Limit string
+	// Max is the value of that field.
+	Max int
+}
+
+// Error implements the error interface.
+func (e *LimitError) Error() string {
+	return "centrifugal: decode limit exceeded: " + e.Limit + " is " + strconv.Itoa(e.Max)
+}
+
+// Is makes every LimitError match ErrDecodeLimitExceeded.
+func (e *LimitError) Is(target error) bool { return target == ErrDecodeLimitExceeded }
+
+// exceeds tells whether n crosses limit, zero meaning no limit.
+func exceeds(n, limit int) bool {
+	return limit > 0 && n > limit
+}
+
+// checkCommandCount returns a LimitError if a frame or stream which already
+// yielded n commands must not yield another one.
+func (l *DecodeLimits) checkCommandCount(n int) error {
+	if exceeds(n+1, l.MaxCommands) {
+		return &LimitError{Limit: "MaxCommands", Max: l.MaxCommands}
+	}
+	return nil
+}
+
+// contentLimited tells whether any limit on the content of a command is set.
+func (l *DecodeLimits) contentLimited() bool {
+	return l.MaxSubs > 0 || l.MaxHeaders > 0 || l.filterLimited() || l.MaxTrackBatches > 0 || l.MaxTrackItems > 0
+}
+
+// filterLimited tells whether a limit on publication filters is set.
+func (l *DecodeLimits) filterLimited() bool {
+	return l.MaxFilterDepth > 0 || l.MaxFilterNodes > 0
+}
+
+// checkEncodedCommand returns a LimitError if the content of an encoded command
+// of the given type crosses a limit, see DecodeLimits. Input it can not make
+// sense of passes, to fail decoding.
+func (l *DecodeLimits) checkEncodedCommand(protoType Type, data []byte) error {
+	if !l.contentLimited() {
+		return nil
+	}
+	s := limitScan{limits: l}
+	var err error
+	if isJSON(protoType) {
+		s.json.Reset(data)
+		// Release the tokenizer stack to its pool.
+		defer s.json.Reset(nil)
+		err = s.jsonCommand()
+	} else {
+		err = s.protobufCommand(data)
+	}
+	if err == errLimitScanStop {
+		return nil
+	}
+	return err
+}
+
+// errLimitScanStop ends a limitScan on input it can not make sense of.
+var errLimitScanStop = errors.New("malformed command")
+
+// limitScan counts what the content limits of a command are about.
+type limitScan struct {
+	limits  *DecodeLimits
+	subs    int
+	headers int
+	batches int
+	items   int
+	// subscribeNodes counts the nodes of the filter of Command.subscribe, which
+	// decoding merges across repeated requests.
+	subscribeNodes int
+	json           json.Tokenizer
+	key            []byte
+}
+
+func (s *limitScan) addSub() error {
+	if s.subs++; exceeds(s.subs, s.limits.MaxSubs) {
+		return &LimitError{Limit: "MaxSubs", Max: s.limits.MaxSubs}
+	}
+	return nil
+}
+
+func (s *limitScan) addHeader() error {
+	if s.headers++; exceeds(s.headers, s.limits.MaxHeaders) {
+		return &LimitError{Limit: "MaxHeaders", Max: s.limits.MaxHeaders}
+	}
+	return nil
+}
+
+func (s *limitScan) addBatch() error {
+	if s.batches++; exceeds(s.batches, s.limits.MaxTrackBatches) {
+		return &LimitError{Limit: "MaxTrackBatches", Max: s.limits.MaxTrackBatches}
+	}
+	return nil
+}
+
+func (s *limitScan) addItem() error {
+	if s.items++; exceeds(s.items, s.limits.MaxTrackItems) {
+		return &LimitError{Limit: "MaxTrackItems", Max: s.limits.MaxTrackItems}
+	}
+	return nil
+}
+
+// addFilterNode counts a filter node at the given depth into the node count of
+// its filter. Returning at the first limit crossed also bounds the recursion of
+// the scan.
+func (s *limitScan) addFilterNode(depth int, count *int) error {
+	if exceeds(depth, s.limits.MaxFilterDepth) {
+		return &LimitError{Limit: "MaxFilterDepth", Max: s.limits.MaxFilterDepth}
+	}
+	if *count++; exceeds(*count, s.limits.MaxFilterNodes) {
+		return &LimitError{Limit: "MaxFilterNodes", Max: s.limits.MaxFilterNodes}
+	}
+	return nil
+}
+
+// Field numbers of the messages a limitScan walks, see client.proto.
+const (
+	commandConnectField    = 4
+	commandSubscribeField  = 5
+	commandSubRefreshField = 15
+	connectSubsField       = 3
+	connectHeadersField    = 6
+	mapEntryValueField     = 2
+	subscribeFilterField   = 13
+	filterNodesField       = 6
+	subRefreshTrackField   = 4
+	trackBatchItemsField   = 2
+)
+
+// protobufField returns the number and, for a length-delimited field, the value
+// of the field data starts with, and the rest of data. It returns
+// errLimitScanStop for malformed input.
+func protobufField(data []byte) (protowire.Number, []byte, []byte, error) {
+	num, typ, n := protowire.ConsumeTag(data)
+	if n < 0 {
+		return 0, nil, nil, errLimitScanStop
+	}
+	data = data[n:]
+	var value []byte
+	if typ == protowire.BytesType {
+		value, n = protowire.ConsumeBytes(data)
+	} else {
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		// Only length-delimited fields are looked into.
+		num = 0
+	}
+	if n < 0 {
+		return 0, nil, nil, errLimitScanStop
+	}
+	return num, value, data[n:], nil
+}
+
+func (s *limitScan) protobufCommand(data []byte) error {
+	for len(data) > 0 {
+		num, value, rest, err := protobufField(data)
+		if err != nil {
+			return err
+		}
+		data = rest
+		switch num {
+		case commandConnectField:
+			err = s.protobufConnect(value)
+		case commandSubscribeField:
+			err = s.protobufSubscribe(value, &s.subscribeNodes)
+		case commandSubRefreshField:
+			err = s.protobufSubRefresh(value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *limitScan) protobufConnect(data []byte) error {
+	for len(data) > 0 {
+		num, value, rest, err := protobufField(data)
+		if err != nil {
+			return err
+		}
+		data = rest
+		switch num {
+		case connectSubsField:
+			if err := s.addSub(); err != nil {
+				return err
+			}
+			if !s.limits.filterLimited() {
+				continue
+			}
+			for len(value) > 0 {
+				var entryNum protowire.Number
+				var sub []byte
+				entryNum, sub, value, err = protobufField(value)
+				if err != nil {
+					return err
+				}
+				if entryNum == mapEntryValueField {
+					// Map entries with the same key replace each other, so
+					// each counts its filter on its own.
+					var nodes int
+					if err := s.protobufSubscribe(sub, &nodes); err != nil {
+						return err
+					}
+				}
+			}
+		case connectHeadersField:
+			if err := s.addHeader(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *limitScan) protobufSubscribe(data []byte, nodes *int) error {
+	if !s.limits.filterLimited() {
+		return nil
+	}
+	for len(data) > 0 {
+		num, value, rest, err := protobufField(data)
+		if err != nil {
+			return err
+		}
+		data = rest
+		if num == subscribeFilterField {
+			if err := s.protobufFilter(value, 1, nodes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *limitScan) protobufFilter(data []byte, depth int, nodes *int) error {
+	if err := s.addFilterNode(depth, nodes); err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		num, value, rest, err := protobufField(data)
+		if err != nil {
+			return err
+		}
+		data = rest
+		if num == filterNodesField {
+			if err := s.protobufFilter(value, depth+1, nodes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *limitScan) protobufSubRefresh(data []byte) error {
+	for len(data) > 0 {
+		num, value, rest, err := protobufField(data)
+		if err != nil {
+			return err
+		}
+		data = rest
+		if num != subRefreshTrackField {
+			continue
+		}
+		if err := s.addBatch(); err != nil {
+			return err
+		}
+		if s.limits.MaxTrackItems <= 0 {
+			continue
+		}
+		for len(value) > 0 {
+			var batchNum protowire.Number
+			batchNum, _, value, err = protobufField(value)
+			if err != nil {
+				return err
+			}
+			if batchNum == trackBatchItemsField {
+				if err := s.addItem(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonNext moves the tokenizer to the next token other than a ':' or a ','
+// delimiter. It returns errLimitScanStop at the end of the input.
+func (s *limitScan) jsonNext() error {
+	for s.json.Next() {
+		if s.json.Delim != ':' && s.json.Delim != ',' {
+			return nil
+		}
+	}
+	return errLimitScanStop
+}
+
+// jsonKey moves the tokenizer from the start of an object or from the value of
+// its previous key to the value of its next key, setting s.key. It returns false
+// at the end of the object, and errLimitScanStop for malformed input.
+func (s *limitScan) jsonKey() (bool, error) {
+	if err := s.jsonNext(); err != nil {
+		return false, err
+	}
+	if s.json.Delim == '}' {
+		return false, nil
+	}
+	if !s.json.IsKey {
+		return false, errLimitScanStop
+	}
+	s.key = s.json.String()
+	return true, s.jsonNext()
+}
+
+// jsonElem moves the tokenizer from the start of an array or from its previous
+// element to its next element. It returns false at the end of the array.
+func (s *limitScan) jsonElem() (bool, error) {
+	if err := s.jsonNext(); err != nil {
+		return false, err
+	}
+	return s.json.Delim != ']', nil
+}
+
+// jsonSkip moves the tokenizer from the start of a value to its last token.
+func (s *limitScan) jsonSkip() error {
+	if s.json.Delim != '{' && s.json.Delim != '[' {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		if !s.json.Next() {
+			return errLimitScanStop
+		}
+		switch s.json.Delim {
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+		}
+	}
+	return nil
+}
+
+// jsonIsKey tells whether s.key is name. Keys are matched without regard to
+// case, as decoding does.
+func (s *limitScan) jsonIsKey(name string) bool {
+	return bytes.EqualFold(s.key, []byte(name))
+}
+
+func (s *limitScan) jsonCommand() error {
+	if err := s.jsonNext(); err != nil || s.json.Delim != '{' {
+		return errLimitScanStop
+	}
+	for {
+		ok, err := s.jsonKey()
+		if !ok || err != nil {
+			return err
+		}
+		switch {
+		case s.jsonIsKey("connect"):
+			err = s.jsonConnect()
+		case s.jsonIsKey("subscribe"):
+			err = s.jsonSubscribe(&s.subscribeNodes)
+		case s.jsonIsKey("sub_refresh"):
+			err = s.jsonSubRefresh()
+		default:
+			err = s.jsonSkip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *limitScan) jsonConnect() error {
+	if s.json.Delim != '{' {
+		return s.jsonSkip()
+	}
+	for {
+		ok, err := s.jsonKey()
+		if !ok || err != nil {
+			return err
+		}
+		switch {
+		case s.jsonIsKey("subs") && s.json.Delim == '{':
+			for {
+				ok, err := s.jsonKey()
+				if err != nil {
+					return err
+				}
+				if !ok {
+					break
+				}
+				if err := s.addSub(); err != nil {
+					return err
+				}
+				var nodes int
+				if err := s.jsonSubscribe(&nodes); err != nil {
+					return err
+				}
+			}
+		case s.jsonIsKey("headers") && s.json.Delim == '{':
+			for {
+				ok, err := s.jsonKey()
+				if err != nil {
+					return err
+				}
+				if !ok {
+					break
+				}
+				if err := s.addHeader(); err != nil {
+					return err
+				}
+				if err := s.jsonSkip(); err != nil {
+					return err
+				}
+			}
+		default:
+			if err := s.jsonSkip(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *limitScan) jsonSubscribe(nodes *int) error {
+	if s.json.Delim != '{' || !s.limits.filterLimited() {
+		return s.jsonSkip()
+	}
+	for {
+		ok, err := s.jsonKey()
+		if !ok || err != nil {
+			return err
+		}
+		if s.jsonIsKey("tf") {
+			err = s.jsonFilter(1, nodes)
+		} else {
+			err = s.jsonSkip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *limitScan) jsonFilter(depth int, nodes *int) error {
+	if s.json.Delim != '{' {
+		return s.jsonSkip()
+	}
+	if err := s.addFilterNode(depth, nodes); err != nil {
+		return err
+	}
+	for {
+		ok, err := s.jsonKey()
+		if !ok || err != nil {
+			return err
+		}
+		if !s.jsonIsKey("nodes") || s.json.Delim != '[' {
+			if err := s.jsonSkip(); err != nil {
+				return err
+			}
+			continue
+		}
+		for {
+			ok, err := s.jsonElem()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			if err := s.jsonFilter(depth+1, nodes); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *limitScan) jsonSubRefresh() error {
+	if s.json.Delim != '{' {
+		return s.jsonSkip()
+	}
+	for {
+		ok, err := s.jsonKey()
+		if !ok || err != nil {
+			return err
+		}
+		if !s.jsonIsKey("track") || s.json.Delim != '[' {
+			if err := s.jsonSkip(); err != nil {
+				return err
+			}
+			continue
+		}
+		for {
+			ok, err := s.jsonElem()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			if err := s.addBatch(); err != nil {
+				return err
+			}
+			if err := s.jsonTrackBatch(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *limitScan) jsonTrackBatch() error {
+	if s.json.Delim != '{' || s.limits.MaxTrackItems <= 0 {
+		return s.jsonSkip()
+	}
+	for {
+		ok, err := s.jsonKey()
+		if !ok || err != nil {
+			return err
+		}
+		if !s.jsonIsKey("items") || s.json.Delim != '[' {
+			if err := s.jsonSkip(); err != nil {
+				return err
+			}
+			continue
+		}
+		for {
+			ok, err := s.jsonElem()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			if err := s.addItem(); err != nil {
+				return err
+			}
+			if err := s.jsonSkip(); err != nil {
+				return err
+			}
+		}
+	}
+}
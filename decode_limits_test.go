@@ -0,0 +1,312 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// encodeCommands returns commands encoded into a frame of the given type.
+func encodeCommands(t *testing.T, protoType Type, cmds ...*Command) []byte {
+	t.Helper()
+	switch protoType {
+	case TypeJSONLengthPrefixed:
+		return encodeJSONLengthPrefixedCommands(t, cmds...)
+	case TypeJSON:
+	default:
+		return encodeCommandFrame(t, cmds...)
+	}
+	var frame []byte
+	for _, cmd := range cmds {
+		b, err := NewJSONCommandEncoder().Encode(cmd)
+		require.NoError(t, err)
+		if len(frame) > 0 {
+			frame = append(frame, '\n')
+		}
+		frame = append(frame, b...)
+	}
+	return frame
+}
+
+func requireLimitError(t *testing.T, err error, limit string) {
+	t.Helper()
+	require.ErrorIs(t, err, ErrDecodeLimitExceeded)
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, limit, limitErr.Limit)
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+}
+
+// filterChain returns a filter of the given depth, one node per level.
+func filterChain(depth int) *FilterNode {
+	node := &FilterNode{Key: "k", Cmp: "eq", Val: "v"}
+	for i := 1; i < depth; i++ {
+		node = &FilterNode{Op: "not", Nodes: []*FilterNode{node}}
+	}
+	return node
+}
+
+// filterWide returns a filter of depth two with n leaves.
+func filterWide(n int) *FilterNode {
+	node := &FilterNode{Op: "or"}
+	for i := 0; i < n; i++ {
+		node.Nodes = append(node.Nodes, &FilterNode{Key: "k", Cmp: "ex"})
+	}
+	return node
+}
+
+var decodeLimitsTests = []struct {
+	name  string
+	cmd   *Command
+	limit string
+}{
+	{
+		"subs",
+		&Command{Id: 1, Connect: &ConnectRequest{Subs: map[string]*SubscribeRequest{"a": {}, "b": {}, "c": {}}}},
+		"MaxSubs",
+	},
+	{
+		"headers",
+		&Command{Id: 1, Connect: &ConnectRequest{Headers: map[string]string{"a": "1", "b": "2", "c": "3"}}},
+		"MaxHeaders",
+	},
+	{
+		"filter depth",
+		&Command{Id: 1, Subscribe: &SubscribeRequest{Channel: "a", Tf: filterChain(4)}},
+		"MaxFilterDepth",
+	},
+	{
+		"filter depth in connect",
+		&Command{Id: 1, Connect: &ConnectRequest{Subs: map[string]*SubscribeRequest{"a": {Tf: filterChain(4)}}}},
+		"MaxFilterDepth",
+	},
+	{
+		"filter nodes",
+		&Command{Id: 1, Subscribe: &SubscribeRequest{Channel: "a", Tf: filterWide(5)}},
+		"MaxFilterNodes",
+	},
+	{
+		"track batches",
+		&Command{Id: 1, SubRefresh: &SubRefreshRequest{Channel: "a", Type: SubRefreshTypeTrack, Track: []*TrackBatch{{}, {}, {}}}},
+		"MaxTrackBatches",
+	},
+	{
+		"track items",
+		&Command{Id: 1, SubRefresh: &SubRefreshRequest{Channel: "a", Type: SubRefreshTypeTrack, Track: []*TrackBatch{
+			{Items: []*KeyedItem{{Key: "a"}, {Key: "b"}}},
+			{Items: []*KeyedItem{{Key: "c"}, {Key: "d"}}},
+		}}},
+		"MaxTrackItems",
+	},
+}
+
+var testDecodeLimits = DecodeLimits{
+	MaxCommands:     2,
+	MaxSubs:         2,
+	MaxHeaders:      2,
+	MaxFilterDepth:  3,
+	MaxFilterNodes:  4,
+	MaxTrackBatches: 2,
+	MaxTrackItems:   3,
+}
+
+func TestCommandDecoder_DecodeLimits(t *testing.T) {
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf, TypeJSONLengthPrefixed} {
+		for _, tt := range decodeLimitsTests {
+			t.Run(string(protoType)+"/"+tt.name, func(t *testing.T) {
+				frame := encodeCommands(t, protoType, &Command{Id: 1, Ping: &PingRequest{}}, tt.cmd)
+
+				decoder := GetCommandDecoder(protoType, frame)
+				for {
+					_, err := decoder.Decode()
+					if err != nil {
+						require.Equal(t, io.EOF, err, "no limits by default")
+						break
+					}
+				}
+				PutCommandDecoder(protoType, decoder)
+
+				decoder = GetCommandDecoderWithLimits(protoType, frame, testDecodeLimits)
+				defer PutCommandDecoder(protoType, decoder)
+				cmd, err := decoder.Decode()
+				require.NoError(t, err)
+				require.NotNil(t, cmd.Ping)
+				cmd, err = decoder.Decode()
+				require.Nil(t, cmd)
+				requireLimitError(t, err, tt.limit)
+				var decodeErr *DecodeError
+				require.True(t, errors.As(err, &decodeErr))
+				require.Equal(t, 1, decodeErr.Index)
+			})
+		}
+	}
+}
+
+func TestCommandDecoder_MaxCommands(t *testing.T) {
+	ping := &Command{Id: 1, Ping: &PingRequest{}}
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		t.Run(string(protoType), func(t *testing.T) {
+			limits := DecodeLimits{MaxCommands: 2}
+
+			decoder := GetCommandDecoderWithLimits(protoType, encodeCommands(t, protoType, ping, ping), limits)
+			_, err := decoder.Decode()
+			require.NoError(t, err)
+			cmd, err := decoder.Decode()
+			require.Equal(t, io.EOF, err)
+			require.NotNil(t, cmd)
+			PutCommandDecoder(protoType, decoder)
+
+			decoder = GetCommandDecoderWithLimits(protoType, encodeCommands(t, protoType, ping, ping, ping), limits)
+			defer PutCommandDecoder(protoType, decoder)
+			_, err = decoder.Decode()
+			require.NoError(t, err)
+			_, err = decoder.Decode()
+			require.NoError(t, err)
+			cmd, err = decoder.Decode()
+			require.Nil(t, cmd)
+			requireLimitError(t, err, "MaxCommands")
+		})
+	}
+}
+
+func TestCommandDecoder_DecodeLimitsNotPooled(t *testing.T) {
+	ping := &Command{Id: 1, Ping: &PingRequest{}}
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		frame := encodeCommands(t, protoType, ping, ping)
+		decoder := GetCommandDecoderWithLimits(protoType, frame, DecodeLimits{MaxCommands: 1})
+		PutCommandDecoder(protoType, decoder)
+		// Whether or not the limited decoder comes back, limits must not.
+		decoder = GetCommandDecoder(protoType, frame)
+		_, err := decoder.Decode()
+		require.NoError(t, err)
+		_, err = decoder.Decode()
+		require.Equal(t, io.EOF, err)
+		PutCommandDecoder(protoType, decoder)
+	}
+}
+
+func TestStreamCommandDecoder_DecodeLimits(t *testing.T) {
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf, TypeJSONLengthPrefixed} {
+		for _, tt := range decodeLimitsTests {
+			t.Run(string(protoType)+"/"+tt.name, func(t *testing.T) {
+				stream := encodeCommands(t, protoType, &Command{Id: 1, Ping: &PingRequest{}}, tt.cmd)
+				decoder := GetStreamCommandDecoderWithLimits(protoType, bytes.NewReader(stream), 1024, testDecodeLimits)
+				defer PutStreamCommandDecoder(protoType, decoder)
+				cmd, _, err := decoder.Decode()
+				require.NoError(t, err)
+				require.NotNil(t, cmd.Ping)
+				cmd, _, err = decoder.Decode()
+				require.Nil(t, cmd)
+				requireLimitError(t, err, tt.limit)
+			})
+		}
+	}
+}
+
+func TestStreamCommandDecoder_MaxCommands(t *testing.T) {
+	ping := &Command{Id: 1, Ping: &PingRequest{}}
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		t.Run(string(protoType), func(t *testing.T) {
+			limits := DecodeLimits{MaxCommands: 2}
+			stream := encodeCommands(t, protoType, ping, ping)
+			if protoType == TypeJSON {
+				stream = append(stream, '\n')
+			}
+
+			// A stream ending right at the limit ends with io.EOF.
+			decoder := GetStreamCommandDecoderWithLimits(protoType, bytes.NewReader(stream), 1024, limits)
+			for i := 0; i < 2; i++ {
+				cmd, _, err := decoder.Decode()
+				require.NoError(t, err)
+				require.NotNil(t, cmd)
+			}
+			_, _, err := decoder.Decode()
+			require.Equal(t, io.EOF, err)
+
+			decoder.Reset(bytes.NewReader(append(stream, encodeCommands(t, protoType, ping)...)), 1024)
+			defer PutStreamCommandDecoder(protoType, decoder)
+			for i := 0; i < 2; i++ {
+				_, _, err := decoder.Decode()
+				require.NoError(t, err)
+			}
+			cmd, _, err := decoder.Decode()
+			require.Nil(t, cmd)
+			requireLimitError(t, err, "MaxCommands")
+			var decodeErr *DecodeError
+			require.True(t, errors.As(err, &decodeErr))
+			require.Equal(t, 2, decodeErr.Index)
+			require.Equal(t, int64(len(stream)), decodeErr.Offset)
+		})
+	}
+}
+
+// lengthPrefixed returns data prefixed with its length, as a one command frame
+// or stream of a length-prefixed type.
+func lengthPrefixed(data []byte) []byte {
+	return append(binary.AppendUvarint(nil, uint64(len(data))), data...)
+}
+
+// encodedOverLimits returns commands of the given type crossing limits, followed
+// by bytes failing to decode, so decoding them fails with a LimitError only when
+// the limits are checked before decoding.
+func encodedOverLimits(t *testing.T, protoType Type) map[string][]byte {
+	if protoType == TypeProtobuf {
+		subs, err := (&Command{Connect: &ConnectRequest{Subs: map[string]*SubscribeRequest{"a": {}, "b": {}, "c": {}}}}).MarshalVT()
+		require.NoError(t, err)
+		filter, err := (&Command{Subscribe: &SubscribeRequest{Tf: filterChain(4)}}).MarshalVT()
+		require.NoError(t, err)
+		// A varint field missing its value.
+		return map[string][]byte{
+			"MaxSubs":        lengthPrefixed(append(subs, 0x08)),
+			"MaxFilterDepth": lengthPrefixed(append(filter, 0x08)),
+		}
+	}
+	cmds := map[string][]byte{
+		// An id which is not a number.
+		"MaxSubs": []byte(`{"id":"x","connect":{"subs":{"a":{},"b":{},"c":{}}}}`),
+		// A deep filter, left unterminated.
+		"MaxFilterDepth": []byte(`{"subscribe":{"tf":` + strings.Repeat(`{"nodes":[`, 1000)),
+	}
+	if protoType == TypeJSONLengthPrefixed {
+		for limit, cmd := range cmds {
+			cmds[limit] = lengthPrefixed(cmd)
+		}
+	}
+	return cmds
+}
+
+func TestCommandDecoder_DecodeLimitsBeforeDecoding(t *testing.T) {
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf, TypeJSONLengthPrefixed} {
+		for limit, frame := range encodedOverLimits(t, protoType) {
+			t.Run(string(protoType)+"/"+limit, func(t *testing.T) {
+				decoder := GetCommandDecoder(protoType, frame)
+				_, err := decoder.Decode()
+				require.Error(t, err)
+				require.NotErrorIs(t, err, ErrDecodeLimitExceeded)
+				PutCommandDecoder(protoType, decoder)
+
+				decoder = GetCommandDecoderWithLimits(protoType, frame, testDecodeLimits)
+				defer PutCommandDecoder(protoType, decoder)
+				_, err = decoder.Decode()
+				requireLimitError(t, err, limit)
+
+				stream := GetStreamCommandDecoderWithLimits(protoType, bytes.NewReader(frame), 1<<22, testDecodeLimits)
+				defer PutStreamCommandDecoder(protoType, stream)
+				_, _, err = stream.Decode()
+				requireLimitError(t, err, limit)
+			})
+		}
+	}
+}
+
+func TestLimitError(t *testing.T) {
+	err := &LimitError{Limit: "MaxSubs", Max: 8}
+	require.EqualError(t, err, "centrifugal: decode limit exceeded: MaxSubs is 8")
+	require.ErrorIs(t, err, ErrDecodeLimitExceeded)
+}
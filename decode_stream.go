@@ -2,10 +2,12 @@ package protocol
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"io"
 	"math"
+	"math/bits"
 	"sync"
 
 	"github.com/segmentio/encoding/json"
@@ -26,8 +28,9 @@ const maxMessageLength = math.MaxInt32
 const maxRetainedLineBuffer = 65536
 
 var (
-	streamJsonCommandDecoderPool     sync.Pool
-	streamProtobufCommandDecoderPool sync.Pool
+	streamJsonCommandDecoderPool               sync.Pool
+	streamProtobufCommandDecoderPool           sync.Pool
+	streamJsonLengthPrefixedCommandDecoderPool sync.Pool
 )
 
 // errNonPositiveMessageSizeLimit is the panic value used when a stream decoder
@@ -45,38 +48,63 @@ const errNonPositiveMessageSizeLimit = "protocol: stream command decoder require
 // Commands larger than messageSizeLimit bytes are rejected with
 // ErrMessageTooLarge. messageSizeLimit must be positive - a zero or negative
 // limit panics, since an unbounded decoder over untrusted input can be driven to
-// allocate arbitrary memory by a single frame. Any type other than TypeJSON is
-// treated as TypeProtobuf.
-func GetStreamCommandDecoderLimited(protoType Type, reader io.Reader, messageSizeLimit int64) StreamCommandDecoder {
+// allocate arbitrary memory by a single frame. Any type other than TypeJSON and
+// TypeJSONLengthPrefixed is treated as TypeProtobuf.
+func GetStreamCommandDecoderLimited(protoType Type, reader io.Reader, messageSizeLimit int64) StreamCommandIntoDecoder {
+	return GetStreamCommandDecoderWithLimits(protoType, reader, messageSizeLimit, DecodeLimits{})
+}
+
+// GetStreamCommandDecoderWithLimits is GetStreamCommandDecoderLimited returning
+// a decoder which also enforces the given DecodeLimits.
+func GetStreamCommandDecoderWithLimits(protoType Type, reader io.Reader, messageSizeLimit int64, limits DecodeLimits) StreamCommandIntoDecoder {
 	if messageSizeLimit <= 0 {
 		panic(errNonPositiveMessageSizeLimit)
 	}
-	if protoType == TypeJSON {
-		e := streamJsonCommandDecoderPool.Get()
-		if e == nil {
-			return NewJSONStreamCommandDecoder(reader, messageSizeLimit)
+	switch protoType {
+	case TypeJSON:
+		var commandDecoder *JSONStreamCommandDecoder
+		if e := streamJsonCommandDecoderPool.Get(); e != nil {
+			commandDecoder = e.(*JSONStreamCommandDecoder)
+			commandDecoder.Reset(reader, messageSizeLimit)
+		} else {
+			commandDecoder = NewJSONStreamCommandDecoder(reader, messageSizeLimit)
 		}
-		commandDecoder := e.(*JSONStreamCommandDecoder)
-		commandDecoder.Reset(reader, messageSizeLimit)
+		commandDecoder.SetLimits(limits)
+		return commandDecoder
+	case TypeJSONLengthPrefixed:
+		var commandDecoder *JSONLengthPrefixedStreamCommandDecoder
+		if e := streamJsonLengthPrefixedCommandDecoderPool.Get(); e != nil {
+			commandDecoder = e.(*JSONLengthPrefixedStreamCommandDecoder)
+			commandDecoder.Reset(reader, messageSizeLimit)
+		} else {
+			commandDecoder = NewJSONLengthPrefixedStreamCommandDecoder(reader, messageSizeLimit)
+		}
+		commandDecoder.SetLimits(limits)
 		return commandDecoder
 	}
-	e := streamProtobufCommandDecoderPool.Get()
-	if e == nil {
-		return NewProtobufStreamCommandDecoder(reader, messageSizeLimit)
+	var commandDecoder *ProtobufStreamCommandDecoder
+	if e := streamProtobufCommandDecoderPool.Get(); e != nil {
+		commandDecoder = e.(*ProtobufStreamCommandDecoder)
+		commandDecoder.Reset(reader, messageSizeLimit)
+	} else {
+		commandDecoder = NewProtobufStreamCommandDecoder(reader, messageSizeLimit)
 	}
-	commandDecoder := e.(*ProtobufStreamCommandDecoder)
-	commandDecoder.Reset(reader, messageSizeLimit)
+	commandDecoder.SetLimits(limits)
 	return commandDecoder
 }
 
 // PutStreamCommandDecoder returns a StreamCommandDecoder obtained with
-// GetStreamCommandDecoderLimited to the pool. The decoder must not be used after
-// that.
+// GetStreamCommandDecoderLimited or GetStreamCommandDecoderWithLimits to the
+// pool. The decoder must not be used after that.
 func PutStreamCommandDecoder(protoType Type, e StreamCommandDecoder) {
 	e.Reset(nil, 0)
-	if protoType == TypeJSON {
+	switch protoType {
+	case TypeJSON:
 		streamJsonCommandDecoderPool.Put(e)
 		return
+	case TypeJSONLengthPrefixed:
+		streamJsonLengthPrefixedCommandDecoderPool.Put(e)
+		return
 	}
 	streamProtobufCommandDecoderPool.Put(e)
 }
@@ -92,8 +120,9 @@ func PutStreamCommandDecoder(protoType Type, e StreamCommandDecoder) {
 type StreamCommandDecoder interface {
 	// Decode returns the next Command from the stream together with the number
 	// of bytes attributed to it, or an error. It returns io.EOF when the stream
-	// is over and ErrMessageTooLarge if the command exceeds the configured
-	// message size limit.
+	// is over, and a DecodeError otherwise - wrapping ErrMessageTooLarge if the
+	// command exceeds the configured message size limit, or a LimitError if the
+	// stream crosses its DecodeLimits.
 	Decode() (*Command, int, error)
 	// Reset makes the decoder read from the given reader, applying the given
 	// message size limit. It is used internally to reuse a pooled decoder;
@@ -102,22 +131,32 @@ type StreamCommandDecoder interface {
 	Reset(reader io.Reader, messageSizeLimit int64)
 }
 
-// ErrMessageTooLarge is returned by a StreamCommandDecoder when a command in the
-// stream exceeds the configured message size limit.
+// StreamCommandIntoDecoder is a StreamCommandDecoder which can also decode into
+// a Command owned by the caller, see AcquireCommand. The decoders
+// GetStreamCommandDecoderLimited returns are.
+type StreamCommandIntoDecoder interface {
+	StreamCommandDecoder
+	// DecodeInto is Decode decoding into c rather than into a new Command. c is
+	// cleared first. It returns c whenever Decode would return a Command, and
+	// nil otherwise - in which case the content of c is undefined.
+	DecodeInto(c *Command) (*Command, int, error)
+}
+
+var (
+	_ StreamCommandIntoDecoder = (*JSONStreamCommandDecoder)(nil)
+	_ StreamCommandIntoDecoder = (*ProtobufStreamCommandDecoder)(nil)
+	_ StreamCommandIntoDecoder = (*JSONLengthPrefixedStreamCommandDecoder)(nil)
+)
+
+// ErrMessageTooLarge is returned by stream decoders, wrapped in a DecodeError,
+// when a message in the stream exceeds the configured message size limit.
 var ErrMessageTooLarge = errors.New("message size exceeds the limit")
 
 // JSONStreamCommandDecoder is a StreamCommandDecoder which reads commands
 // separated by a `\n` delimiter.
 type JSONStreamCommandDecoder struct {
-	reader           *bufio.Reader
-	limitedReader    *io.LimitedReader
-	messageSizeLimit int64
-	// buf accumulates a command which does not fit into the bufio.Reader
-	// buffer, reused across Decode calls. It's held behind a pointer rather
-	// than being a []byte so that JSONStreamCommandDecoder stays comparable:
-	// a slice field would make it non comparable, which is an incompatible
-	// API change even though nothing here compares decoders.
-	buf *ByteBuffer
+	jsonStreamReader
+	limits DecodeLimits
 }
 
 // NewJSONStreamCommandDecoder creates a new JSONStreamCommandDecoder reading from
@@ -126,56 +165,118 @@ func NewJSONStreamCommandDecoder(reader io.Reader, messageSizeLimit int64) *JSON
 	if messageSizeLimit <= 0 {
 		panic(errNonPositiveMessageSizeLimit)
 	}
+	return &JSONStreamCommandDecoder{jsonStreamReader: newJSONStreamReader(reader, messageSizeLimit)}
+}
+
+// Decode returns the next Command from the stream, see the StreamCommandDecoder
+// interface.
+func (d *JSONStreamCommandDecoder) Decode() (*Command, int, error) {
+	return d.DecodeInto(&Command{})
+}
+
+// DecodeInto is Decode decoding into c rather than into a new Command, so a
+// server can reuse command envelopes, see AcquireCommand. c is cleared first. It
+// returns c whenever Decode would return a Command, and nil otherwise - in which
+// case the content of c is undefined.
+func (d *JSONStreamCommandDecoder) DecodeInto(c *Command) (*Command, int, error) {
+	*c = Command{}
+	cmdBytes, err := d.next()
+	if err != nil && (err != io.EOF || len(cmdBytes) == 0) {
+		return nil, 0, d.decodeError(err)
+	}
+	if limitErr := d.limits.checkCommandCount(d.index); limitErr != nil {
+		return nil, 0, d.decodeError(limitErr)
+	}
+	if limitErr := d.limits.checkEncodedCommand(TypeJSON, cmdBytes); limitErr != nil {
+		return nil, 0, d.decodeError(limitErr)
+	}
+	if _, parseErr := json.Parse(cmdBytes, c, 0); parseErr != nil {
+		return nil, 0, d.decodeError(parseErr)
+	}
+	d.index++
+	return c, len(cmdBytes), err
+}
+
+// SetLimits makes the decoder enforce the given limits, from the next Decode
+// on. They are kept across Reset.
+func (d *JSONStreamCommandDecoder) SetLimits(limits DecodeLimits) {
+	d.limits = limits
+}
+
+// Reset makes the decoder read from the given reader, applying the given message
+// size limit.
+func (d *JSONStreamCommandDecoder) Reset(reader io.Reader, messageSizeLimit int64) {
+	d.reset(reader, messageSizeLimit)
+}
+
+// jsonStreamReader reads messages separated by a `\n` delimiter, enforcing a
+// message size limit. It's the part JSON stream decoders of commands and replies
+// share.
+type jsonStreamReader struct {
+	reader           *bufio.Reader
+	limitedReader    *io.LimitedReader
+	messageSizeLimit int64
+	// buf accumulates a message which does not fit into the bufio.Reader
+	// buffer, reused across next calls. It's held behind a pointer rather
+	// than being a []byte so that the decoders embedding jsonStreamReader stay
+	// comparable: a slice field would make them non comparable, which is an
+	// incompatible API change even though nothing here compares decoders.
+	buf *ByteBuffer
+	// index counts the messages decoded so far, for DecodeError. offset is the
+	// number of bytes read from the stream, and start where the message
+	// returned last by next starts.
+	index  int
+	offset int64
+	start  int64
+}
+
+func newJSONStreamReader(reader io.Reader, messageSizeLimit int64) jsonStreamReader {
 	limitedReader := &io.LimitedReader{R: reader, N: readBudget(messageSizeLimit)}
-	return &JSONStreamCommandDecoder{
+	return jsonStreamReader{
 		reader:           bufio.NewReader(limitedReader),
 		limitedReader:    limitedReader,
 		messageSizeLimit: messageSizeLimit,
 	}
 }
 
-// Decode returns the next Command from the stream, see the StreamCommandDecoder
-// interface.
-func (d *JSONStreamCommandDecoder) Decode() (*Command, int, error) {
+// next returns the next message, including its delimiter if any. It returns
+// ErrMessageTooLarge if the message exceeds the limit, and the last message of
+// the stream together with io.EOF when the stream does not end with a
+// delimiter. The returned slice is only valid until the next call, see readLine.
+func (d *jsonStreamReader) next() ([]byte, error) {
 	if d.messageSizeLimit > 0 {
 		d.limitedReader.N = readBudget(d.messageSizeLimit)
 	}
-	// Drop an oversized buffer left behind by an earlier command before it is
+	// Drop an oversized buffer left behind by an earlier message before it is
 	// reused, so a decoder which is never Reset does not keep one for the life
 	// of the stream.
 	d.trimBuf()
-	cmdBytes, err := d.readLine()
+	msgBytes, err := d.readLine()
+	d.start = d.offset
+	d.offset += int64(len(msgBytes))
 	// The limit is checked on both paths out of readLine. Checking it only
-	// when reading failed is not enough: a command whose delimiter was already
-	// buffered under an earlier Decode's budget comes back with a nil error,
-	// and would otherwise skip the check entirely.
-	if d.messageSizeLimit > 0 && int64(commandLen(cmdBytes)) > d.messageSizeLimit {
-		return nil, 0, ErrMessageTooLarge
-	}
-	if err != nil {
-		if err == io.EOF && len(cmdBytes) > 0 {
-			var c Command
-			_, parseErr := json.Parse(cmdBytes, &c, 0)
-			if parseErr != nil {
-				return nil, 0, parseErr
-			}
-			return &c, len(cmdBytes), err
-		}
-		return nil, 0, err
+	// when reading failed is not enough: a message whose delimiter was already
+	// buffered under an earlier call's budget comes back with a nil error, and
+	// would otherwise skip the check entirely.
+	if d.messageSizeLimit > 0 && int64(commandLen(msgBytes)) > d.messageSizeLimit {
+		return nil, ErrMessageTooLarge
 	}
+	return msgBytes, err
+}
 
-	var c Command
-	_, err = json.Parse(cmdBytes, &c, 0)
-	if err != nil {
-		return nil, 0, err
+// decodeError wraps err into a DecodeError for the message returned last by
+// next, leaving io.EOF as is.
+func (d *jsonStreamReader) decodeError(err error) error {
+	if err == io.EOF {
+		return err
 	}
-	return &c, len(cmdBytes), nil
+	return &DecodeError{Type: TypeJSON, Index: d.index, Offset: d.start, Err: err}
 }
 
 // trimBuf drops the accumulation buffer once it has grown past
-// maxRetainedLineBuffer, so that a single large command does not make a decoder
+// maxRetainedLineBuffer, so that a single large message does not make a decoder
 // hold on to a large allocation indefinitely.
-func (d *JSONStreamCommandDecoder) trimBuf() {
+func (d *jsonStreamReader) trimBuf() {
 	if d.buf != nil && cap(d.buf.B) > maxRetainedLineBuffer {
 		d.buf = nil
 	}
@@ -203,16 +304,15 @@ func commandLen(cmdBytes []byte) int {
 	return len(cmdBytes)
 }
 
-// readLine returns the next `\n` terminated command, including the delimiter.
+// readLine returns the next `\n` terminated message, including the delimiter.
 //
-// The returned slice is only valid until the next Decode call - it may point
-// into the bufio.Reader buffer or into a buffer reused across calls. Callers
-// must copy anything they keep, which json.Parse does since it's used here
-// without the ZeroCopy flag.
-func (d *JSONStreamCommandDecoder) readLine() ([]byte, error) {
+// The returned slice is only valid until the next call - it may point into the
+// bufio.Reader buffer or into a buffer reused across calls. Callers must copy
+// anything they keep, which json.Parse does when used without the ZeroCopy flag.
+func (d *jsonStreamReader) readLine() ([]byte, error) {
 	chunk, err := d.reader.ReadSlice('\n')
 	if err != bufio.ErrBufferFull {
-		// Fast path: the whole command was in the bufio.Reader buffer, so
+		// Fast path: the whole message was in the bufio.Reader buffer, so
 		// there is nothing to accumulate and nothing to allocate.
 		return chunk, err
 	}
@@ -229,9 +329,9 @@ func (d *JSONStreamCommandDecoder) readLine() ([]byte, error) {
 	}
 }
 
-// Reset makes the decoder read from the given reader, applying the given message
+// reset makes the reader read from the given reader, applying the given message
 // size limit.
-func (d *JSONStreamCommandDecoder) Reset(reader io.Reader, messageSizeLimit int64) {
+func (d *jsonStreamReader) reset(reader io.Reader, messageSizeLimit int64) {
 	d.messageSizeLimit = messageSizeLimit
 	if messageSizeLimit > 0 {
 		if d.limitedReader == nil {
@@ -248,12 +348,13 @@ func (d *JSONStreamCommandDecoder) Reset(reader io.Reader, messageSizeLimit int6
 		}
 		d.reader.Reset(reader)
 	}
-	// A single large command must not make a pooled decoder hold on to a large
+	// A single large message must not make a pooled decoder hold on to a large
 	// buffer for the rest of the process lifetime.
 	d.trimBuf()
 	if d.buf != nil {
 		d.buf.B = d.buf.B[:0]
 	}
+	d.index, d.offset, d.start = 0, 0, 0
 }
 
 // ProtobufStreamCommandDecoder is a StreamCommandDecoder which reads commands
@@ -261,6 +362,10 @@ func (d *JSONStreamCommandDecoder) Reset(reader io.Reader, messageSizeLimit int6
 type ProtobufStreamCommandDecoder struct {
 	reader           *bufio.Reader
 	messageSizeLimit int64
+	// index and offset locate the next message in the stream, for DecodeError.
+	index  int
+	offset int64
+	limits DecodeLimits
 }
 
 // NewProtobufStreamCommandDecoder creates a new ProtobufStreamCommandDecoder
@@ -279,19 +384,72 @@ func NewProtobufStreamCommandDecoder(reader io.Reader, messageSizeLimit int64) *
 // interface. The size limit is checked against the length prefix before the
 // command is read, so an oversized command is rejected without buffering it.
 func (d *ProtobufStreamCommandDecoder) Decode() (*Command, int, error) {
-	msgLength, err := binary.ReadUvarint(d.reader)
+	return d.DecodeInto(&Command{})
+}
+
+// DecodeInto is Decode decoding into c rather than into a new Command, see
+// JSONStreamCommandDecoder.DecodeInto.
+func (d *ProtobufStreamCommandDecoder) DecodeInto(c *Command) (*Command, int, error) {
+	*c = Command{}
+	if limitErr := d.limits.checkCommandCount(d.index); limitErr != nil {
+		// Only a command actually there crosses the limit, a stream ending right
+		// at it is not an error.
+		if _, err := d.reader.Peek(1); err != nil {
+			return nil, 0, streamDecodeError(TypeProtobuf, err, d.index, d.offset)
+		}
+		return nil, 0, streamDecodeError(TypeProtobuf, limitErr, d.index, d.offset)
+	}
+	m := limitedCommand{protoType: TypeProtobuf, limits: &d.limits, m: c}
+	msgLength, prefixLength, err := readStreamMessage(d.reader, d.messageSizeLimit, m)
+	if err != nil {
+		return nil, 0, streamDecodeError(TypeProtobuf, err, d.index, d.offset)
+	}
+	d.index++
+	d.offset += int64(prefixLength + msgLength)
+	return c, msgLength + 8, nil
+}
+
+// limitedCommand checks an encoded command against limits before unmarshaling
+// it with m, so readStreamMessage rejects a command crossing one of them without
+// decoding it.
+type limitedCommand struct {
+	protoType Type
+	limits    *DecodeLimits
+	m         vtUnmarshaler
+}
+
+// UnmarshalVT implements vtUnmarshaler.
+func (l limitedCommand) UnmarshalVT(data []byte) error {
+	if err := l.limits.checkEncodedCommand(l.protoType, data); err != nil {
+		return err
+	}
+	return l.m.UnmarshalVT(data)
+}
+
+// vtUnmarshaler is a message generated with vtprotobuf.
+type vtUnmarshaler interface {
+	UnmarshalVT([]byte) error
+}
+
+// readStreamMessage reads the next message prefixed with its length encoded as
+// a varint, and unmarshals it into m. It returns the length of the message and
+// of its prefix. The size limit is checked against the length prefix before the
+// message is read, so an oversized message is rejected without buffering it.
+func readStreamMessage(reader *bufio.Reader, messageSizeLimit int64, m vtUnmarshaler) (int, int, error) {
+	msgLength, err := binary.ReadUvarint(reader)
 	if err != nil {
-		return nil, 0, err
+		return 0, 0, err
 	}
 
-	if d.messageSizeLimit > 0 && msgLength > uint64(d.messageSizeLimit) {
-		return nil, 0, ErrMessageTooLarge
+	if messageSizeLimit > 0 && msgLength > uint64(messageSizeLimit) {
+		return 0, 0, ErrMessageTooLarge
 	}
 	// The length is declared by the other side and is used as an allocation size
 	// below, so it must be bounded even when no explicit limit is configured.
 	if msgLength > maxMessageLength {
-		return nil, 0, ErrMessageTooLarge
+		return 0, 0, ErrMessageTooLarge
 	}
+	prefixLength := (bits.Len64(msgLength|1) + 6) / 7
 
 	// Fast path: the whole message is already buffered, so it can be unmarshaled
 	// straight out of the bufio.Reader without copying it into a scratch buffer
@@ -301,40 +459,44 @@ func (d *ProtobufStreamCommandDecoder) Decode() (*Command, int, error) {
 	// Peek fills the whole buffer before reporting that it cannot hold the
 	// message, which would leave the scratch buffer path below copying those
 	// bytes out instead of reading the body straight into it.
-	if int64(msgLength) <= int64(d.reader.Size()) {
-		if msgBytes, peekErr := d.reader.Peek(int(msgLength)); peekErr == nil {
-			var c Command
-			err = c.UnmarshalVT(msgBytes) // Note, UnmarshalVTUnsafe here will result into issues.
+	if int64(msgLength) <= int64(reader.Size()) {
+		if msgBytes, peekErr := reader.Peek(int(msgLength)); peekErr == nil {
+			err = m.UnmarshalVT(msgBytes) // Note, UnmarshalVTUnsafe here will result into issues.
 			// The message is consumed even when it failed to unmarshal, matching
 			// the scratch buffer path below, which reads it off the stream before
 			// unmarshaling it. A caller which keeps decoding after an error must
 			// see the next message rather than this body again.
-			if _, discardErr := d.reader.Discard(int(msgLength)); discardErr != nil && err == nil {
+			if _, discardErr := reader.Discard(int(msgLength)); discardErr != nil && err == nil {
 				err = discardErr
 			}
 			if err != nil {
-				return nil, 0, err
+				return 0, 0, err
 			}
-			return &c, int(msgLength) + 8, nil
+			return int(msgLength), prefixLength, nil
 		}
 	}
 
 	bb := getByteBuffer(int(msgLength))
 	defer putByteBuffer(bb)
 
-	n, err := io.ReadFull(d.reader, bb.B[:int(msgLength)])
+	n, err := io.ReadFull(reader, bb.B[:int(msgLength)])
 	if err != nil {
-		return nil, 0, err
+		return 0, 0, err
 	}
 	if uint64(n) != msgLength {
-		return nil, 0, io.ErrShortBuffer
+		return 0, 0, io.ErrShortBuffer
 	}
-	var c Command
-	err = c.UnmarshalVT(bb.B[:int(msgLength)]) // Note, UnmarshalVTUnsafe here will result into issues.
+	err = m.UnmarshalVT(bb.B[:int(msgLength)]) // Note, UnmarshalVTUnsafe here will result into issues.
 	if err != nil {
-		return nil, 0, err
+		return 0, 0, err
 	}
-	return &c, int(msgLength) + 8, nil
+	return int(msgLength), prefixLength, nil
+}
+
+// SetLimits makes the decoder enforce the given limits, from the next Decode
+// on. They are kept across Reset.
+func (d *ProtobufStreamCommandDecoder) SetLimits(limits DecodeLimits) {
+	d.limits = limits
 }
 
 // Reset makes the decoder read from the given reader, applying the given message
@@ -342,4 +504,183 @@ func (d *ProtobufStreamCommandDecoder) Decode() (*Command, int, error) {
 func (d *ProtobufStreamCommandDecoder) Reset(reader io.Reader, messageSizeLimit int64) {
 	d.messageSizeLimit = messageSizeLimit
 	d.reader.Reset(reader)
+	d.index, d.offset = 0, 0
+}
+
+// streamDecodeError wraps err into a DecodeError for the message of a length
+// prefixed stream of the given type at the given position, leaving io.EOF as is.
+func streamDecodeError(protoType Type, err error, index int, offset int64) error {
+	if err == io.EOF {
+		return err
+	}
+	return &DecodeError{Type: protoType, Index: index, Offset: offset, Err: err}
+}
+
+var (
+	streamJsonReplyDecoderPool               sync.Pool
+	streamProtobufReplyDecoderPool           sync.Pool
+	streamJsonLengthPrefixedReplyDecoderPool sync.Pool
+)
+
+// GetStreamReplyDecoderLimited returns a StreamReplyDecoder for the given
+// protocol type, taking it from a pool and resetting it to read replies from
+// reader. Return it with PutStreamReplyDecoder once the stream is processed.
+//
+// Replies larger than messageSizeLimit bytes are rejected with
+// ErrMessageTooLarge. The same rules as for GetStreamCommandDecoderLimited
+// apply: messageSizeLimit must be positive, and any type other than TypeJSON and
+// TypeJSONLengthPrefixed is treated as TypeProtobuf.
+func GetStreamReplyDecoderLimited(protoType Type, reader io.Reader, messageSizeLimit int64) StreamReplyDecoder {
+	if messageSizeLimit <= 0 {
+		panic(errNonPositiveMessageSizeLimit)
+	}
+	switch protoType {
+	case TypeJSON:
+		e := streamJsonReplyDecoderPool.Get()
+		if e == nil {
+			return NewJSONStreamReplyDecoder(reader, messageSizeLimit)
+		}
+		replyDecoder := e.(*JSONStreamReplyDecoder)
+		replyDecoder.Reset(reader, messageSizeLimit)
+		return replyDecoder
+	case TypeJSONLengthPrefixed:
+		e := streamJsonLengthPrefixedReplyDecoderPool.Get()
+		if e == nil {
+			return NewJSONLengthPrefixedStreamReplyDecoder(reader, messageSizeLimit)
+		}
+		replyDecoder := e.(*JSONLengthPrefixedStreamReplyDecoder)
+		replyDecoder.Reset(reader, messageSizeLimit)
+		return replyDecoder
+	}
+	e := streamProtobufReplyDecoderPool.Get()
+	if e == nil {
+		return NewProtobufStreamReplyDecoder(reader, messageSizeLimit)
+	}
+	replyDecoder := e.(*ProtobufStreamReplyDecoder)
+	replyDecoder.Reset(reader, messageSizeLimit)
+	return replyDecoder
+}
+
+// PutStreamReplyDecoder returns a StreamReplyDecoder obtained with
+// GetStreamReplyDecoderLimited to the pool. The decoder must not be used after
+// that.
+func PutStreamReplyDecoder(protoType Type, e StreamReplyDecoder) {
+	e.Reset(nil, 0)
+	switch protoType {
+	case TypeJSON:
+		streamJsonReplyDecoderPool.Put(e)
+		return
+	case TypeJSONLengthPrefixed:
+		streamJsonLengthPrefixedReplyDecoderPool.Put(e)
+		return
+	}
+	streamProtobufReplyDecoderPool.Put(e)
+}
+
+// StreamReplyDecoder decodes replies from an io.Reader. It's the client-side
+// counterpart of StreamCommandDecoder, for transports such as HTTP-streaming
+// where replies arrive one after another rather than in frames already read
+// into memory.
+//
+// A StreamReplyDecoder is not safe for concurrent use. Use
+// GetStreamReplyDecoderLimited and PutStreamReplyDecoder to take one from a pool
+// and return it back when done.
+type StreamReplyDecoder interface {
+	// Decode returns the next Reply from the stream together with the number of
+	// bytes it took on the wire, delimiter or length prefix included, or an
+	// error. It returns io.EOF when the stream is over, and a DecodeError
+	// otherwise - wrapping ErrMessageTooLarge if the reply exceeds the
+	// configured message size limit.
+	Decode() (*Reply, int, error)
+	// Reset makes the decoder read from the given reader, applying the given
+	// message size limit. It is used internally to reuse a pooled decoder;
+	// obtain a decoder via GetStreamReplyDecoderLimited, which enforces a
+	// positive limit, rather than resetting one with a non-positive limit.
+	Reset(reader io.Reader, messageSizeLimit int64)
+}
+
+// JSONStreamReplyDecoder is a StreamReplyDecoder which reads replies separated
+// by a `\n` delimiter, as written by JSONDataEncoder.
+type JSONStreamReplyDecoder struct {
+	jsonStreamReader
+}
+
+// NewJSONStreamReplyDecoder creates a new JSONStreamReplyDecoder reading from
+// reader. messageSizeLimit must be positive; a zero or negative value panics.
+func NewJSONStreamReplyDecoder(reader io.Reader, messageSizeLimit int64) *JSONStreamReplyDecoder {
+	if messageSizeLimit <= 0 {
+		panic(errNonPositiveMessageSizeLimit)
+	}
+	return &JSONStreamReplyDecoder{newJSONStreamReader(reader, messageSizeLimit)}
+}
+
+// Decode returns the next Reply from the stream, see the StreamReplyDecoder
+// interface. Unlike JSONStreamCommandDecoder, it never returns a Reply together
+// with io.EOF: the last reply of a stream is returned on its own, and io.EOF on
+// the following call.
+func (d *JSONStreamReplyDecoder) Decode() (*Reply, int, error) {
+	for {
+		replyBytes, err := d.next()
+		if err != nil && (err != io.EOF || len(replyBytes) == 0) {
+			return nil, 0, d.decodeError(err)
+		}
+		if len(bytes.TrimSpace(replyBytes)) == 0 {
+			// Blank lines carry nothing, HTTP-streaming servers may send them
+			// to keep the connection alive.
+			continue
+		}
+		var r Reply
+		if _, parseErr := json.Parse(replyBytes, &r, 0); parseErr != nil {
+			return nil, 0, d.decodeError(parseErr)
+		}
+		d.index++
+		return &r, len(replyBytes), nil
+	}
+}
+
+// Reset makes the decoder read from the given reader, applying the given message
+// size limit.
+func (d *JSONStreamReplyDecoder) Reset(reader io.Reader, messageSizeLimit int64) {
+	d.reset(reader, messageSizeLimit)
+}
+
+// ProtobufStreamReplyDecoder is a StreamReplyDecoder which reads replies prefixed
+// with their length encoded as a varint, as written by ProtobufDataEncoder.
+type ProtobufStreamReplyDecoder struct {
+	reader           *bufio.Reader
+	messageSizeLimit int64
+	index            int
+	offset           int64
+}
+
+// NewProtobufStreamReplyDecoder creates a new ProtobufStreamReplyDecoder reading
+// from reader. messageSizeLimit must be positive; a zero or negative value
+// panics, see NewProtobufStreamCommandDecoder.
+func NewProtobufStreamReplyDecoder(reader io.Reader, messageSizeLimit int64) *ProtobufStreamReplyDecoder {
+	if messageSizeLimit <= 0 {
+		panic(errNonPositiveMessageSizeLimit)
+	}
+	return &ProtobufStreamReplyDecoder{reader: bufio.NewReader(reader), messageSizeLimit: messageSizeLimit}
+}
+
+// Decode returns the next Reply from the stream, see the StreamReplyDecoder
+// interface. The size limit is checked against the length prefix before the
+// reply is read, so an oversized reply is rejected without buffering it.
+func (d *ProtobufStreamReplyDecoder) Decode() (*Reply, int, error) {
+	var r Reply
+	msgLength, prefixLength, err := readStreamMessage(d.reader, d.messageSizeLimit, &r)
+	if err != nil {
+		return nil, 0, streamDecodeError(TypeProtobuf, err, d.index, d.offset)
+	}
+	d.index++
+	d.offset += int64(prefixLength + msgLength)
+	return &r, prefixLength + msgLength, nil
+}
+
+// Reset makes the decoder read from the given reader, applying the given message
+// size limit.
+func (d *ProtobufStreamReplyDecoder) Reset(reader io.Reader, messageSizeLimit int64) {
+	d.messageSizeLimit = messageSizeLimit
+	d.reader.Reset(reader)
+	d.index, d.offset = 0, 0
 }
@@ -492,3 +492,196 @@ func TestStreamingDecode_JSON_DropsOversizedBuffer(t *testing.T) {
 	PutStreamCommandDecoder(TypeJSON, decoder)
 	require.Nil(t, jsonDecoder.buf)
 }
+
+func getTestReplyStream(tb testing.TB, protoType Type, replies ...*Reply) []byte {
+	tb.Helper()
+	encoder := GetDataEncoder(protoType)
+	defer PutDataEncoder(protoType, encoder)
+	replyEncoder := GetReplyEncoder(protoType)
+	for _, r := range replies {
+		data, err := replyEncoder.Encode(r)
+		require.NoError(tb, err)
+		require.NoError(tb, encoder.Encode(data))
+	}
+	return append([]byte(nil), encoder.Finish()...)
+}
+
+func TestStreamReplyDecoder(t *testing.T) {
+	replies := []*Reply{
+		{Id: 1, Connect: &ConnectResult{Client: "c1", Version: "v"}},
+		{Push: &Push{Channel: "news", Pub: &Publication{Data: []byte(`{"title":"hello"}`), Offset: 7}}},
+		{Id: 2, Error: &Error{Code: 100, Message: "internal"}},
+	}
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		t.Run(string(protoType), func(t *testing.T) {
+			stream := getTestReplyStream(t, protoType, replies...)
+			dec := GetStreamReplyDecoderLimited(protoType, bytes.NewReader(stream), 1<<20)
+			defer PutStreamReplyDecoder(protoType, dec)
+			total := 0
+			for i, expected := range replies {
+				r, n, err := dec.Decode()
+				require.NoError(t, err, "reply %d", i)
+				require.Equal(t, expected.Id, r.Id)
+				require.Equal(t, expected.GetConnect().GetClient(), r.GetConnect().GetClient())
+				require.Equal(t, expected.GetPush().GetPub().GetData(), r.GetPush().GetPub().GetData())
+				require.Equal(t, expected.GetError().GetCode(), r.GetError().GetCode())
+				total += n
+			}
+			_, _, err := dec.Decode()
+			require.ErrorIs(t, err, io.EOF)
+			require.Equal(t, len(stream), total, "bytes reported must add up to the stream")
+		})
+	}
+}
+
+func TestStreamReplyDecoder_MessageTooLarge(t *testing.T) {
+	small := &Reply{Push: &Push{Channel: "a"}}
+	large := &Reply{Push: &Push{Channel: strings.Repeat("a", 1000)}}
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		t.Run(string(protoType), func(t *testing.T) {
+			stream := getTestReplyStream(t, protoType, small, large)
+			dec := GetStreamReplyDecoderLimited(protoType, bytes.NewReader(stream), 100)
+			defer PutStreamReplyDecoder(protoType, dec)
+			r, _, err := dec.Decode()
+			require.NoError(t, err)
+			require.Equal(t, "a", r.Push.Channel)
+			_, _, err = dec.Decode()
+			require.ErrorIs(t, err, ErrMessageTooLarge)
+		})
+	}
+}
+
+// A reply is decoded the same whether or not the stream ends with a delimiter,
+// and blank lines between JSON replies are skipped.
+func TestJSONStreamReplyDecoder_Delimiters(t *testing.T) {
+	stream := "\n" + `{"id":1,"rpc":{"data":{}}}` + "\n\n" + `{"id":2,"rpc":{"data":{}}}`
+	dec := NewJSONStreamReplyDecoder(strings.NewReader(stream), 1<<20)
+	r, n, err := dec.Decode()
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), r.Id)
+	require.Equal(t, len(`{"id":1,"rpc":{"data":{}}}`)+1, n)
+	r, n, err = dec.Decode()
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), r.Id)
+	require.Equal(t, len(`{"id":2,"rpc":{"data":{}}}`), n)
+	_, _, err = dec.Decode()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestStreamReplyDecoder_Pooled(t *testing.T) {
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		t.Run(string(protoType), func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				stream := getTestReplyStream(t, protoType, &Reply{Id: uint32(i + 1)})
+				dec := GetStreamReplyDecoderLimited(protoType, bytes.NewReader(stream), 1<<20)
+				r, _, err := dec.Decode()
+				require.NoError(t, err)
+				require.Equal(t, uint32(i+1), r.Id)
+				PutStreamReplyDecoder(protoType, dec)
+			}
+		})
+	}
+}
+
+func TestStreamReplyDecoder_NonPositiveLimitPanics(t *testing.T) {
+	require.PanicsWithValue(t, errNonPositiveMessageSizeLimit, func() {
+		GetStreamReplyDecoderLimited(TypeJSON, strings.NewReader(""), 0)
+	})
+	require.PanicsWithValue(t, errNonPositiveMessageSizeLimit, func() {
+		NewProtobufStreamReplyDecoder(strings.NewReader(""), -1)
+	})
+}
+
+// The Protobuf length prefix is checked before anything is allocated for the
+// body, so a huge declared length costs nothing.
+func TestProtobufStreamReplyDecoder_HugeLengthPrefix(t *testing.T) {
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], math.MaxUint32)
+	dec := NewProtobufStreamReplyDecoder(bytes.NewReader(prefix[:n]), 1<<20)
+	_, _, err := dec.Decode()
+	require.ErrorIs(t, err, ErrMessageTooLarge)
+}
+
+func TestStreamCommandDecoder_DecodeInto(t *testing.T) {
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		t.Run(string(protoType), func(t *testing.T) {
+			frame := getTestFrame(t, protoType, 100)
+			dec := GetStreamCommandDecoderLimited(protoType, bytes.NewReader(frame), 1<<20)
+			defer PutStreamCommandDecoder(protoType, dec)
+			c := AcquireCommand()
+			defer ReleaseCommand(c)
+			decoded := 0
+			for {
+				cmd, n, err := dec.DecodeInto(c)
+				if cmd != nil {
+					require.Same(t, c, cmd)
+					require.Positive(t, n)
+					require.NotNil(t, cmd.Publish)
+					require.Len(t, cmd.Publish.Channel, 100)
+					decoded++
+				}
+				if err != nil {
+					require.ErrorIs(t, err, io.EOF)
+					break
+				}
+			}
+			require.Equal(t, 2, decoded)
+		})
+	}
+}
+
+func TestStreamDecoders_DecodeError(t *testing.T) {
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		t.Run(string(protoType), func(t *testing.T) {
+			frame := getTestFrame(t, protoType, 100)
+			first := bytes.IndexByte(frame, '\n') + 1
+			if protoType == TypeProtobuf {
+				l, n := binary.Uvarint(frame)
+				first = n + int(l)
+			}
+			dec := GetStreamCommandDecoderLimited(protoType, bytes.NewReader(frame), 150)
+			defer PutStreamCommandDecoder(protoType, dec)
+			var err error
+			for err == nil {
+				_, _, err = dec.Decode()
+			}
+			// The regular end of a stream stays a bare io.EOF.
+			require.Equal(t, io.EOF, err)
+
+			dec.Reset(bytes.NewReader(frame), 50)
+			_, _, err = dec.Decode()
+			require.ErrorIs(t, err, ErrMessageTooLarge)
+			requireDecodeError(t, err, protoType, 0, 0)
+
+			// Second message is truncated.
+			dec.Reset(bytes.NewReader(frame[:len(frame)-10]), 150)
+			_, _, err = dec.Decode()
+			require.NoError(t, err)
+			_, _, err = dec.Decode()
+			decodeErr := requireDecodeError(t, err, protoType, 1, int64(first))
+			require.NotErrorIs(t, decodeErr, io.EOF)
+		})
+	}
+}
+
+func TestStreamReplyDecoder_DecodeError(t *testing.T) {
+	stream := "{\"id\":1}\n{\"id\":\n"
+	dec := NewJSONStreamReplyDecoder(strings.NewReader(stream), 1<<20)
+	_, _, err := dec.Decode()
+	require.NoError(t, err)
+	_, _, err = dec.Decode()
+	requireDecodeError(t, err, TypeJSON, 1, int64(len("{\"id\":1}\n")))
+
+	data, err := NewProtobufReplyEncoder().Encode(&Reply{Id: 1})
+	require.NoError(t, err)
+	encoder := NewProtobufDataEncoder()
+	require.NoError(t, encoder.Encode(data))
+	require.NoError(t, encoder.Encode(data))
+	frame := encoder.Finish()
+	pbDec := NewProtobufStreamReplyDecoder(bytes.NewReader(frame[:len(frame)-1]), 1<<20)
+	_, n, err := pbDec.Decode()
+	require.NoError(t, err)
+	_, _, err = pbDec.Decode()
+	requireDecodeError(t, err, TypeProtobuf, 1, int64(n))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
@@ -1,10 +1,14 @@
 package protocol
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 )
 
 func readCommands(t testing.TB, decoder CommandDecoder) []*Command {
@@ -105,7 +109,7 @@ func TestJSONCommandDecoder_Decode_Many_UnexpectedEOF(t *testing.T) {
 	data := []byte(``)
 	decoder := GetCommandDecoder(TypeJSON, data)
 	_, err := decoder.Decode()
-	require.Equal(t, io.ErrUnexpectedEOF, err)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
 }
 
 func TestJSONCommandDecoder_Decode_Many_FormatError(t *testing.T) {
@@ -220,3 +224,372 @@ func TestProtobufReplyDecoder_Decode_Malformed(t *testing.T) {
 		})
 	}
 }
+
+func TestJSONReplyDecoder_Decode_Many(t *testing.T) {
+	encoder := NewJSONDataEncoder()
+	replyEncoder := NewJSONReplyEncoder()
+	for _, id := range []uint32{1, 2, 3} {
+		replyData, err := replyEncoder.Encode(&Reply{Id: id, Rpc: &RPCResult{Data: []byte(`{"n":1}`)}})
+		require.NoError(t, err)
+		require.NoError(t, encoder.Encode(replyData))
+	}
+
+	decoder := GetReplyDecoder(TypeJSON, encoder.Finish())
+	defer PutReplyDecoder(TypeJSON, decoder)
+	var replies []*Reply
+	for {
+		reply, err := decoder.Decode()
+		if err != nil {
+			require.ErrorIs(t, err, io.EOF)
+			require.Nil(t, reply)
+			break
+		}
+		replies = append(replies, reply)
+	}
+	require.Len(t, replies, 3)
+	for i, reply := range replies {
+		require.Equal(t, uint32(i+1), reply.Id)
+		require.Equal(t, Raw(`{"n":1}`), reply.Rpc.Data)
+	}
+}
+
+func TestJSONReplyDecoder_Decode_EmptyLines(t *testing.T) {
+	decoder := NewJSONReplyDecoder([]byte("\n{\"id\":1}\n\n{\"id\":2}\n"))
+	reply, err := decoder.Decode()
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), reply.Id)
+	reply, err = decoder.Decode()
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), reply.Id)
+	_, err = decoder.Decode()
+	require.ErrorIs(t, err, io.EOF)
+
+	require.NoError(t, decoder.Reset(nil))
+	_, err = decoder.Decode()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestJSONReplyDecoder_Decode_Malformed(t *testing.T) {
+	decoder := NewJSONReplyDecoder([]byte("{\"id\":1}\n{\"id\":"))
+	_, err := decoder.Decode()
+	require.NoError(t, err)
+	_, err = decoder.Decode()
+	require.Error(t, err)
+	require.NotErrorIs(t, err, io.EOF)
+}
+
+// Strings of a decoded reply point into the frame, while Raw payloads are
+// copies - this is the lifetime contract JSONReplyDecoder documents.
+func TestJSONReplyDecoder_ZeroCopy(t *testing.T) {
+	frame := []byte(`{"push":{"channel":"news","pub":{"data":{"v":1}}}}`)
+	decoder := NewJSONReplyDecoder(frame)
+	reply, err := decoder.Decode()
+	require.NoError(t, err)
+	require.Equal(t, "news", reply.Push.Channel)
+
+	copy(frame, bytes.Repeat([]byte("x"), len(frame)))
+	require.Equal(t, "xxxx", reply.Push.Channel, "channel must alias the frame")
+	require.Equal(t, Raw(`{"v":1}`), reply.Push.Pub.Data, "raw payload must be a copy")
+}
+
+func TestReplyDecoder_Pool(t *testing.T) {
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		t.Run(string(protoType), func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				data, err := GetReplyEncoder(protoType).Encode(&Reply{Id: uint32(i + 1)})
+				require.NoError(t, err)
+				encoder := GetDataEncoder(protoType)
+				require.NoError(t, encoder.Encode(data))
+				frame := encoder.Finish()
+				PutDataEncoder(protoType, encoder)
+
+				decoder := GetReplyDecoder(protoType, frame)
+				reply, err := decoder.Decode()
+				require.NoError(t, err)
+				require.Equal(t, uint32(i+1), reply.Id)
+				_, err = decoder.Decode()
+				require.ErrorIs(t, err, io.EOF)
+				PutReplyDecoder(protoType, decoder)
+			}
+		})
+	}
+}
+
+// fillMessage sets every exported field reachable from v: Raw payloads to a
+// distinct non-empty value, strings to a non-empty value, messages, lists and
+// maps to one element each. Recursive messages stop at depth.
+func fillMessage(v reflect.Value, depth int, n *int) {
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Type().Field(i).IsExported() {
+			continue
+		}
+		fillValue(v.Field(i), depth, n)
+	}
+}
+
+func fillValue(f reflect.Value, depth int, n *int) {
+	*n++
+	switch {
+	case f.Type() == reflect.TypeOf(Raw(nil)):
+		f.Set(reflect.ValueOf(Raw(fmt.Sprintf(`{"payload":%d}`, *n))))
+	case f.Kind() == reflect.String:
+		f.SetString(fmt.Sprintf("s%d", *n))
+	case f.Kind() == reflect.Pointer && f.Type().Elem().Kind() == reflect.Struct:
+		if depth == 0 {
+			return
+		}
+		f.Set(reflect.New(f.Type().Elem()))
+		fillMessage(f.Elem(), depth-1, n)
+	case f.Kind() == reflect.Slice && f.Type().Elem().Kind() != reflect.Uint8:
+		s := reflect.MakeSlice(f.Type(), 1, 1)
+		fillValue(s.Index(0), depth, n)
+		if s.Index(0).Kind() == reflect.Pointer && s.Index(0).IsNil() {
+			return
+		}
+		f.Set(s)
+	case f.Kind() == reflect.Map:
+		m := reflect.MakeMap(f.Type())
+		k := reflect.New(f.Type().Key()).Elem()
+		e := reflect.New(f.Type().Elem()).Elem()
+		fillValue(k, depth, n)
+		fillValue(e, depth, n)
+		m.SetMapIndex(k, e)
+		f.Set(m)
+	}
+}
+
+// collectRaw returns every non-empty Raw payload reachable from v.
+func collectRaw(v reflect.Value, out *[]Raw) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			collectRaw(v.Elem(), out)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				collectRaw(v.Field(i), out)
+			}
+		}
+	case reflect.Slice:
+		if v.Type() == reflect.TypeOf(Raw(nil)) {
+			if v.Len() > 0 {
+				*out = append(*out, v.Interface().(Raw))
+			}
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			collectRaw(v.Index(i), out)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			collectRaw(iter.Value(), out)
+		}
+	}
+}
+
+func encodeCommandFrame(t *testing.T, cmds ...*Command) []byte {
+	t.Helper()
+	encoder := NewProtobufDataEncoder()
+	for _, cmd := range cmds {
+		data, err := cmd.MarshalVT()
+		require.NoError(t, err)
+		require.NoError(t, encoder.Encode(data))
+	}
+	return append([]byte(nil), encoder.Finish()...)
+}
+
+func TestProtobufCommandDecoderUnsafe_SameAsSafe(t *testing.T) {
+	var cmd Command
+	n := 0
+	fillMessage(reflect.ValueOf(&cmd).Elem(), 3, &n)
+	frame := encodeCommandFrame(t, &cmd, &Command{Id: 2, Publish: &PublishRequest{Channel: "ch", Data: []byte(`{}`)}})
+
+	safe := readCommands(t, NewProtobufCommandDecoder(frame))
+	unsafe := readCommands(t, NewProtobufCommandDecoderUnsafe(frame))
+	require.Len(t, unsafe, 2)
+	for i := range safe {
+		require.True(t, proto.Equal(safe[i], unsafe[i]), "command %d", i)
+	}
+}
+
+// Every Raw payload of a command decoded in place must survive the frame buffer
+// being reused, as with JSONCommandDecoder. The command has every field set, so
+// a Raw field added to a request type later and not detached fails here.
+func TestProtobufCommandDecoderUnsafe_RawSurvivesBufferReuse(t *testing.T) {
+	var cmd Command
+	n := 0
+	fillMessage(reflect.ValueOf(&cmd).Elem(), 3, &n)
+	var expected []Raw
+	collectRaw(reflect.ValueOf(&cmd), &expected)
+	require.NotEmpty(t, expected)
+
+	frame := encodeCommandFrame(t, &cmd)
+	decoded := readCommands(t, NewProtobufCommandDecoderUnsafe(frame))
+	require.Len(t, decoded, 1)
+
+	// Reuse the read buffer for the next frame.
+	for i := range frame {
+		frame[i] = 0xff
+	}
+	var got []Raw
+	collectRaw(reflect.ValueOf(decoded[0]), &got)
+	require.ElementsMatch(t, expected, got)
+}
+
+// Strings do alias the frame, which is the point of decoding in place.
+func TestProtobufCommandDecoderUnsafe_StringsAliasFrame(t *testing.T) {
+	frame := encodeCommandFrame(t, &Command{Id: 1, Publish: &PublishRequest{Channel: "news", Data: []byte(`{"v":1}`)}})
+	decoded := readCommands(t, NewProtobufCommandDecoderUnsafe(frame))
+	require.Len(t, decoded, 1)
+	idx := bytes.Index(frame, []byte("news"))
+	require.GreaterOrEqual(t, idx, 0)
+	copy(frame[idx:], "xxxx")
+	require.Equal(t, "xxxx", decoded[0].Publish.Channel)
+	require.Equal(t, Raw(`{"v":1}`), decoded[0].Publish.Data)
+}
+
+// Decoders put back into the pool must not hand out in place decoding to
+// GetCommandDecoder callers which never asked for it.
+func TestProtobufCommandDecoderUnsafe_PoolCopies(t *testing.T) {
+	frame := encodeCommandFrame(t, &Command{Id: 1, Publish: &PublishRequest{Channel: "news"}})
+	PutCommandDecoder(TypeProtobuf, NewProtobufCommandDecoderUnsafe(nil))
+	for i := 0; i < 10; i++ {
+		decoder := GetCommandDecoder(TypeProtobuf, frame)
+		require.False(t, decoder.(*ProtobufCommandDecoder).unsafe)
+		PutCommandDecoder(TypeProtobuf, decoder)
+	}
+}
+
+func TestCommandDecoder_DecodeInto(t *testing.T) {
+	cmds := []*Command{
+		{Id: 1, Publish: &PublishRequest{Channel: "a", Data: []byte(`{}`)}},
+		{Id: 2, Subscribe: &SubscribeRequest{Channel: "b"}},
+		{Id: 3, Rpc: &RPCRequest{Method: "m", Data: []byte(`[]`)}},
+	}
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		t.Run(string(protoType), func(t *testing.T) {
+			encoder := GetDataEncoder(protoType)
+			for _, cmd := range cmds {
+				data, err := cmd.MarshalVT()
+				if protoType == TypeJSON {
+					data, err = NewJSONCommandEncoder().Encode(cmd)
+				}
+				require.NoError(t, err)
+				require.NoError(t, encoder.Encode(data))
+			}
+			frame := encoder.Finish()
+			PutDataEncoder(protoType, encoder)
+
+			decoder := GetCommandDecoder(protoType, frame)
+			defer PutCommandDecoder(protoType, decoder)
+			c := AcquireCommand()
+			defer ReleaseCommand(c)
+			for i, expected := range cmds {
+				cmd, err := decoder.DecodeInto(c)
+				if i == len(cmds)-1 {
+					require.ErrorIs(t, err, io.EOF)
+				} else {
+					require.NoError(t, err)
+				}
+				require.Same(t, c, cmd)
+				require.Equal(t, expected.Id, cmd.Id)
+				// Requests of the previous command must not be left over.
+				require.Equal(t, expected.Publish != nil, cmd.Publish != nil)
+				require.Equal(t, expected.Subscribe != nil, cmd.Subscribe != nil)
+				require.Equal(t, expected.Rpc != nil, cmd.Rpc != nil)
+			}
+		})
+	}
+}
+
+func TestCommandDecoder_DecodeIntoNoCommand(t *testing.T) {
+	c := AcquireCommand()
+	defer ReleaseCommand(c)
+	cmd, err := NewProtobufCommandDecoder(nil).DecodeInto(c)
+	require.ErrorIs(t, err, io.EOF)
+	require.Nil(t, cmd)
+	cmd, err = NewJSONCommandDecoder(nil).DecodeInto(c)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Nil(t, cmd)
+}
+
+func TestCommandDecoder_DecodeIntoAllocs(t *testing.T) {
+	frame := []byte(`{"id":1,"ping":{}}`)
+	decoder := NewJSONCommandDecoder(frame)
+	c := &Command{}
+	decodeAllocs := testing.AllocsPerRun(100, func() {
+		_ = decoder.Reset(frame)
+		_, _ = decoder.Decode()
+	})
+	intoAllocs := testing.AllocsPerRun(100, func() {
+		_ = decoder.Reset(frame)
+		_, _ = decoder.DecodeInto(c)
+	})
+	require.Less(t, intoAllocs, decodeAllocs)
+}
+
+func TestDecodeError(t *testing.T) {
+	err := &DecodeError{Type: TypeProtobuf, Index: 2, Offset: 17, Err: io.ErrShortBuffer}
+	require.Equal(t, "centrifugal: decode protobuf message 2 at offset 17: short buffer", err.Error())
+	require.ErrorIs(t, err, io.ErrShortBuffer)
+	require.NotErrorIs(t, err, io.EOF)
+}
+
+func requireDecodeError(t *testing.T, err error, protoType Type, index int, offset int64) *DecodeError {
+	t.Helper()
+	var decodeErr *DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	require.Equal(t, protoType, decodeErr.Type)
+	require.Equal(t, index, decodeErr.Index)
+	require.Equal(t, offset, decodeErr.Offset)
+	return decodeErr
+}
+
+func TestCommandDecoder_DecodeError(t *testing.T) {
+	first := `{"id":1,"ping":{}}`
+	decoder := NewJSONCommandDecoder([]byte(first + "\n{\"id\":\n{\"id\":3}"))
+	_, err := decoder.Decode()
+	require.NoError(t, err)
+	_, err = decoder.Decode()
+	requireDecodeError(t, err, TypeJSON, 1, int64(len(first)+1))
+
+	_, err = NewJSONCommandDecoder(nil).Decode()
+	requireDecodeError(t, err, TypeJSON, 0, 0)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	data, err := NewProtobufCommandEncoder().Encode(&Command{Id: 1})
+	require.NoError(t, err)
+	frame := append(data, 0x10, 0x01)
+	pbDecoder := NewProtobufCommandDecoder(frame)
+	_, err = pbDecoder.Decode()
+	require.NoError(t, err)
+	_, err = pbDecoder.Decode()
+	requireDecodeError(t, err, TypeProtobuf, 1, int64(len(data)))
+	require.ErrorIs(t, err, io.ErrShortBuffer)
+}
+
+func TestReplyDecoder_DecodeError(t *testing.T) {
+	first := `{"id":1}`
+	decoder := NewJSONReplyDecoder([]byte(first + "\n\n[1,2"))
+	_, err := decoder.Decode()
+	require.NoError(t, err)
+	_, err = decoder.Decode()
+	requireDecodeError(t, err, TypeJSON, 1, int64(len(first)+2))
+
+	data, err := NewProtobufReplyEncoder().Encode(&Reply{Id: 1})
+	require.NoError(t, err)
+	encoder := NewProtobufDataEncoder()
+	require.NoError(t, encoder.Encode(data))
+	frame := append(encoder.Finish(), 0x03, 0xff, 0xff, 0xff)
+	pbDecoder := NewProtobufReplyDecoder(frame)
+	_, err = pbDecoder.Decode()
+	require.NoError(t, err)
+	_, err = pbDecoder.Decode()
+	requireDecodeError(t, err, TypeProtobuf, 1, int64(len(frame)-4))
+
+	// The regular end of a frame stays a bare io.EOF.
+	_, err = NewProtobufReplyDecoder(nil).Decode()
+	require.Equal(t, io.EOF, err)
+}
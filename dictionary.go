@@ -0,0 +1,139 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// dictionaryIDSize is how many bytes of the SHA-256 of dictionary content make
+// up its id, see Dictionary.id.
+const dictionaryIDSize = 12
+
+// ErrDictionaryMismatch is returned when dictionary content does not hash to the
+// id it was presented with. For a client this means cached bytes were altered
+// and must be discarded rather than installed, see Dictionary.id for why that
+// matters.
+var ErrDictionaryMismatch = errors.New("centrifugal: dictionary content does not match its id")
+
+// DictionaryID derives the id of dictionary content as fixed by Dictionary.id:
+// the first 12 bytes of its SHA-256, base64url encoded without padding.
+//
+// A server must name its dictionaries with this and nothing else - a client
+// checks cached content against the id before reusing it, and an id derived any
+// other way makes every caching client silently stop caching.
+func DictionaryID(content []byte) string {
+	sum := sha256.Sum256(content)
+	return base64.RawURLEncoding.EncodeToString(sum[:dictionaryIDSize])
+}
+
+// VerifyDictionary checks that content is the dictionary named by id, returning
+// ErrDictionaryMismatch if it is not. A client must call it on cached content
+// before installing it.
+func VerifyDictionary(id string, content []byte) error {
+	if DictionaryID(content) != id {
+		return ErrDictionaryMismatch
+	}
+	return nil
+}
+
+// NewVerifiedDeflateFrameCodec builds a DeflateFrameCodec like
+// NewDeflateFrameCodec does, but first verifies dict against id and refuses to
+// build a codec from content the id does not name. Prefer it over
+// NewDeflateFrameCodec whenever the content comes from anywhere but this process
+// - a client cache, a shared store, another node.
+func NewVerifiedDeflateFrameCodec(id string, dict []byte) (*DeflateFrameCodec, error) {
+	if err := VerifyDictionary(id, dict); err != nil {
+		return nil, err
+	}
+	return NewDeflateFrameCodec(id, dict), nil
+}
+
+var (
+	// ErrDictionaryNotCached is returned by ResolveDictionary when the server
+	// named a dictionary by id only, but the client does not hold it.
+	ErrDictionaryNotCached = errors.New("centrifugal: dictionary not cached")
+	// ErrInvalidDictionary is returned by ResolveDictionary for a Dictionary
+	// message which breaks the rules documented on it.
+	ErrInvalidDictionary = errors.New("centrifugal: invalid dictionary message")
+)
+
+// NewDictionary builds the Dictionary message announcing c to a connection of
+// the given protocol type, for ConnectResult.dict.
+//
+// clientDict is the id the client advertised in ConnectRequest.dict. When it
+// names c the message carries the id only, since the client holds the bytes.
+// Otherwise it carries the content, deflated, in data on Protobuf connections
+// and base64 encoded in data_b64 on JSON ones. The deflated content is computed
+// once per codec and shared, so building the message for every connect is cheap.
+// TypeJSONLengthPrefixed is treated as TypeJSON, and any other type as
+// TypeProtobuf.
+func NewDictionary(protoType Type, c *DeflateFrameCodec, clientDict string) *Dictionary {
+	d := &Dictionary{Id: c.ID()}
+	if clientDict != "" && clientDict == c.ID() {
+		return d
+	}
+	if isJSON(protoType) {
+		d.DataB64 = base64.StdEncoding.EncodeToString(c.deflatedDict())
+	} else {
+		d.Data = c.deflatedDict()
+	}
+	return d
+}
+
+// DictionaryCache is a client-side store of dictionary content by id, which
+// lets a reconnecting client skip the dictionary transfer. Content read from it
+// is always verified against its id before use, so an implementation may keep
+// it anywhere, including storage other code can write to.
+type DictionaryCache interface {
+	// Get returns the content cached for id, if any.
+	Get(id string) ([]byte, bool)
+	// Set stores content for id.
+	Set(id string, content []byte)
+}
+
+// ResolveDictionary turns a Dictionary received in ConnectResult.dict into a
+// codec ready to decompress the frames which follow it.
+//
+// Content carried in the message is inflated with InflateDictionary, bounded by
+// maxSize, verified against the id and stored in cache. A message carrying the
+// id only is resolved from cache, and ErrDictionaryNotCached is returned on a
+// miss. Cached content which does not match its id yields ErrDictionaryMismatch
+// and must not be reused. cache may be nil for a client which does not cache.
+func ResolveDictionary(d *Dictionary, cache DictionaryCache, maxSize int) (*DeflateFrameCodec, error) {
+	if d == nil || d.Id == "" || (len(d.Data) > 0 && d.DataB64 != "") {
+		return nil, ErrInvalidDictionary
+	}
+	var data []byte
+	switch {
+	case len(d.Data) > 0:
+		data = d.Data
+	case d.DataB64 != "":
+		var err error
+		data, err = base64.StdEncoding.DecodeString(d.DataB64)
+		if err != nil {
+			return nil, ErrInvalidDictionary
+		}
+	default:
+		if cache == nil {
+			return nil, ErrDictionaryNotCached
+		}
+		content, ok := cache.Get(d.Id)
+		if !ok {
+			return nil, ErrDictionaryNotCached
+		}
+		return NewVerifiedDeflateFrameCodec(d.Id, content)
+	}
+	content, err := InflateDictionary(data, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewVerifiedDeflateFrameCodec(d.Id, content)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		cache.Set(d.Id, content)
+	}
+	return c, nil
+}
@@ -0,0 +1,226 @@
+package protocol
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DictionaryRegistry maps connection profiles, see ConnectRequest.profile, to
+// the DeflateFrameCodec currently active for each, and keeps superseded codecs
+// around while clients still hold them.
+//
+// Rotating a dictionary does not invalidate the one it replaces straight away.
+// Clients connected with it keep using it, and clients which cached it reconnect
+// advertising it in ConnectRequest.dict - typically all at once, during the
+// rolling deploy which introduced the new dictionary. Serving them the dictionary
+// they already hold costs nothing, while switching them costs a full transfer
+// each. So a superseded codec stays available by id for a grace period, bounded
+// by an LRU so that frequent rotations cannot pile up codecs, each of which
+// retains its pooled DEFLATE state.
+//
+// A DictionaryRegistry is safe for concurrent use.
+type DictionaryRegistry struct {
+	mu          sync.Mutex
+	grace       time.Duration
+	maxRetained int
+	// profiles maps a profile to the id of its active codec.
+	profiles map[string]string
+	// active holds the codecs some profile currently uses, by id.
+	active map[string]*activeDictionary
+	// retained holds superseded codecs by id, as elements of lru.
+	retained map[string]*list.Element
+	// lru orders retained codecs from the most to the least recently used.
+	lru *list.List
+	// now is time.Now, swapped in tests.
+	now func() time.Time
+}
+
+type activeDictionary struct {
+	codec *DeflateFrameCodec
+	// users is the set of profiles using the codec, a codec may be shared.
+	users map[string]struct{}
+	// profiles is the set of profiles the codec has been active for, including
+	// those which moved on to another codec since.
+	profiles map[string]struct{}
+}
+
+type retainedDictionary struct {
+	codec    *DeflateFrameCodec
+	profiles map[string]struct{}
+	expires  time.Time
+}
+
+// NewDictionaryRegistry creates an empty DictionaryRegistry. Superseded codecs
+// stay available for grace after being replaced, and at most maxRetained of
+// them are kept at a time, evicting the least recently used. A zero grace or
+// maxRetained drops superseded codecs immediately.
+func NewDictionaryRegistry(grace time.Duration, maxRetained int) *DictionaryRegistry {
+	return &DictionaryRegistry{
+		grace:       grace,
+		maxRetained: maxRetained,
+		profiles:    make(map[string]string),
+		active:      make(map[string]*activeDictionary),
+		retained:    make(map[string]*list.Element),
+		lru:         list.New(),
+		now:         time.Now,
+	}
+}
+
+// Set makes c the active codec of profile. The codec it replaces, if no other
+// profile uses it, is retained for the grace period.
+func (r *DictionaryRegistry) Set(profile string, c *DeflateFrameCodec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prev, ok := r.profiles[profile]; ok {
+		if prev == c.ID() {
+			return
+		}
+		r.deactivate(profile, prev)
+	}
+	r.profiles[profile] = c.ID()
+	if a, ok := r.active[c.ID()]; ok {
+		a.users[profile] = struct{}{}
+		a.profiles[profile] = struct{}{}
+		return
+	}
+	a := &activeDictionary{codec: c, users: map[string]struct{}{profile: {}}, profiles: map[string]struct{}{profile: {}}}
+	if el, ok := r.retained[c.ID()]; ok {
+		// Rolled back to a dictionary still retained, keep its existing codec
+		// so that its pooled state is not thrown away.
+		rd := el.Value.(*retainedDictionary)
+		a.codec = rd.codec
+		for p := range rd.profiles {
+			a.profiles[p] = struct{}{}
+		}
+		r.lru.Remove(el)
+		delete(r.retained, c.ID())
+	}
+	r.active[c.ID()] = a
+}
+
+// Remove drops the active codec of profile, retaining it like Set does.
+func (r *DictionaryRegistry) Remove(profile string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prev, ok := r.profiles[profile]; ok {
+		delete(r.profiles, profile)
+		r.deactivate(profile, prev)
+	}
+}
+
+// deactivate detaches profile from the active codec id, moving the codec to the
+// retained set once no profile uses it anymore.
+func (r *DictionaryRegistry) deactivate(profile string, id string) {
+	a := r.active[id]
+	delete(a.users, profile)
+	if len(a.users) > 0 {
+		return
+	}
+	delete(r.active, id)
+	if r.grace <= 0 || r.maxRetained <= 0 {
+		return
+	}
+	// The profiles are remembered so that Codec serves a retained codec only to
+	// the profiles it was active for.
+	rd := &retainedDictionary{
+		codec:    a.codec,
+		profiles: a.profiles,
+		expires:  r.now().Add(r.grace),
+	}
+	r.retained[id] = r.lru.PushFront(rd)
+	for r.lru.Len() > r.maxRetained {
+		r.evict(r.lru.Back())
+	}
+}
+
+func (r *DictionaryRegistry) evict(el *list.Element) {
+	r.lru.Remove(el)
+	delete(r.retained, el.Value.(*retainedDictionary).codec.ID())
+}
+
+// Active returns the active codec of profile.
+func (r *DictionaryRegistry) Active(profile string) (*DeflateFrameCodec, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id, ok := r.profiles[profile]
+	if !ok {
+		return nil, false
+	}
+	return r.active[id].codec, true
+}
+
+// Lookup returns the codec with the given dictionary id, whether it is active
+// for some profile or superseded and still within its grace period.
+func (r *DictionaryRegistry) Lookup(id string) (*DeflateFrameCodec, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if a, ok := r.active[id]; ok {
+		return a.codec, true
+	}
+	rd, ok := r.lookupRetained(id)
+	if !ok {
+		return nil, false
+	}
+	return rd.codec, true
+}
+
+// lookupRetained returns a retained codec which has not expired, marking it as
+// recently used.
+func (r *DictionaryRegistry) lookupRetained(id string) (*retainedDictionary, bool) {
+	el, ok := r.retained[id]
+	if !ok {
+		return nil, false
+	}
+	rd := el.Value.(*retainedDictionary)
+	if !r.now().Before(rd.expires) {
+		r.evict(el)
+		return nil, false
+	}
+	r.lru.MoveToFront(el)
+	return rd, true
+}
+
+// Codec returns the codec to use for a new connection of profile, which
+// advertised clientDict in ConnectRequest.dict.
+//
+// That is the dictionary the client already holds when it is the one active for
+// profile or was until recently - so the connection needs no transfer - and the
+// active codec of profile otherwise. A dictionary of a different profile is not
+// served even when the client holds it: ConnectRequest.profile is untrusted, and
+// a dictionary is only good for the traffic it was trained on.
+func (r *DictionaryRegistry) Codec(profile string, clientDict string) (*DeflateFrameCodec, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id, ok := r.profiles[profile]
+	if !ok {
+		return nil, false
+	}
+	if clientDict != "" && clientDict != id {
+		if a, ok := r.active[clientDict]; ok {
+			if _, ok := a.profiles[profile]; ok {
+				return a.codec, true
+			}
+		} else if rd, ok := r.lookupRetained(clientDict); ok {
+			if _, ok := rd.profiles[profile]; ok {
+				return rd.codec, true
+			}
+		}
+	}
+	return r.active[id].codec, true
+}
+
+// Prune drops retained codecs whose grace period is over. Expired codecs are
+// never returned either way, this only releases them sooner than eviction would.
+func (r *DictionaryRegistry) Prune() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	for el := r.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if !now.Before(el.Value.(*retainedDictionary).expires) {
+			r.evict(el)
+		}
+		el = prev
+	}
+}
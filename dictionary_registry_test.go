@@ -0,0 +1,184 @@
+package protocol
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testRegistryCodec(version int) *DeflateFrameCodec {
+	content := []byte(fmt.Sprintf(`{"push":{"channel":"","pub":{"data":{"v%d":`, version))
+	return NewDeflateFrameCodec(DictionaryID(content), content)
+}
+
+func newTestDictionaryRegistry(grace time.Duration, maxRetained int) (*DictionaryRegistry, *time.Time) {
+	r := NewDictionaryRegistry(grace, maxRetained)
+	now := time.Unix(1700000000, 0)
+	r.now = func() time.Time { return now }
+	return r, &now
+}
+
+func TestDictionaryRegistry_Rotation(t *testing.T) {
+	r, now := newTestDictionaryRegistry(time.Minute, 10)
+	v1, v2 := testRegistryCodec(1), testRegistryCodec(2)
+
+	_, ok := r.Active("chat")
+	require.False(t, ok)
+	_, ok = r.Codec("chat", "")
+	require.False(t, ok)
+
+	r.Set("chat", v1)
+	c, ok := r.Active("chat")
+	require.True(t, ok)
+	require.Same(t, v1, c)
+
+	r.Set("chat", v2)
+	c, _ = r.Active("chat")
+	require.Same(t, v2, c)
+
+	// A client holding the superseded dictionary keeps it during the grace
+	// period, a fresh one gets the new dictionary.
+	c, _ = r.Codec("chat", v1.ID())
+	require.Same(t, v1, c)
+	c, _ = r.Codec("chat", "")
+	require.Same(t, v2, c)
+	c, _ = r.Codec("chat", "unknown")
+	require.Same(t, v2, c)
+	c, ok = r.Lookup(v1.ID())
+	require.True(t, ok)
+	require.Same(t, v1, c)
+
+	*now = now.Add(time.Minute)
+	_, ok = r.Lookup(v1.ID())
+	require.False(t, ok)
+	c, _ = r.Codec("chat", v1.ID())
+	require.Same(t, v2, c)
+}
+
+// Profile is untrusted, so a dictionary is only served to the profile it was
+// built for.
+func TestDictionaryRegistry_CodecStaysWithinProfile(t *testing.T) {
+	r, _ := newTestDictionaryRegistry(time.Minute, 10)
+	v1, v2, v3 := testRegistryCodec(1), testRegistryCodec(2), testRegistryCodec(3)
+	r.Set("chat", v1)
+	r.Set("chat", v2)
+	r.Set("feed", v3)
+
+	c, _ := r.Codec("feed", v1.ID())
+	require.Same(t, v3, c)
+	c, _ = r.Codec("feed", v2.ID())
+	require.Same(t, v3, c)
+	c, _ = r.Codec("chat", v3.ID())
+	require.Same(t, v2, c)
+}
+
+func TestDictionaryRegistry_SharedCodec(t *testing.T) {
+	r, _ := newTestDictionaryRegistry(time.Minute, 10)
+	v1, v2 := testRegistryCodec(1), testRegistryCodec(2)
+	r.Set("chat", v1)
+	r.Set("feed", v1)
+	r.Set("chat", v2)
+
+	// Still active for feed.
+	c, ok := r.Active("feed")
+	require.True(t, ok)
+	require.Same(t, v1, c)
+	// And still good for chat clients which hold it.
+	c, _ = r.Codec("chat", v1.ID())
+	require.Same(t, v1, c)
+
+	r.Remove("feed")
+	_, ok = r.Active("feed")
+	require.False(t, ok)
+	c, _ = r.Codec("chat", v1.ID())
+	require.Same(t, v1, c)
+}
+
+func TestDictionaryRegistry_RollBack(t *testing.T) {
+	r, _ := newTestDictionaryRegistry(time.Minute, 10)
+	v1, v2 := testRegistryCodec(1), testRegistryCodec(2)
+	r.Set("chat", v1)
+	r.Set("chat", v2)
+	// Rolling back reuses the retained codec rather than the equal new one.
+	r.Set("chat", testRegistryCodec(1))
+	c, _ := r.Active("chat")
+	require.Same(t, v1, c)
+	c, _ = r.Codec("chat", v2.ID())
+	require.Same(t, v2, c)
+}
+
+func TestDictionaryRegistry_LRU(t *testing.T) {
+	r, _ := newTestDictionaryRegistry(time.Hour, 2)
+	codecs := make([]*DeflateFrameCodec, 5)
+	for i := range codecs {
+		codecs[i] = testRegistryCodec(i)
+		r.Set("chat", codecs[i])
+		if i == 2 {
+			// Keep v0 in use, so v1 is the least recently used one.
+			_, ok := r.Lookup(codecs[0].ID())
+			require.True(t, ok)
+		}
+	}
+	// v4 is active, v3 and one of the older ones are retained.
+	_, ok := r.Lookup(codecs[3].ID())
+	require.True(t, ok)
+	_, ok = r.Lookup(codecs[1].ID())
+	require.False(t, ok)
+	_, ok = r.Lookup(codecs[2].ID())
+	require.True(t, ok)
+	_, ok = r.Lookup(codecs[0].ID())
+	require.False(t, ok)
+}
+
+func TestDictionaryRegistry_NoGrace(t *testing.T) {
+	r, _ := newTestDictionaryRegistry(0, 10)
+	v1 := testRegistryCodec(1)
+	r.Set("chat", v1)
+	r.Set("chat", testRegistryCodec(2))
+	_, ok := r.Lookup(v1.ID())
+	require.False(t, ok)
+}
+
+func TestDictionaryRegistry_Prune(t *testing.T) {
+	r, now := newTestDictionaryRegistry(time.Minute, 10)
+	for i := 0; i < 4; i++ {
+		r.Set("chat", testRegistryCodec(i))
+		*now = now.Add(20 * time.Second)
+	}
+	r.Prune()
+	// v0 superseded 60s ago, v1 40s ago, v2 20s ago.
+	require.Equal(t, 2, r.lru.Len())
+	_, ok := r.Lookup(testRegistryCodec(0).ID())
+	require.False(t, ok)
+	_, ok = r.Lookup(testRegistryCodec(1).ID())
+	require.True(t, ok)
+}
+
+func TestDictionaryRegistry_Concurrent(t *testing.T) {
+	r := NewDictionaryRegistry(time.Minute, 4)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			profile := fmt.Sprintf("p%d", g)
+			for i := 0; i < 200; i++ {
+				c := testRegistryCodec(i % 7)
+				r.Set(profile, c)
+				if got, ok := r.Codec(profile, c.ID()); !ok || got == nil {
+					t.Errorf("no codec for %s right after setting one", profile)
+					return
+				}
+				r.Lookup(c.ID())
+				if i%50 == 0 {
+					r.Remove(profile)
+					r.Prune()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
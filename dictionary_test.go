@@ -0,0 +1,153 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/require"
+)
+
+// The id derivation is part of the protocol, clients in other languages compute
+// the same value - it must never change.
+func TestDictionaryID(t *testing.T) {
+	require.Equal(t, "47DEQpj8HBSa-_TI", DictionaryID(nil))
+	require.Equal(t, "47DEQpj8HBSa-_TI", DictionaryID([]byte{}))
+
+	content := []byte(`{"push":{"channel":"","pub":{"data":`)
+	sum := sha256.Sum256(content)
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(sum[:12]), DictionaryID(content))
+	require.Len(t, DictionaryID(content), 16)
+}
+
+func TestVerifyDictionary(t *testing.T) {
+	content := []byte(`{"push":{"channel":"","pub":{"data":`)
+	id := DictionaryID(content)
+	require.NoError(t, VerifyDictionary(id, content))
+
+	tampered := append([]byte(nil), content...)
+	tampered[0] = '['
+	require.ErrorIs(t, VerifyDictionary(id, tampered), ErrDictionaryMismatch)
+	require.ErrorIs(t, VerifyDictionary("", content), ErrDictionaryMismatch)
+}
+
+func TestNewVerifiedDeflateFrameCodec(t *testing.T) {
+	content := []byte(`{"push":{"channel":"","pub":{"data":`)
+	id := DictionaryID(content)
+
+	c, err := NewVerifiedDeflateFrameCodec(id, content)
+	require.NoError(t, err)
+	require.Equal(t, id, c.ID())
+	require.Equal(t, content, c.Dict())
+
+	c, err = NewVerifiedDeflateFrameCodec("v1", content)
+	require.ErrorIs(t, err, ErrDictionaryMismatch)
+	require.Nil(t, c)
+}
+
+type mapDictionaryCache map[string][]byte
+
+func (m mapDictionaryCache) Get(id string) ([]byte, bool) {
+	content, ok := m[id]
+	return content, ok
+}
+
+func (m mapDictionaryCache) Set(id string, content []byte) {
+	m[id] = content
+}
+
+func TestNewDictionary(t *testing.T) {
+	content := []byte(`{"push":{"channel":"","pub":{"data":{"price":`)
+	c := NewDeflateFrameCodec(DictionaryID(content), content)
+
+	d := NewDictionary(TypeProtobuf, c, "")
+	require.Equal(t, c.ID(), d.Id)
+	require.Equal(t, Raw(DeflateDictionary(content)), d.Data)
+	require.Empty(t, d.DataB64)
+
+	d = NewDictionary(TypeJSON, c, "other")
+	require.Equal(t, c.ID(), d.Id)
+	require.Empty(t, d.Data)
+	require.Equal(t, base64.StdEncoding.EncodeToString(DeflateDictionary(content)), d.DataB64)
+
+	// The client already holds it.
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		d = NewDictionary(protoType, c, c.ID())
+		require.Equal(t, c.ID(), d.Id)
+		require.Empty(t, d.Data)
+		require.Empty(t, d.DataB64)
+	}
+}
+
+// A Dictionary must survive the trip through both protocol encodings and
+// resolve into a codec which decodes what the server side codec produced.
+func TestResolveDictionary_RoundTrip(t *testing.T) {
+	content := []byte(`{"push":{"channel":"","pub":{"data":{"price":`)
+	server := NewDeflateFrameCodec(DictionaryID(content), content)
+	msg := []byte(`{"push":{"channel":"prices","pub":{"data":{"price":1}}}}`)
+
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		t.Run(string(protoType), func(t *testing.T) {
+			encoded, err := GetReplyEncoder(protoType).Encode(&Reply{
+				Id:      1,
+				Connect: &ConnectResult{Dict: NewDictionary(protoType, server, "")},
+			})
+			require.NoError(t, err)
+			var reply Reply
+			if protoType == TypeJSON {
+				require.NoError(t, json.Unmarshal(encoded, &reply))
+			} else {
+				require.NoError(t, reply.UnmarshalVT(encoded))
+			}
+
+			cache := mapDictionaryCache{}
+			client, err := ResolveDictionary(reply.Connect.Dict, cache, 1<<16)
+			require.NoError(t, err)
+			require.Equal(t, server.ID(), client.ID())
+			require.Equal(t, content, cache[server.ID()])
+			out, err := client.Decompress(nil, server.Compress(nil, msg), 1<<20)
+			require.NoError(t, err)
+			require.Equal(t, msg, out)
+
+			// On reconnect the server names the dictionary only.
+			client, err = ResolveDictionary(NewDictionary(protoType, server, server.ID()), cache, 1<<16)
+			require.NoError(t, err)
+			require.Equal(t, content, client.Dict())
+		})
+	}
+}
+
+func TestResolveDictionary_Errors(t *testing.T) {
+	content := []byte(`{"push":{"channel":"","pub":{"data":{"price":`)
+	id := DictionaryID(content)
+	deflated := DeflateDictionary(content)
+
+	_, err := ResolveDictionary(nil, nil, 1<<16)
+	require.ErrorIs(t, err, ErrInvalidDictionary)
+	_, err = ResolveDictionary(&Dictionary{Data: deflated}, nil, 1<<16)
+	require.ErrorIs(t, err, ErrInvalidDictionary)
+	_, err = ResolveDictionary(&Dictionary{Id: id, Data: deflated, DataB64: "eA"}, nil, 1<<16)
+	require.ErrorIs(t, err, ErrInvalidDictionary)
+	_, err = ResolveDictionary(&Dictionary{Id: id, DataB64: "not base64!"}, nil, 1<<16)
+	require.ErrorIs(t, err, ErrInvalidDictionary)
+
+	_, err = ResolveDictionary(&Dictionary{Id: id}, nil, 1<<16)
+	require.ErrorIs(t, err, ErrDictionaryNotCached)
+	_, err = ResolveDictionary(&Dictionary{Id: id}, mapDictionaryCache{}, 1<<16)
+	require.ErrorIs(t, err, ErrDictionaryNotCached)
+
+	// Cached content rewritten behind the client's back must be refused.
+	cache := mapDictionaryCache{id: []byte(`{"push":{"channel":"","pub":{"data":{"evil":`)}
+	_, err = ResolveDictionary(&Dictionary{Id: id}, cache, 1<<16)
+	require.ErrorIs(t, err, ErrDictionaryMismatch)
+
+	// Content the server sent under a wrong id is not installed nor cached.
+	cache = mapDictionaryCache{}
+	_, err = ResolveDictionary(&Dictionary{Id: "v1", Data: deflated}, cache, 1<<16)
+	require.ErrorIs(t, err, ErrDictionaryMismatch)
+	require.Empty(t, cache)
+
+	_, err = ResolveDictionary(&Dictionary{Id: id, Data: deflated}, nil, 8)
+	require.Error(t, err)
+}
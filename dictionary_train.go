@@ -0,0 +1,288 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// maxDictionarySize is the largest dictionary that can be useful to
+// DeflateFrameCodec. DEFLATE back references reach at most 32KB behind the
+// current position, so anything before the last 32KB of a preset dictionary can
+// never be referenced - it is carried around and paid for without ever being
+// used. TrainDictionary clamps its budget to this.
+const maxDictionarySize = 32768
+
+// dictionaryDmerSize is the length of the substrings TrainDictionary counts.
+//
+// It must stay well above the 3 byte DEFLATE minimum match: a match is encoded
+// as a length and a distance, which costs around 3 bytes on its own, so short
+// recurring substrings save nothing. 8 is also exactly one uint64, which lets
+// the counting below key on the substring itself rather than on a hash of it, so
+// two different substrings can never be mistaken for one.
+const dictionaryDmerSize = 8
+
+// dictionarySegmentSize is the length of the segments TrainDictionary selects
+// before trimming. It is in the range of a typical frame of a chatty connection,
+// so a segment tends to capture one whole message shape - keys, punctuation and
+// the values that do not change - rather than a fragment of it.
+const dictionarySegmentSize = 64
+
+// TrainDictionary builds a DeflateFrameCodec dictionary of at most maxSize bytes
+// from samples of encoded frames, and returns its id together with the content.
+//
+// Samples should be frames of one profile as they are sent on the wire, before
+// frame compression: a dictionary only helps a frame it shares substrings with,
+// so mixing traffic of unrelated shapes dilutes it for all of them. The id is
+// DictionaryID of the content.
+//
+// The dictionary is a concatenation of segments of the samples. Segments are
+// chosen greedily by how many samples share the substrings they contain, each
+// substring counted once across the whole dictionary, and placed in ascending
+// order of that value. The order matters: DEFLATE encodes a back reference with
+// fewer bits the closer it points, and the end of a preset dictionary is the
+// part closest to the frame, so the most valuable content goes last.
+//
+// maxSize is clamped to 32KB, the DEFLATE window, since content past it could
+// never be referenced. Substrings found in a single sample only are ignored, so
+// a dictionary trained on too few or too dissimilar samples may be shorter than
+// maxSize, or empty.
+func TrainDictionary(samples [][]byte, maxSize int) (string, []byte) {
+	if maxSize > maxDictionarySize {
+		maxSize = maxDictionarySize
+	}
+	dict := trainDictionary(samples, maxSize)
+	return DictionaryID(dict), dict
+}
+
+// dictionarySegment is a candidate piece of a trained dictionary.
+type dictionarySegment struct {
+	data  []byte
+	score int
+	// seq keeps the order in which segments were selected, to break ties in
+	// score deterministically.
+	seq int
+}
+
+func trainDictionary(samples [][]byte, maxSize int) []byte {
+	if maxSize <= 0 {
+		return nil
+	}
+	freq := dmerFrequencies(samples)
+	if len(freq) == 0 {
+		return nil
+	}
+
+	// Samples are scanned in epochs, one segment picked from each, rather than
+	// searching all the samples for the single best segment every time. The
+	// latter costs a full pass per segment; this way one pass over the samples
+	// yields up to one dictionary's worth of segments, and every part of the
+	// sample set gets a say in the result.
+	total := 0
+	for _, s := range samples {
+		total += len(s)
+	}
+	numEpochs := maxSize / dictionarySegmentSize
+	if numEpochs < 1 {
+		numEpochs = 1
+	}
+	epochSize := total / numEpochs
+	if epochSize < dictionarySegmentSize {
+		epochSize = dictionarySegmentSize
+	}
+
+	var segments []dictionarySegment
+	size := 0
+	for size < maxSize {
+		found := false
+		for start := 0; start < total && size < maxSize; start += epochSize {
+			seg, ok := bestDictionarySegment(samples, freq, start, start+epochSize)
+			if !ok {
+				continue
+			}
+			found = true
+			if size+len(seg.data) > maxSize {
+				seg.data = seg.data[len(seg.data)-(maxSize-size):]
+			}
+			seg.seq = len(segments)
+			segments = append(segments, seg)
+			size += len(seg.data)
+		}
+		if !found {
+			// Every substring shared between samples is covered already.
+			break
+		}
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool {
+		if segments[i].score != segments[j].score {
+			return segments[i].score < segments[j].score
+		}
+		return segments[i].seq > segments[j].seq
+	})
+	dict := make([]byte, 0, size)
+	for _, seg := range segments {
+		dict = append(dict, seg.data...)
+	}
+	return dict
+}
+
+// dmerAt returns the substring of dictionaryDmerSize bytes starting at b as a
+// map key.
+func dmerAt(b []byte) uint64 {
+	return binary.LittleEndian.Uint64(b)
+}
+
+// dmerFrequencies returns, for every substring of dictionaryDmerSize bytes, the
+// number of samples containing it. Substrings found in one sample only are left
+// out: they would only help a frame identical to that sample.
+func dmerFrequencies(samples [][]byte) map[uint64]int {
+	freq := make(map[uint64]int)
+	seen := make(map[uint64]struct{})
+	for _, s := range samples {
+		clear(seen)
+		for i := 0; i+dictionaryDmerSize <= len(s); i++ {
+			d := dmerAt(s[i:])
+			if _, ok := seen[d]; ok {
+				continue
+			}
+			seen[d] = struct{}{}
+			freq[d]++
+		}
+	}
+	for d, n := range freq {
+		if n < 2 {
+			delete(freq, d)
+		}
+	}
+	return freq
+}
+
+// bestDictionarySegment returns the highest scoring segment starting within
+// [from, to) of the samples taken as one contiguous sequence, and marks the
+// substrings it contains as covered. A segment never crosses from one sample
+// into the next, since no real frame contains that boundary.
+func bestDictionarySegment(samples [][]byte, freq map[uint64]int, from, to int) (dictionarySegment, bool) {
+	var (
+		best      dictionarySegment
+		bestFound bool
+		offset    int
+		scores    []int
+	)
+	for _, s := range samples {
+		sampleFrom, sampleTo := from-offset, to-offset
+		offset += len(s)
+		if sampleTo <= 0 || sampleFrom >= len(s) {
+			continue
+		}
+		if sampleFrom < 0 {
+			sampleFrom = 0
+		}
+		numDmers := len(s) - dictionaryDmerSize + 1
+		if numDmers <= 0 || sampleFrom >= numDmers {
+			continue
+		}
+		if sampleTo > numDmers {
+			sampleTo = numDmers
+		}
+		// Score of every substring the segments starting in range can cover.
+		last := sampleTo + dictionarySegmentSize - dictionaryDmerSize
+		if last > numDmers {
+			last = numDmers
+		}
+		scores = scores[:0]
+		for i := sampleFrom; i < last; i++ {
+			scores = append(scores, freq[dmerAt(s[i:])])
+		}
+		window := dictionarySegmentSize - dictionaryDmerSize + 1
+		score := 0
+		for i := 0; i < len(scores) && i < window; i++ {
+			score += scores[i]
+		}
+		for i := sampleFrom; i < sampleTo; i++ {
+			k := i - sampleFrom
+			if k > 0 {
+				score -= scores[k-1]
+				if k+window-1 < len(scores) {
+					score += scores[k+window-1]
+				}
+			}
+			if score > best.score {
+				end := i + dictionarySegmentSize
+				if end > len(s) {
+					end = len(s)
+				}
+				best = dictionarySegment{data: s[i:end], score: score}
+				bestFound = true
+			}
+		}
+	}
+	if !bestFound {
+		return best, false
+	}
+	// Trim substrings nobody else shares off both ends, they only take space.
+	data := best.data
+	for len(data) >= dictionaryDmerSize && freq[dmerAt(data)] == 0 {
+		data = data[1:]
+	}
+	for len(data) >= dictionaryDmerSize && freq[dmerAt(data[len(data)-dictionaryDmerSize:])] == 0 {
+		data = data[:len(data)-1]
+	}
+	for i := 0; i+dictionaryDmerSize <= len(data); i++ {
+		delete(freq, dmerAt(data[i:]))
+	}
+	best.data = append([]byte(nil), data...)
+	return best, true
+}
+
+// DictionaryEvaluation describes how well a dictionary compresses a set of
+// frames, see EvaluateDictionary.
+type DictionaryEvaluation struct {
+	// Frames is the number of frames evaluated.
+	Frames int
+	// RawBytes is the total size of the frames as given.
+	RawBytes int
+	// PlainBytes is their total size once framed by a codec with no dictionary.
+	PlainBytes int
+	// DictBytes is their total size once framed by the evaluated codec.
+	DictBytes int
+}
+
+// Ratio returns how many times smaller the frames are with the dictionary than
+// without compression at all.
+func (e DictionaryEvaluation) Ratio() float64 {
+	if e.DictBytes == 0 {
+		return 0
+	}
+	return float64(e.RawBytes) / float64(e.DictBytes)
+}
+
+// Gain returns how many times smaller the frames are with the dictionary than
+// with compression but no dictionary - the part of the saving the dictionary
+// itself is responsible for. A gain close to 1 means the dictionary does not
+// match the traffic.
+func (e DictionaryEvaluation) Gain() float64 {
+	if e.DictBytes == 0 {
+		return 0
+	}
+	return float64(e.PlainBytes) / float64(e.DictBytes)
+}
+
+// EvaluateDictionary compresses every frame with c and with a codec without a
+// dictionary, and reports the sizes. Evaluate on frames held out from training:
+// a dictionary always looks good on the samples it was built from.
+func EvaluateDictionary(c *DeflateFrameCodec, frames [][]byte) DictionaryEvaluation {
+	plain := NewDeflateFrameCodec("", nil)
+	var (
+		e   DictionaryEvaluation
+		buf []byte
+	)
+	for _, f := range frames {
+		e.Frames++
+		e.RawBytes += len(f)
+		buf = plain.Compress(buf[:0], f)
+		e.PlainBytes += len(buf)
+		buf = c.Compress(buf[:0], f)
+		e.DictBytes += len(buf)
+	}
+	return e
+}
@@ -0,0 +1,107 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func trainingFrames(n int) [][]byte {
+	frames := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		frames = append(frames, []byte(fmt.Sprintf(
+			`{"push":{"channel":"prices:%d","pub":{"data":{"ticker":"T%d","price":%d.%02d},"offset":%d}}}`,
+			i%7, i%13, 100+i, i%100, 1000+i)))
+	}
+	return frames
+}
+
+func TestTrainDictionary(t *testing.T) {
+	samples := trainingFrames(500)
+	id, dict := TrainDictionary(samples, 1024)
+	require.NotEmpty(t, dict)
+	require.LessOrEqual(t, len(dict), 1024)
+	require.Equal(t, DictionaryID(dict), id)
+
+	// Shared message structure must make it in, it is what every frame
+	// references.
+	require.True(t, bytes.Contains(dict, []byte(`"pub":{"data":{"ticker":"T`)))
+
+	// Training is deterministic, so every node of a cluster building a
+	// dictionary from the same samples gets the same id.
+	id2, dict2 := TrainDictionary(samples, 1024)
+	require.Equal(t, id, id2)
+	require.Equal(t, dict, dict2)
+
+	c := NewDeflateFrameCodec(id, dict)
+	for _, f := range trainingFrames(20) {
+		out, err := c.Decompress(nil, c.Compress(nil, f), 1<<20)
+		require.NoError(t, err)
+		require.Equal(t, f, out)
+	}
+}
+
+// The most valuable content must sit at the end of the dictionary, where it is
+// closest to the frame being compressed.
+func TestTrainDictionary_MostValuableLast(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 100; i++ {
+		if i%10 == 0 {
+			// A shape shared by a tenth of the samples only.
+			samples = append(samples, []byte(fmt.Sprintf(`{"rare":"0123456789-rare-but-shared","n":%d}`, i)))
+			continue
+		}
+		samples = append(samples, []byte(fmt.Sprintf(`{"common":"abcdefghijklmnopqrstuvwxyz","n":%d}`, i)))
+	}
+	_, dict := TrainDictionary(samples, 4096)
+	common := bytes.Index(dict, []byte(`abcdefghijklmnopqrstuvwxyz`))
+	rare := bytes.Index(dict, []byte(`rare-but-shared`))
+	require.GreaterOrEqual(t, common, 0)
+	require.GreaterOrEqual(t, rare, 0)
+	require.Greater(t, common, rare)
+}
+
+func TestTrainDictionary_Budget(t *testing.T) {
+	samples := trainingFrames(5000)
+	_, dict := TrainDictionary(samples, 1<<20)
+	require.LessOrEqual(t, len(dict), maxDictionarySize)
+
+	_, dict = TrainDictionary(samples, 100)
+	require.NotEmpty(t, dict)
+	require.LessOrEqual(t, len(dict), 100)
+
+	_, dict = TrainDictionary(samples, 0)
+	require.Empty(t, dict)
+}
+
+func TestTrainDictionary_NothingShared(t *testing.T) {
+	_, dict := TrainDictionary([][]byte{[]byte("only one sample here")}, 1024)
+	require.Empty(t, dict)
+	_, dict = TrainDictionary(nil, 1024)
+	require.Empty(t, dict)
+	_, dict = TrainDictionary([][]byte{[]byte("short"), []byte("short")}, 1024)
+	require.Empty(t, dict)
+}
+
+func TestEvaluateDictionary(t *testing.T) {
+	frames := trainingFrames(50)
+	id, dict := TrainDictionary(trainingFrames(500), 2048)
+	c := NewDeflateFrameCodec(id, dict)
+	e := EvaluateDictionary(c, frames)
+	require.Equal(t, 50, e.Frames)
+
+	raw := 0
+	dictBytes := 0
+	for _, f := range frames {
+		raw += len(f)
+		dictBytes += len(c.Compress(nil, f))
+	}
+	require.Equal(t, raw, e.RawBytes)
+	require.Equal(t, dictBytes, e.DictBytes)
+	require.Positive(t, e.PlainBytes)
+	require.InDelta(t, float64(raw)/float64(dictBytes), e.Ratio(), 1e-9)
+	require.Zero(t, DictionaryEvaluation{}.Ratio())
+	require.Zero(t, DictionaryEvaluation{}.Gain())
+}
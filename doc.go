@@ -21,7 +21,8 @@
 //
 // Messages of both formats can be streamed one after another inside a single
 // transport frame. In JSON messages are separated by a `\n` delimiter, in Protobuf
-// every message is prefixed with its length encoded as a varint.
+// every message is prefixed with its length encoded as a varint. TypeJSONLengthPrefixed
+// frames JSON messages as Protobuf ones are, see Raw.MarshalJSON for why.
 //
 // # Encoders and decoders
 //
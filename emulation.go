@@ -0,0 +1,129 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// ErrInvalidEmulationRequest is returned by DecodeEmulationRequest and
+// GetEmulationCommandDecoder for a request which can not be acted on.
+var ErrInvalidEmulationRequest = errors.New("centrifugal: invalid emulation request")
+
+// errNonPositiveEmulationSizeLimit is the panic value used when an emulation
+// request is decoded without a positive size limit, see
+// errNonPositiveMessageSizeLimit.
+const errNonPositiveEmulationSizeLimit = "protocol: emulation request decoder requires a positive maxSize"
+
+// EncodeEmulationRequest encodes req, whose Data is a frame of commands as
+// built by a DataEncoder of the same type.
+//
+// Unidirectional transports - HTTP-streaming and SSE - can not carry commands
+// from the client, so a client sends them in separate HTTP requests instead,
+// naming the node and the session of the connection they are meant for. In
+// JSON, Data is sent as a JSON string: a frame of several commands is not a
+// JSON value, and could not be embedded as one. TypeJSONLengthPrefixed requests
+// are JSON too, with Data base64 encoded (standard encoding, with padding) – the
+// length prefixes of its frames are binary, which a JSON string can not carry.
+// Any other type is treated as TypeProtobuf.
+func EncodeEmulationRequest(protoType Type, req *EmulationRequest) ([]byte, error) {
+	if !isJSON(protoType) {
+		return req.MarshalVT()
+	}
+	jw := newWriter()
+	jw.RawByte('{')
+	jw.RawString(`"node":`)
+	jw.String(req.Node)
+	jw.RawString(`,"session":`)
+	jw.String(req.Session)
+	if len(req.Data) > 0 {
+		jw.RawString(`,"data":`)
+		if protoType == TypeJSONLengthPrefixed {
+			jw.String(base64.StdEncoding.EncodeToString(req.Data))
+		} else {
+			jw.String(string(req.Data))
+		}
+	}
+	jw.RawByte('}')
+	return jw.BuildBytes()
+}
+
+// DecodeEmulationRequest decodes a request encoded by EncodeEmulationRequest.
+// Data of the result is the frame of commands in all types. In JSON and
+// TypeJSONLengthPrefixed, Data is also accepted as a single command embedded as
+// an object.
+//
+// It only decodes, see GetEmulationCommandDecoder for what a server should use.
+func DecodeEmulationRequest(protoType Type, data []byte) (*EmulationRequest, error) {
+	var req EmulationRequest
+	if !isJSON(protoType) {
+		if err := req.UnmarshalVT(data); err != nil {
+			return nil, err
+		}
+		return &req, nil
+	}
+	if _, err := json.Parse(data, &req, 0); err != nil {
+		return nil, err
+	}
+	if len(req.Data) == 0 {
+		return &req, nil
+	}
+	if req.Data[0] != '"' {
+		if protoType == TypeJSONLengthPrefixed {
+			req.Data = append(binary.AppendUvarint(nil, uint64(len(req.Data))), req.Data...)
+		}
+		return &req, nil
+	}
+	var frame string
+	if _, err := json.Parse(req.Data, &frame, 0); err != nil {
+		return nil, err
+	}
+	if protoType == TypeJSONLengthPrefixed {
+		decoded, err := base64.StdEncoding.DecodeString(frame)
+		if err != nil {
+			return nil, err
+		}
+		req.Data = decoded
+	} else {
+		req.Data = Raw(frame)
+	}
+	return &req, nil
+}
+
+// GetEmulationCommandDecoder decodes an emulation request and returns it
+// together with a CommandDecoder reading the commands it carries, see
+// EncodeEmulationRequest. The decoder is taken from a pool and enforces the
+// given DecodeLimits. Return it with PutCommandDecoder once the commands are
+// processed.
+//
+// A request of more than maxSize bytes is rejected with ErrMessageTooLarge
+// before anything is decoded. A request which does not name both the node and
+// the session of the connection is rejected with ErrInvalidEmulationRequest,
+// as is one carrying no commands. maxSize must be positive: emulation endpoints
+// are exposed to anyone who can reach them, and a zero or negative limit
+// panics. Any type other than TypeJSON and TypeJSONLengthPrefixed is treated as
+// TypeProtobuf.
+func GetEmulationCommandDecoder(protoType Type, body []byte, maxSize int, limits DecodeLimits) (*EmulationRequest, CommandIntoDecoder, error) {
+	if maxSize <= 0 {
+		panic(errNonPositiveEmulationSizeLimit)
+	}
+	if len(body) > maxSize {
+		return nil, nil, ErrMessageTooLarge
+	}
+	req, err := DecodeEmulationRequest(protoType, body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidEmulationRequest, err)
+	}
+	switch {
+	case req.Node == "":
+		return nil, nil, fmt.Errorf("%w: no node", ErrInvalidEmulationRequest)
+	case req.Session == "":
+		return nil, nil, fmt.Errorf("%w: no session", ErrInvalidEmulationRequest)
+	case len(req.Data) == 0:
+		return nil, nil, fmt.Errorf("%w: no data", ErrInvalidEmulationRequest)
+	}
+	return req, GetCommandDecoderWithLimits(protoType, req.Data, limits), nil
+}
@@ -0,0 +1,144 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+var emulationCommands = []*Command{
+	{Id: 1, Publish: &PublishRequest{Channel: "news", Data: Raw(`{"text":"hi\n"}`)}},
+	{Id: 2, Rpc: &RPCRequest{Method: "m", Data: Raw(`{}`)}},
+}
+
+func TestEmulationRequest_RoundTrip(t *testing.T) {
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf, TypeJSONLengthPrefixed} {
+		t.Run(string(protoType), func(t *testing.T) {
+			req := &EmulationRequest{Node: "n1", Session: "s1", Data: encodeCommands(t, protoType, emulationCommands...)}
+			data, err := EncodeEmulationRequest(protoType, req)
+			require.NoError(t, err)
+			decoded, err := DecodeEmulationRequest(protoType, data)
+			require.NoError(t, err)
+			require.Equal(t, req.Node, decoded.Node)
+			require.Equal(t, req.Session, decoded.Session)
+			require.Equal(t, []byte(req.Data), []byte(decoded.Data))
+		})
+	}
+}
+
+func TestEncodeEmulationRequest_JSONDataIsString(t *testing.T) {
+	req := &EmulationRequest{Node: "n1", Session: "s1", Data: Raw("{\"id\":1}\n{\"id\":2}")}
+	data, err := EncodeEmulationRequest(TypeJSON, req)
+	require.NoError(t, err)
+	require.Equal(t, `{"node":"n1","session":"s1","data":"{\"id\":1}\n{\"id\":2}"}`, string(data))
+}
+
+func TestDecodeEmulationRequest_JSONDataObject(t *testing.T) {
+	req, err := DecodeEmulationRequest(TypeJSON, []byte(`{"node":"n1","session":"s1","data":{"id":1}}`))
+	require.NoError(t, err)
+	require.Equal(t, `{"id":1}`, string(req.Data))
+}
+
+func TestEncodeEmulationRequest_JSONLengthPrefixedDataIsBase64(t *testing.T) {
+	// A command long enough for its length prefix not to be valid UTF-8.
+	frame := encodeCommands(t, TypeJSONLengthPrefixed, &Command{Id: 1, Publish: &PublishRequest{
+		Channel: "news", Data: Raw(`"` + strings.Repeat("a", 200) + `"`),
+	}})
+	req := &EmulationRequest{Node: "n1", Session: "s1", Data: frame}
+	data, err := EncodeEmulationRequest(TypeJSONLengthPrefixed, req)
+	require.NoError(t, err)
+	require.Equal(t, `{"node":"n1","session":"s1","data":"`+base64.StdEncoding.EncodeToString(frame)+`"}`, string(data))
+
+	decoded, err := DecodeEmulationRequest(TypeJSONLengthPrefixed, data)
+	require.NoError(t, err)
+	require.Equal(t, frame, []byte(decoded.Data))
+}
+
+func TestDecodeEmulationRequest_JSONLengthPrefixedDataObject(t *testing.T) {
+	_, decoder, err := GetEmulationCommandDecoder(TypeJSONLengthPrefixed, []byte(`{"node":"n1","session":"s1","data":{"id":1}}`), 1024, DecodeLimits{})
+	require.NoError(t, err)
+	defer PutCommandDecoder(TypeJSONLengthPrefixed, decoder)
+	cmd, err := decoder.Decode()
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, uint32(1), cmd.Id)
+}
+
+func TestGetEmulationCommandDecoder(t *testing.T) {
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf, TypeJSONLengthPrefixed} {
+		t.Run(string(protoType), func(t *testing.T) {
+			body, err := EncodeEmulationRequest(protoType, &EmulationRequest{
+				Node: "n1", Session: "s1", Data: encodeCommands(t, protoType, emulationCommands...),
+			})
+			require.NoError(t, err)
+			req, decoder, err := GetEmulationCommandDecoder(protoType, body, len(body), DecodeLimits{})
+			require.NoError(t, err)
+			defer PutCommandDecoder(protoType, decoder)
+			require.Equal(t, "n1", req.Node)
+			require.Equal(t, "s1", req.Session)
+
+			var cmds []*Command
+			for {
+				cmd, err := decoder.Decode()
+				if cmd != nil {
+					cmds = append(cmds, cmd)
+				}
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+			}
+			require.Len(t, cmds, len(emulationCommands))
+			for i := range cmds {
+				require.True(t, proto.Equal(emulationCommands[i], cmds[i]))
+			}
+		})
+	}
+}
+
+func TestGetEmulationCommandDecoder_Invalid(t *testing.T) {
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf, TypeJSONLengthPrefixed} {
+		t.Run(string(protoType), func(t *testing.T) {
+			frame := encodeCommands(t, protoType, emulationCommands...)
+			for name, req := range map[string]*EmulationRequest{
+				"no node":    {Session: "s1", Data: frame},
+				"no session": {Node: "n1", Data: frame},
+				"no data":    {Node: "n1", Session: "s1"},
+			} {
+				body, err := EncodeEmulationRequest(protoType, req)
+				require.NoError(t, err)
+				_, _, err = GetEmulationCommandDecoder(protoType, body, 1024, DecodeLimits{})
+				require.ErrorIs(t, err, ErrInvalidEmulationRequest, name)
+				require.ErrorContains(t, err, name)
+			}
+
+			_, _, err := GetEmulationCommandDecoder(protoType, []byte("\xff{"), 1024, DecodeLimits{})
+			require.ErrorIs(t, err, ErrInvalidEmulationRequest)
+		})
+	}
+}
+
+func TestGetEmulationCommandDecoder_Limits(t *testing.T) {
+	body, err := EncodeEmulationRequest(TypeProtobuf, &EmulationRequest{
+		Node: "n1", Session: "s1", Data: encodeCommands(t, TypeProtobuf, emulationCommands...),
+	})
+	require.NoError(t, err)
+
+	_, _, err = GetEmulationCommandDecoder(TypeProtobuf, body, len(body)-1, DecodeLimits{})
+	require.ErrorIs(t, err, ErrMessageTooLarge)
+
+	_, decoder, err := GetEmulationCommandDecoder(TypeProtobuf, body, len(body), DecodeLimits{MaxCommands: 1})
+	require.NoError(t, err)
+	defer PutCommandDecoder(TypeProtobuf, decoder)
+	_, err = decoder.Decode()
+	require.NoError(t, err)
+	_, err = decoder.Decode()
+	require.ErrorIs(t, err, ErrDecodeLimitExceeded)
+
+	require.Panics(t, func() {
+		_, _, _ = GetEmulationCommandDecoder(TypeProtobuf, body, 0, DecodeLimits{})
+	})
+}
@@ -48,6 +48,7 @@ var _ PushEncoder = (*ProtobufPushEncoder)(nil)
 
 // JSONPushEncoder is a PushEncoder which encodes to JSON.
 type JSONPushEncoder struct {
+	flags flags
 }
 
 // NewJSONPushEncoder creates a new JSONPushEncoder. It's safe to use the
@@ -58,7 +59,7 @@ func NewJSONPushEncoder() *JSONPushEncoder {
 
 // Encode Push to bytes.
 func (e *JSONPushEncoder) Encode(message *Push) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	message.MarshalEasyJSON(jw)
 	res, err := jw.BuildBytes()
 	if err != nil {
@@ -72,63 +73,63 @@ func (e *JSONPushEncoder) Encode(message *Push) ([]byte, error) {
 
 // EncodePublication to bytes.
 func (e *JSONPushEncoder) EncodePublication(message *Publication, reuse ...[]byte) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	message.MarshalEasyJSON(jw)
 	return jw.BuildBytes(reuse...)
 }
 
 // EncodeMessage to bytes.
 func (e *JSONPushEncoder) EncodeMessage(message *Message, reuse ...[]byte) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	message.MarshalEasyJSON(jw)
 	return jw.BuildBytes(reuse...)
 }
 
 // EncodeJoin to bytes.
 func (e *JSONPushEncoder) EncodeJoin(message *Join, reuse ...[]byte) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	message.MarshalEasyJSON(jw)
 	return jw.BuildBytes(reuse...)
 }
 
 // EncodeLeave to bytes.
 func (e *JSONPushEncoder) EncodeLeave(message *Leave, reuse ...[]byte) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	message.MarshalEasyJSON(jw)
 	return jw.BuildBytes(reuse...)
 }
 
 // EncodeUnsubscribe to bytes.
 func (e *JSONPushEncoder) EncodeUnsubscribe(message *Unsubscribe, reuse ...[]byte) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	message.MarshalEasyJSON(jw)
 	return jw.BuildBytes(reuse...)
 }
 
 // EncodeSubscribe to bytes.
 func (e *JSONPushEncoder) EncodeSubscribe(message *Subscribe, reuse ...[]byte) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	message.MarshalEasyJSON(jw)
 	return jw.BuildBytes(reuse...)
 }
 
 // EncodeConnect to bytes.
 func (e *JSONPushEncoder) EncodeConnect(message *Connect, reuse ...[]byte) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	message.MarshalEasyJSON(jw)
 	return jw.BuildBytes(reuse...)
 }
 
 // EncodeDisconnect to bytes.
 func (e *JSONPushEncoder) EncodeDisconnect(message *Disconnect, reuse ...[]byte) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	message.MarshalEasyJSON(jw)
 	return jw.BuildBytes(reuse...)
 }
 
 // EncodeRefresh to bytes.
 func (e *JSONPushEncoder) EncodeRefresh(message *Refresh, reuse ...[]byte) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	message.MarshalEasyJSON(jw)
 	return jw.BuildBytes(reuse...)
 }
@@ -290,7 +291,9 @@ type ReplyEncoder interface {
 }
 
 // JSONReplyEncoder is a ReplyEncoder which encodes to JSON.
-type JSONReplyEncoder struct{}
+type JSONReplyEncoder struct {
+	flags flags
+}
 
 // NewJSONReplyEncoder creates a new JSONReplyEncoder. It's safe to use the
 // returned encoder concurrently, see also DefaultJsonReplyEncoder.
@@ -300,7 +303,7 @@ func NewJSONReplyEncoder() *JSONReplyEncoder {
 
 // Encode Reply to bytes.
 func (e *JSONReplyEncoder) Encode(r *Reply) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	r.MarshalEasyJSON(jw)
 	result, err := jw.BuildBytes()
 	if err != nil {
@@ -448,7 +451,9 @@ type ResultEncoder interface {
 }
 
 // JSONResultEncoder is a ResultEncoder which encodes to JSON.
-type JSONResultEncoder struct{}
+type JSONResultEncoder struct {
+	flags flags
+}
 
 // NewJSONResultEncoder creates a new JSONResultEncoder. It's safe to use the
 // returned encoder concurrently.
@@ -458,77 +463,77 @@ func NewJSONResultEncoder() *JSONResultEncoder {
 
 // EncodeConnectResult encodes ConnectResult to bytes.
 func (e *JSONResultEncoder) EncodeConnectResult(res *ConnectResult) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	res.MarshalEasyJSON(jw)
 	return jw.BuildBytes()
 }
 
 // EncodeRefreshResult encodes RefreshResult to bytes.
 func (e *JSONResultEncoder) EncodeRefreshResult(res *RefreshResult) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	res.MarshalEasyJSON(jw)
 	return jw.BuildBytes()
 }
 
 // EncodeSubscribeResult encodes SubscribeResult to bytes.
 func (e *JSONResultEncoder) EncodeSubscribeResult(res *SubscribeResult) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	res.MarshalEasyJSON(jw)
 	return jw.BuildBytes()
 }
 
 // EncodeSubRefreshResult encodes SubRefreshResult to bytes.
 func (e *JSONResultEncoder) EncodeSubRefreshResult(res *SubRefreshResult) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	res.MarshalEasyJSON(jw)
 	return jw.BuildBytes()
 }
 
 // EncodeUnsubscribeResult encodes UnsubscribeResult to bytes.
 func (e *JSONResultEncoder) EncodeUnsubscribeResult(res *UnsubscribeResult) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	res.MarshalEasyJSON(jw)
 	return jw.BuildBytes()
 }
 
 // EncodePublishResult encodes PublishResult to bytes.
 func (e *JSONResultEncoder) EncodePublishResult(res *PublishResult) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	res.MarshalEasyJSON(jw)
 	return jw.BuildBytes()
 }
 
 // EncodePresenceResult encodes PresenceResult to bytes.
 func (e *JSONResultEncoder) EncodePresenceResult(res *PresenceResult) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	res.MarshalEasyJSON(jw)
 	return jw.BuildBytes()
 }
 
 // EncodePresenceStatsResult encodes PresenceStatsResult to bytes.
 func (e *JSONResultEncoder) EncodePresenceStatsResult(res *PresenceStatsResult) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	res.MarshalEasyJSON(jw)
 	return jw.BuildBytes()
 }
 
 // EncodeHistoryResult encodes HistoryResult to bytes.
 func (e *JSONResultEncoder) EncodeHistoryResult(res *HistoryResult) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	res.MarshalEasyJSON(jw)
 	return jw.BuildBytes()
 }
 
 // EncodePingResult encodes PingResult to bytes.
 func (e *JSONResultEncoder) EncodePingResult(res *PingResult) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	res.MarshalEasyJSON(jw)
 	return jw.BuildBytes()
 }
 
 // EncodeRPCResult encodes RPCResult to bytes.
 func (e *JSONResultEncoder) EncodeRPCResult(res *RPCResult) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	res.MarshalEasyJSON(jw)
 	return jw.BuildBytes()
 }
@@ -605,6 +610,7 @@ type CommandEncoder interface {
 
 // JSONCommandEncoder is a CommandEncoder which encodes to JSON.
 type JSONCommandEncoder struct {
+	flags flags
 }
 
 // NewJSONCommandEncoder creates a new JSONCommandEncoder. It's safe to use the
@@ -616,7 +622,7 @@ func NewJSONCommandEncoder() *JSONCommandEncoder {
 // Encode Command to bytes. The result contains no framing: to send several
 // commands in one frame join them with a `\n` delimiter, see JSONDataEncoder.
 func (e *JSONCommandEncoder) Encode(cmd *Command) ([]byte, error) {
-	jw := newWriter()
+	jw := newWriterFlags(e.flags)
 	cmd.MarshalEasyJSON(jw)
 	return jw.BuildBytes()
 }
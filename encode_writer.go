@@ -14,6 +14,7 @@ type flags int
 const (
 	nilMapAsEmpty   flags = 1 << iota // Encode nil map as '{}' rather than 'null'.
 	nilSliceAsEmpty                   // Encode nil slice as '[]' rather than 'null'.
+	rawIntact                         // Write Raw payloads as they are, see RawPayload.
 )
 
 // writer is a JSON writer.
@@ -30,6 +31,13 @@ func newWriter() *writer {
 	}
 }
 
+// newWriterFlags returns a writer applying the given flags.
+func newWriterFlags(f flags) *writer {
+	w := newWriter()
+	w.Flags = f
+	return w
+}
+
 // BuildBytes returns writer data as a single byte slice.
 func (w *writer) BuildBytes(reuse ...[]byte) ([]byte, error) {
 	if w.Error != nil {
@@ -86,6 +94,17 @@ func (w *writer) Raw(src []byte, err error) {
 	}
 }
 
+// RawPayload appends a Raw payload. Unless the rawIntact flag is set it's
+// written as its MarshalJSON returns it, without raw newlines. Encoders of
+// TypeJSONLengthPrefixed set the flag: no delimiter to protect there.
+func (w *writer) RawPayload(r Raw) {
+	if w.Flags&rawIntact != 0 {
+		w.Raw(r, nil)
+		return
+	}
+	w.Raw(r.MarshalJSON())
+}
+
 func (w *writer) Uint32(n uint32) {
 	_, _ = w.Buffer.WriteString(strconv.FormatUint(uint64(n), 10))
 }
@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// ConnectFlag is a connection level capability bit of ConnectRequest.flag and
+// ConnectResult.flag.
+//
+// A client sets the bits of the features it supports, and the server replies
+// with the subset it enabled - see Negotiate. Bits are part of the protocol and
+// are never reused once assigned, which is why they are defined here and
+// nowhere else: a server and a client SDK disagreeing on a bit would enable a
+// feature on one side only.
+type ConnectFlag int64
+
+const (
+	// ConnectFlagFrameCompression enables compressing frames with
+	// DeflateFrameCodec, against the dictionary sent in ConnectResult.dict.
+	ConnectFlagFrameCompression ConnectFlag = 1 << 0
+	// ConnectFlagZstdFrameCodec enables compressing frames with ZstdFrameCodec
+	// instead. It refines ConnectFlagFrameCompression rather than replacing it:
+	// a client supporting it sets both bits, so a server which does not know
+	// this one still enables DEFLATE, and it is never enabled on its own.
+	ConnectFlagZstdFrameCodec ConnectFlag = 1 << 1
+)
+
+var connectFlagNames = []string{
+	"frame_compression",
+	"zstd_frame_codec",
+}
+
+// SubscribeFlag is a subscription level capability bit of SubscribeRequest.flag.
+// The same rules as for ConnectFlag apply.
+type SubscribeFlag int64
+
+const (
+	// SubscribeFlagChannelCompaction asks the server to assign the channel a
+	// numeric id, returned in SubscribeResult.id and then sent in Push.id
+	// instead of the channel name.
+	SubscribeFlagChannelCompaction SubscribeFlag = 1 << 0
+)
+
+var subscribeFlagNames = []string{
+	"channel_compaction",
+}
+
+// Has tells whether all bits of flag are set in f.
+func (f ConnectFlag) Has(flag ConnectFlag) bool { return f&flag == flag }
+
+// String returns the names of the bits set in f joined with "|", for logs. Bits
+// this package does not know are printed in hex.
+func (f ConnectFlag) String() string { return formatFlags(int64(f), connectFlagNames) }
+
+// Has tells whether all bits of flag are set in f.
+func (f SubscribeFlag) Has(flag SubscribeFlag) bool { return f&flag == flag }
+
+// String returns the names of the bits set in f joined with "|", for logs. Bits
+// this package does not know are printed in hex.
+func (f SubscribeFlag) String() string { return formatFlags(int64(f), subscribeFlagNames) }
+
+func formatFlags(f int64, names []string) string {
+	if f == 0 {
+		return "0"
+	}
+	var sb strings.Builder
+	u := uint64(f)
+	for u != 0 {
+		if sb.Len() > 0 {
+			sb.WriteByte('|')
+		}
+		i := bits.TrailingZeros64(u)
+		if i >= len(names) {
+			// Everything from here on is unknown, print it at once.
+			sb.WriteString("0x")
+			sb.WriteString(strconv.FormatUint(u, 16))
+			break
+		}
+		sb.WriteString(names[i])
+		u &^= 1 << i
+	}
+	return sb.String()
+}
+
+// Negotiate returns the flags to enable for a client which advertised
+// clientFlags, on a server supporting serverSupported: the bits both sides
+// support, minus those whose prerequisites did not make it. The server sends the
+// result back, in ConnectResult.flag for connection level flags, and both sides
+// then act on it only.
+func Negotiate[F ConnectFlag | SubscribeFlag](clientFlags, serverSupported F) F {
+	enabled := clientFlags & serverSupported
+	if f, ok := any(enabled).(ConnectFlag); ok && !f.Has(ConnectFlagFrameCompression) {
+		enabled &^= F(ConnectFlagZstdFrameCodec)
+	}
+	return enabled
+}
@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectFlag_String(t *testing.T) {
+	require.Equal(t, "0", ConnectFlag(0).String())
+	require.Equal(t, "frame_compression", ConnectFlagFrameCompression.String())
+	require.Equal(t, "frame_compression|zstd_frame_codec", (ConnectFlagFrameCompression | ConnectFlagZstdFrameCodec).String())
+	require.Equal(t, "zstd_frame_codec|0x30", (ConnectFlagZstdFrameCodec | 1<<4 | 1<<5).String())
+	require.Equal(t, "0x8000000000000000", ConnectFlag(-1<<63).String())
+}
+
+func TestSubscribeFlag_String(t *testing.T) {
+	require.Equal(t, "channel_compaction", SubscribeFlagChannelCompaction.String())
+	require.Equal(t, "channel_compaction|0x2", (SubscribeFlagChannelCompaction | 1<<1).String())
+}
+
+func TestFlag_Has(t *testing.T) {
+	f := ConnectFlagFrameCompression | ConnectFlagZstdFrameCodec
+	require.True(t, f.Has(ConnectFlagFrameCompression))
+	require.True(t, f.Has(ConnectFlagFrameCompression|ConnectFlagZstdFrameCodec))
+	require.False(t, ConnectFlagFrameCompression.Has(ConnectFlagFrameCompression|ConnectFlagZstdFrameCodec))
+	require.True(t, SubscribeFlagChannelCompaction.Has(SubscribeFlagChannelCompaction))
+	require.False(t, SubscribeFlag(0).Has(SubscribeFlagChannelCompaction))
+}
+
+func TestNegotiate(t *testing.T) {
+	both := ConnectFlagFrameCompression | ConnectFlagZstdFrameCodec
+
+	require.Equal(t, both, Negotiate(both, both))
+	// A server not supporting zstd falls back to DEFLATE.
+	require.Equal(t, ConnectFlagFrameCompression, Negotiate(both, ConnectFlagFrameCompression))
+	// Bits the server does not know are never enabled.
+	require.Equal(t, ConnectFlagFrameCompression, Negotiate(ConnectFlagFrameCompression|1<<10, both))
+	// Zstd refines frame compression and is never enabled on its own.
+	require.Equal(t, ConnectFlag(0), Negotiate(ConnectFlagZstdFrameCodec, both))
+	require.Equal(t, ConnectFlag(0), Negotiate(both, ConnectFlagZstdFrameCodec))
+
+	require.Equal(t, SubscribeFlagChannelCompaction, Negotiate(SubscribeFlagChannelCompaction, SubscribeFlagChannelCompaction))
+	require.Equal(t, SubscribeFlag(0), Negotiate(SubscribeFlagChannelCompaction, 0))
+}
+
+// Flags travel as plain int64 fields of the generated messages.
+func TestFlag_MessageFields(t *testing.T) {
+	req := &ConnectRequest{Flag: int64(ConnectFlagFrameCompression | ConnectFlagZstdFrameCodec)}
+	res := &ConnectResult{Flag: int64(Negotiate(ConnectFlag(req.Flag), ConnectFlagFrameCompression))}
+	require.True(t, ConnectFlag(res.Flag).Has(ConnectFlagFrameCompression))
+	require.False(t, ConnectFlag(res.Flag).Has(ConnectFlagZstdFrameCodec))
+}
@@ -5,6 +5,7 @@ import (
 	"compress/flate"
 	"errors"
 	"io"
+	"slices"
 	"sync"
 )
 
@@ -50,11 +51,13 @@ const (
 // is one value wide, and the values outside it are a cliff rather than a
 // trade - so a knob here could only be set wrong.
 //
-// klauspost/compress was evaluated as a faster encoder and rejected: it ignores
-// the dictionary below level 7, and at level 7 it was only ~1.25x cheaper while
-// producing ~6% more bytes overall on real traffic. Once the shared frame cache
-// removed the fan-out multiplier, per-frame encode cost stopped being the
-// bottleneck, so the extra dependency in this package was not worth it.
+// The flate package of klauspost/compress was evaluated as a faster DEFLATE
+// encoder and rejected: it ignores the dictionary below level 7, and at level 7
+// it was only ~1.25x cheaper while producing ~6% more bytes overall on real
+// traffic. Once the shared frame cache removed the fan-out multiplier, per-frame
+// encode cost stopped being the bottleneck, so DEFLATE stays on compress/flate.
+// The module is a dependency all the same, for its zstd package, which
+// ZstdFrameCodec is built on.
 const frameCompressionLevel = 6
 
 var (
@@ -91,11 +94,17 @@ type DeflateFrameCodec struct {
 	dict  []byte
 	wPool sync.Pool
 	rPool sync.Pool
+
+	// deflated caches DeflateDictionary of dict, which every connection that
+	// does not hold the dictionary yet is sent.
+	deflateOnce sync.Once
+	deflated    []byte
 }
 
 // NewDeflateFrameCodec builds a codec for the given dictionary. id identifies
 // the dictionary content so both sides can tell which one a frame was built
-// with.
+// with, and should be DictionaryID(dict). The codec trusts both as given, see
+// NewVerifiedDeflateFrameCodec for content that comes from outside the process.
 func NewDeflateFrameCodec(id string, dict []byte) *DeflateFrameCodec {
 	c := &DeflateFrameCodec{id: id, dict: dict}
 	c.wPool.New = func() any {
@@ -114,6 +123,15 @@ func (c *DeflateFrameCodec) ID() string { return c.id }
 // Dict returns the raw dictionary bytes. The result must not be modified.
 func (c *DeflateFrameCodec) Dict() []byte { return c.dict }
 
+// deflatedDict returns DeflateDictionary of the codec dictionary, computing it
+// once. The result must not be modified.
+func (c *DeflateFrameCodec) deflatedDict() []byte {
+	c.deflateOnce.Do(func() {
+		c.deflated = DeflateDictionary(c.dict)
+	})
+	return c.deflated
+}
+
 // Compress encodes src into a framed payload appended to dst.
 //
 // It falls back to FrameCodecRaw whenever compression does not actually shrink
@@ -139,36 +157,88 @@ func (c *DeflateFrameCodec) Compress(dst, src []byte) []byte {
 // Decompress decodes a framed payload produced by Compress, appending the result
 // to dst. maxSize bounds the decompressed output, pass 0 to leave it unbounded.
 func (c *DeflateFrameCodec) Decompress(dst, frame []byte, maxSize int) ([]byte, error) {
+	bb := ByteBuffer{B: dst}
+	if err := c.DecompressTo(&bb, frame, maxSize); err != nil {
+		return nil, err
+	}
+	return bb.B, nil
+}
+
+// DecompressTo is Decompress appending to bb instead of a slice, for the read
+// path where every inbound frame is decompressed and dropped shortly after.
+// Taking bb from GetByteBuffer and releasing it with PutByteBuffer once the
+// frame is decoded makes decompression allocation free in the steady state.
+//
+// On error bb is left as it was.
+func (c *DeflateFrameCodec) DecompressTo(bb *ByteBuffer, frame []byte, maxSize int) error {
 	if len(frame) == 0 {
-		return nil, ErrEmptyFrame
+		return ErrEmptyFrame
 	}
 	switch frame[0] {
 	case FrameCodecRaw:
-		return append(dst, frame[1:]...), nil
+		bb.B = append(bb.B, frame[1:]...)
+		return nil
 	case FrameCodecCompressed:
 	default:
-		return nil, ErrUnknownFrameCodec
+		return ErrUnknownFrameCodec
 	}
+	src := frameSourcePool.Get().(*bytes.Reader)
+	src.Reset(frame[1:])
 	r := c.rPool.Get().(io.ReadCloser)
-	defer c.rPool.Put(r)
-	if err := r.(flate.Resetter).Reset(bytes.NewReader(frame[1:]), c.dict); err != nil {
-		return nil, err
+	defer func() {
+		c.rPool.Put(r)
+		// The reader keeps src until its next reset, so src must not keep the
+		// frame.
+		src.Reset(nil)
+		frameSourcePool.Put(src)
+	}()
+	if err := r.(flate.Resetter).Reset(src, c.dict); err != nil {
+		return err
 	}
-	var rd io.Reader = r
-	if maxSize > 0 {
-		// Read one byte past the limit so an oversized frame is detected rather
-		// than silently truncated.
-		rd = io.LimitReader(r, int64(maxSize)+1)
-	}
-	out := bytes.NewBuffer(dst)
-	n, err := out.ReadFrom(rd)
-	if err != nil {
-		return nil, err
-	}
-	if maxSize > 0 && int(n) > maxSize {
-		return nil, ErrFrameTooLarge
+	return readFrame(bb, r, maxSize)
+}
+
+// frameSourcePool holds the readers frames are decompressed from, which would
+// otherwise be the one allocation left on the read path.
+var frameSourcePool = sync.Pool{
+	New: func() any { return bytes.NewReader(nil) },
+}
+
+// readFrameGrowth is the minimum number of bytes readFrame grows a buffer by.
+const readFrameGrowth = 512
+
+// readFrame reads r to the end, appending to bb, and fails with ErrFrameTooLarge
+// as soon as more than maxSize bytes were read - without reading further, so a
+// small crafted frame cannot be inflated into a large allocation. A non-positive
+// maxSize leaves the output unbounded. On error bb is left as it was.
+func readFrame(bb *ByteBuffer, r io.Reader, maxSize int) error {
+	start := len(bb.B)
+	for {
+		if len(bb.B) == cap(bb.B) {
+			bb.B = slices.Grow(bb.B, readFrameGrowth)
+		}
+		buf := bb.B[len(bb.B):cap(bb.B)]
+		if maxSize > 0 {
+			// Read one byte past the limit so an oversized frame is detected
+			// rather than silently truncated.
+			if limit := start + maxSize + 1 - len(bb.B); len(buf) > limit {
+				buf = buf[:limit]
+			}
+		}
+		n, err := r.Read(buf)
+		bb.B = bb.B[:len(bb.B)+n]
+		if maxSize > 0 && len(bb.B)-start > maxSize {
+			bb.B = bb.B[:start]
+			return ErrFrameTooLarge
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			bb.B = bb.B[:start]
+			return err
+		}
 	}
-	return out.Bytes(), nil
 }
 
 // DeflateDictionary compresses dictionary content with raw DEFLATE and no preset
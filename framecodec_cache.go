@@ -0,0 +1,148 @@
+package protocol
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"sync/atomic"
+)
+
+// frameCacheKey identifies a compressed frame: the dictionary it was compressed
+// against and the content it was compressed from.
+//
+// The content is identified by its SHA-256 rather than a cheaper hash, because
+// the cache does not keep the content to compare against - a collision would
+// hand one subscriber another's publication. Hashing is still a small fraction
+// of what compressing the frame costs.
+type frameCacheKey struct {
+	dict string
+	sum  [sha256.Size]byte
+}
+
+type frameCacheEntry struct {
+	key   frameCacheKey
+	frame []byte
+}
+
+// frameCacheCall is a compression in flight, which concurrent callers with the
+// same key wait for instead of compressing the same frame again.
+type frameCacheCall struct {
+	done  chan struct{}
+	frame []byte
+}
+
+// FrameCacheStats are the counters of a FrameCache.
+type FrameCacheStats struct {
+	// Hits is the number of frames served without compressing, including those
+	// which waited for a concurrent compression of the same frame.
+	Hits uint64
+	// Misses is the number of frames compressed.
+	Misses uint64
+	// Entries and Bytes describe what is cached at the moment.
+	Entries int
+	Bytes   int
+}
+
+// FrameCache caches frames compressed by DeflateFrameCodec, so that a frame
+// sent to many connections sharing a dictionary is compressed once rather than
+// once per connection.
+//
+// This is what makes per-frame compression affordable for fan-out: the same
+// publication sent to 50k subscribers is the same input to the same codec, and
+// compressing it 50k times would dominate everything else the server does with
+// it. Frames are keyed by dictionary id and content hash, so connections on
+// different dictionaries never share a frame. Concurrent requests for a frame
+// which is being compressed wait for that compression rather than starting their
+// own, which matters since fan-out delivers the same frame to many connections
+// at the same moment.
+//
+// The cache is bounded by the total size of the compressed frames it holds and
+// evicts the least recently used ones. A FrameCache is safe for concurrent use.
+type FrameCache struct {
+	maxBytes int
+
+	mu       sync.Mutex
+	bytes    int
+	entries  map[frameCacheKey]*list.Element
+	lru      *list.List
+	inflight map[frameCacheKey]*frameCacheCall
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewFrameCache creates a FrameCache holding at most maxBytes of compressed
+// frames. Frames larger than that on their own are compressed but not cached.
+func NewFrameCache(maxBytes int) *FrameCache {
+	return &FrameCache{
+		maxBytes: maxBytes,
+		entries:  make(map[frameCacheKey]*list.Element),
+		lru:      list.New(),
+		inflight: make(map[frameCacheKey]*frameCacheCall),
+	}
+}
+
+// Compress returns c.Compress(dst, src), from the cache when the same src was
+// compressed against the same dictionary before. The framed payload is always
+// appended to dst, so the caller may modify the result freely.
+func (fc *FrameCache) Compress(c *DeflateFrameCodec, dst, src []byte) []byte {
+	key := frameCacheKey{dict: c.ID(), sum: sha256.Sum256(src)}
+
+	fc.mu.Lock()
+	if el, ok := fc.entries[key]; ok {
+		fc.lru.MoveToFront(el)
+		frame := el.Value.(*frameCacheEntry).frame
+		fc.mu.Unlock()
+		fc.hits.Add(1)
+		return append(dst, frame...)
+	}
+	if call, ok := fc.inflight[key]; ok {
+		fc.mu.Unlock()
+		<-call.done
+		fc.hits.Add(1)
+		return append(dst, call.frame...)
+	}
+	call := &frameCacheCall{done: make(chan struct{})}
+	fc.inflight[key] = call
+	fc.mu.Unlock()
+
+	fc.misses.Add(1)
+	call.frame = c.Compress(nil, src)
+
+	fc.mu.Lock()
+	delete(fc.inflight, key)
+	fc.add(key, call.frame)
+	fc.mu.Unlock()
+	close(call.done)
+	return append(dst, call.frame...)
+}
+
+// add caches a frame, evicting the least recently used ones to make room. It
+// must be called with mu held.
+func (fc *FrameCache) add(key frameCacheKey, frame []byte) {
+	if len(frame) > fc.maxBytes {
+		return
+	}
+	for fc.bytes+len(frame) > fc.maxBytes {
+		el := fc.lru.Back()
+		e := el.Value.(*frameCacheEntry)
+		fc.lru.Remove(el)
+		delete(fc.entries, e.key)
+		fc.bytes -= len(e.frame)
+	}
+	fc.entries[key] = fc.lru.PushFront(&frameCacheEntry{key: key, frame: frame})
+	fc.bytes += len(frame)
+}
+
+// Stats returns the counters collected so far.
+func (fc *FrameCache) Stats() FrameCacheStats {
+	fc.mu.Lock()
+	entries, bytes := fc.lru.Len(), fc.bytes
+	fc.mu.Unlock()
+	return FrameCacheStats{
+		Hits:    fc.hits.Load(),
+		Misses:  fc.misses.Load(),
+		Entries: entries,
+		Bytes:   bytes,
+	}
+}
@@ -0,0 +1,95 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameCache(t *testing.T) {
+	v1 := NewDeflateFrameCodec("v1", []byte(`{"push":{"channel":"","pub":{"data":`))
+	v2 := NewDeflateFrameCodec("v2", []byte(`{"push":{"channel":"","join":{"info":`))
+	fc := NewFrameCache(1 << 20)
+	msg := []byte(`{"push":{"channel":"news","pub":{"data":{"title":"hello"}}}}`)
+
+	first := fc.Compress(v1, nil, msg)
+	require.Equal(t, v1.Compress(nil, msg), first)
+	second := fc.Compress(v1, []byte("prefix"), msg)
+	require.Equal(t, append([]byte("prefix"), first...), second)
+	s := fc.Stats()
+	require.EqualValues(t, 1, s.Misses)
+	require.EqualValues(t, 1, s.Hits)
+	require.Equal(t, 1, s.Entries)
+	require.Equal(t, len(first), s.Bytes)
+
+	// The result is the caller's, changing it must not affect the cache.
+	first[len(first)-1]++
+	require.Equal(t, v1.Compress(nil, msg), fc.Compress(v1, nil, msg))
+
+	// A different dictionary never shares a frame.
+	require.Equal(t, v2.Compress(nil, msg), fc.Compress(v2, nil, msg))
+	require.EqualValues(t, 2, fc.Stats().Misses)
+}
+
+func TestFrameCache_Eviction(t *testing.T) {
+	c := NewDeflateFrameCodec("v1", nil)
+	frames := make([][]byte, 10)
+	for i := range frames {
+		frames[i] = bytes.Repeat([]byte{byte('a' + i)}, 1000)
+	}
+	size := len(c.Compress(nil, frames[0]))
+	fc := NewFrameCache(3 * size)
+	for i := 0; i < 3; i++ {
+		fc.Compress(c, nil, frames[i])
+	}
+	// Touch the oldest, so the second one is evicted next.
+	fc.Compress(c, nil, frames[0])
+	fc.Compress(c, nil, frames[3])
+	s := fc.Stats()
+	require.Equal(t, 3, s.Entries)
+	require.LessOrEqual(t, s.Bytes, 3*size)
+
+	misses := s.Misses
+	fc.Compress(c, nil, frames[0])
+	require.Equal(t, misses, fc.Stats().Misses)
+	fc.Compress(c, nil, frames[1])
+	require.Equal(t, misses+1, fc.Stats().Misses)
+
+	// Too large to be cached at all, but still compressed.
+	big := bytes.Repeat([]byte(fmt.Sprint(12345)), 100000)
+	out, err := c.Decompress(nil, fc.Compress(c, nil, big), 0)
+	require.NoError(t, err)
+	require.Equal(t, big, out)
+	require.LessOrEqual(t, fc.Stats().Bytes, 3*size)
+}
+
+// Fan-out hands the same frame to many connections at once, it must be
+// compressed once no matter how the calls interleave.
+func TestFrameCache_ConcurrentDeduplication(t *testing.T) {
+	c := NewDeflateFrameCodec("v1", nil)
+	fc := NewFrameCache(1 << 20)
+	msg := bytes.Repeat([]byte(`{"push":{"channel":"news","pub":{"data":{"title":"hello"}}}}`), 500)
+	want := c.Compress(nil, msg)
+
+	const n = 64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if got := fc.Compress(c, nil, msg); !bytes.Equal(want, got) {
+				t.Errorf("unexpected frame")
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	s := fc.Stats()
+	require.EqualValues(t, 1, s.Misses)
+	require.EqualValues(t, n-1, s.Hits)
+}
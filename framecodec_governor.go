@@ -0,0 +1,209 @@
+package protocol
+
+import "time"
+
+// Defaults of CompressionGovernorConfig, used for fields left at zero.
+const (
+	defaultGovernorMaxRatio      = 0.9
+	defaultGovernorSampleFrames  = 64
+	defaultGovernorProbeInterval = 256
+)
+
+// CompressionGovernorConfig sets the thresholds a CompressionGovernor decides by.
+// Zero fields take the documented defaults.
+type CompressionGovernorConfig struct {
+	// MaxRatio is the largest compressed to raw size ratio, framing included,
+	// at which compression is considered to pay off. Traffic compressing worse
+	// than this over a sample turns compression off. Defaults to 0.9.
+	MaxRatio float64
+	// MaxCostPerKB is the largest compression time worth spending to save one
+	// kilobyte on the wire. Traffic costing more than this over a sample turns
+	// compression off. Zero leaves CPU time out of the decision.
+	MaxCostPerKB time.Duration
+	// SampleFrames is the number of frames a decision is based on, both while
+	// compression is on and while probing. Defaults to 64.
+	SampleFrames int
+	// ProbeInterval is the number of frames sent raw after compression was
+	// turned off before the governor probes whether it pays off again. It
+	// doubles every time a probe fails, up to MaxProbeInterval, and starts over
+	// once one succeeds. Defaults to 256.
+	ProbeInterval int
+	// MaxProbeInterval caps the growth of ProbeInterval. Defaults to 16 times
+	// ProbeInterval.
+	MaxProbeInterval int
+}
+
+// CompressionGovernorStats are the counters of a CompressionGovernor.
+type CompressionGovernorStats struct {
+	// Enabled tells whether frames are currently compressed, probing included.
+	Enabled bool
+	// CompressedFrames is the number of frames passed through the codec, and
+	// RawFrames the number sent raw without trying.
+	CompressedFrames uint64
+	RawFrames        uint64
+	// BytesIn and BytesOut are the sizes of the frames passed through the codec
+	// before and after compression, framing included.
+	BytesIn  uint64
+	BytesOut uint64
+	// CompressTime is the time spent compressing.
+	CompressTime time.Duration
+	// Disables and Enables count the decisions to turn compression off and back
+	// on again.
+	Disables uint64
+	Enables  uint64
+}
+
+// CompressionGovernor decides, for one connection, whether compressing its
+// frames with a DeflateFrameCodec pays off, and falls back to FrameCodecRaw for
+// as long as it does not.
+//
+// Whether compression pays off is a property of the traffic rather than of the
+// codec: a connection receiving already compressed or encrypted payloads burns
+// CPU for nothing, while a neighbour receiving JSON saves most of its bandwidth.
+// The governor measures the ratio and the CPU time achieved over a sample of
+// frames and turns compression off when either crosses its threshold. Traffic
+// changes, so while off it periodically compresses another sample - a probe -
+// and turns compression back on if that one pays off. Failed probes back off.
+//
+// The receiver needs to know nothing of this: frames keep the markers of
+// DeflateFrameCodec, and raw frames are a case every receiver supports anyway.
+//
+// A CompressionGovernor is not safe for concurrent use. The codec it wraps is
+// shared as usual, only the governor is per connection.
+type CompressionGovernor struct {
+	codec *DeflateFrameCodec
+	cfg   CompressionGovernorConfig
+
+	enabled bool
+	// probing is set while compressing a sample with compression turned off.
+	probing bool
+	// left counts down frames until the next decision: to the end of the
+	// sample while enabled, to the next probe while disabled.
+	left          int
+	probeInterval int
+	sampleIn      int
+	sampleOut     int
+	sampleTime    time.Duration
+
+	stats CompressionGovernorStats
+	// now is time.Now, swapped in tests.
+	now func() time.Time
+}
+
+// NewCompressionGovernor creates a CompressionGovernor for one connection using
+// codec. Compression starts enabled.
+func NewCompressionGovernor(codec *DeflateFrameCodec, cfg CompressionGovernorConfig) *CompressionGovernor {
+	if cfg.MaxRatio <= 0 {
+		cfg.MaxRatio = defaultGovernorMaxRatio
+	}
+	if cfg.SampleFrames <= 0 {
+		cfg.SampleFrames = defaultGovernorSampleFrames
+	}
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = defaultGovernorProbeInterval
+	}
+	if cfg.MaxProbeInterval < cfg.ProbeInterval {
+		cfg.MaxProbeInterval = 16 * cfg.ProbeInterval
+	}
+	return &CompressionGovernor{
+		codec:         codec,
+		cfg:           cfg,
+		enabled:       true,
+		left:          cfg.SampleFrames,
+		probeInterval: cfg.ProbeInterval,
+		stats:         CompressionGovernorStats{Enabled: true},
+		now:           time.Now,
+	}
+}
+
+// Codec returns the codec the governor compresses with.
+func (g *CompressionGovernor) Codec() *DeflateFrameCodec { return g.codec }
+
+// Stats returns the counters collected so far.
+func (g *CompressionGovernor) Stats() CompressionGovernorStats { return g.stats }
+
+// Compress encodes src into a framed payload appended to dst, compressed or raw
+// depending on what the governor currently decides.
+func (g *CompressionGovernor) Compress(dst, src []byte) []byte {
+	if !g.enabled {
+		g.stats.RawFrames++
+		g.left--
+		if g.left <= 0 {
+			// Probe: compress the next sample, then decide again.
+			g.enabled = true
+			g.probing = true
+			g.stats.Enabled = true
+			g.left = g.cfg.SampleFrames
+		}
+		dst = append(dst, FrameCodecRaw)
+		return append(dst, src...)
+	}
+
+	start := g.now()
+	n := len(dst)
+	dst = g.codec.Compress(dst, src)
+	elapsed := g.now().Sub(start)
+
+	out := len(dst) - n
+	g.sampleIn += len(src)
+	g.sampleOut += out
+	g.sampleTime += elapsed
+	g.stats.CompressedFrames++
+	g.stats.BytesIn += uint64(len(src))
+	g.stats.BytesOut += uint64(out)
+	g.stats.CompressTime += elapsed
+
+	g.left--
+	if g.left <= 0 {
+		g.decide()
+	}
+	return dst
+}
+
+// decide turns compression off or keeps it on according to the sample just
+// collected, and starts the next one.
+func (g *CompressionGovernor) decide() {
+	pays := g.paysOff()
+	switch {
+	case pays && g.probing:
+		g.stats.Enables++
+		g.probeInterval = g.cfg.ProbeInterval
+	case !pays && g.probing:
+		g.probeInterval *= 2
+		if g.probeInterval > g.cfg.MaxProbeInterval {
+			g.probeInterval = g.cfg.MaxProbeInterval
+		}
+	case !pays:
+		g.stats.Disables++
+	}
+	g.probing = false
+	g.enabled = pays
+	g.stats.Enabled = pays
+	if g.enabled {
+		g.left = g.cfg.SampleFrames
+	} else {
+		g.left = g.probeInterval
+	}
+	g.sampleIn, g.sampleOut, g.sampleTime = 0, 0, 0
+}
+
+// paysOff tells whether the current sample meets the configured thresholds.
+func (g *CompressionGovernor) paysOff() bool {
+	if g.sampleIn == 0 {
+		return true
+	}
+	if float64(g.sampleOut) > g.cfg.MaxRatio*float64(g.sampleIn) {
+		return false
+	}
+	if g.cfg.MaxCostPerKB > 0 {
+		saved := g.sampleIn - g.sampleOut
+		if saved <= 0 {
+			return false
+		}
+		perKB := time.Duration(float64(g.sampleTime) * 1024 / float64(saved))
+		if perKB > g.cfg.MaxCostPerKB {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package protocol
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func incompressibleFrame(i int) []byte {
+	b := make([]byte, 64)
+	x := uint32(i*2654435761 + 1)
+	for j := range b {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		b[j] = byte(x)
+	}
+	return b
+}
+
+func compressibleFrame(i int) []byte {
+	return []byte(fmt.Sprintf(`{"push":{"channel":"chat","pub":{"data":{"text":"hello hello hello hello %d"}}}}`, i%10))
+}
+
+func TestCompressionGovernor_DisablesAndProbes(t *testing.T) {
+	codec := NewDeflateFrameCodec("v1", nil)
+	g := NewCompressionGovernor(codec, CompressionGovernorConfig{SampleFrames: 10, ProbeInterval: 20})
+	receiver := NewDeflateFrameCodec("v1", nil)
+
+	send := func(msg []byte) byte {
+		frame := g.Compress(nil, msg)
+		out, err := receiver.Decompress(nil, frame, 0)
+		require.NoError(t, err)
+		require.Equal(t, msg, out)
+		return frame[0]
+	}
+
+	for i := 0; i < 10; i++ {
+		send(incompressibleFrame(i))
+	}
+	s := g.Stats()
+	require.False(t, s.Enabled)
+	require.EqualValues(t, 1, s.Disables)
+	require.EqualValues(t, 10, s.CompressedFrames)
+
+	// Off: compressible frames go raw until the probe.
+	for i := 0; i < 20; i++ {
+		require.Equal(t, FrameCodecRaw, send(compressibleFrame(i)))
+	}
+	require.EqualValues(t, 20, g.Stats().RawFrames)
+	require.True(t, g.Stats().Enabled)
+
+	// The probe finds compression pays off again.
+	for i := 0; i < 10; i++ {
+		require.Equal(t, FrameCodecCompressed, send(compressibleFrame(i)))
+	}
+	s = g.Stats()
+	require.True(t, s.Enabled)
+	require.EqualValues(t, 1, s.Enables)
+	require.EqualValues(t, 20, s.CompressedFrames)
+	require.Less(t, s.BytesOut, s.BytesIn)
+}
+
+func TestCompressionGovernor_ProbeBackoff(t *testing.T) {
+	g := NewCompressionGovernor(NewDeflateFrameCodec("v1", nil), CompressionGovernorConfig{
+		SampleFrames:     4,
+		ProbeInterval:    8,
+		MaxProbeInterval: 20,
+	})
+	// Collects the lengths of raw runs between probes.
+	var runs []int
+	run := 0
+	for i := 0; i < 200; i++ {
+		before := g.Stats().RawFrames
+		g.Compress(nil, incompressibleFrame(i))
+		if g.Stats().RawFrames > before {
+			run++
+		} else if run > 0 {
+			runs = append(runs, run)
+			run = 0
+		}
+	}
+	require.GreaterOrEqual(t, len(runs), 3)
+	require.Equal(t, []int{8, 16, 20}, runs[:3])
+	require.EqualValues(t, 1, g.Stats().Disables)
+	require.Zero(t, g.Stats().Enables)
+}
+
+func TestCompressionGovernor_CPUThreshold(t *testing.T) {
+	g := NewCompressionGovernor(NewDeflateFrameCodec("v1", nil), CompressionGovernorConfig{
+		SampleFrames: 5,
+		MaxCostPerKB: time.Millisecond,
+	})
+	// Every compression appears to take 5ms.
+	var now time.Time
+	g.now = func() time.Time {
+		now = now.Add(5 * time.Millisecond)
+		return now
+	}
+	for i := 0; i < 5; i++ {
+		g.Compress(nil, compressibleFrame(i))
+	}
+	require.False(t, g.Stats().Enabled)
+	require.Equal(t, 25*time.Millisecond, g.Stats().CompressTime)
+}
+
+func TestCompressionGovernor_StaysOnForCompressibleTraffic(t *testing.T) {
+	g := NewCompressionGovernor(NewDeflateFrameCodec("v1", nil), CompressionGovernorConfig{})
+	for i := 0; i < 1000; i++ {
+		require.Equal(t, FrameCodecCompressed, g.Compress(nil, compressibleFrame(i))[0])
+	}
+	s := g.Stats()
+	require.True(t, s.Enabled)
+	require.Zero(t, s.Disables)
+	require.Zero(t, s.RawFrames)
+}
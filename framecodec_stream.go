@@ -0,0 +1,235 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrStreamWindowTooSmall is returned by NewDeflateStreamCodec for a window too
+// small to hold any useful history.
+var ErrStreamWindowTooSmall = errors.New("centrifugal: stream codec window too small")
+
+// ErrStreamWindowMismatch is returned by DeflateStreamCodec.Decompress for a
+// frame compressed with a larger window than the receiving codec has, whose
+// history can not be what the frame references.
+var ErrStreamWindowMismatch = errors.New("centrifugal: stream codec window larger than the receiver's")
+
+// minStreamWindow is the smallest history window a DeflateStreamCodec accepts.
+// Below it there is not enough history for a frame to reference anything
+// longer than a few keys, while every frame still pays for the extra work.
+const minStreamWindow = 256
+
+// deflateSyncTail is the empty stored block a DEFLATE sync flush ends with.
+// Every frame produced by DeflateStreamCodec would end with it, so it is cut off
+// on the wire and put back before decoding, like permessage-deflate does.
+var deflateSyncTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// deflateFinalBlock is an empty final stored block, appended after the sync tail
+// when decoding so the reader sees a properly terminated stream instead of
+// io.ErrUnexpectedEOF.
+var deflateFinalBlock = []byte{0x01, 0x00, 0x00, 0xff, 0xff}
+
+// DeflateStreamCodec is a stateful sibling of DeflateFrameCodec for one direction
+// of one long-lived connection: every frame is compressed against the shared
+// dictionary and, in addition, against the frames that came before it on the
+// connection - the context takeover of permessage-deflate.
+//
+// Chatty connections repeat themselves far more than any static dictionary can
+// anticipate: the same channel names, the same publication shapes, values which
+// change in a digit or two. Referencing previous frames captures all of that.
+//
+// The price is per-connection memory, which is what window sets: it is the
+// number of bytes of previous frames kept and referenced, clamped to the 32KB
+// DEFLATE window. Unlike permessage-deflate, a connection does not own a DEFLATE
+// writer or reader - those are borrowed from the pools of the shared
+// DeflateFrameCodec, as its own frames do. What a codec holds is the history,
+// window bytes, and on the Decompress side a scratch buffer of the shared
+// dictionary followed by the history, the preset dictionary of the borrowed
+// reader, of up to the dictionary size plus window bytes. So a codec which
+// compresses holds up to window bytes, and one which decompresses up to
+// dictionary size plus twice the window - 96KB with a 32KB dictionary and
+// window - give or take the slack of slice growth. Frames also cost CPU in
+// proportion to window, since the history is run through the borrowed writer
+// before every frame.
+//
+// Frames use the same FrameCodecRaw and FrameCodecCompressed markers as
+// DeflateFrameCodec, and every frame becomes history, raw or compressed. This
+// makes the codec order dependent: frames must be decompressed exactly in the
+// order they were compressed, each exactly once, by a codec with the same shared
+// dictionary and a window at least as large as the sender's. A frame which fails
+// to decompress leaves the receiving side out of sync for good, so a connection
+// must be closed after any Decompress error.
+//
+// Back references count from the end of the history, so a receiver must decode
+// against exactly the history the sender had, no more. Compressed frames carry
+// the window of the sender for that, as a varint right after the marker – two
+// bytes, three for windows over 16KB. The receiver decodes against that much of
+// its own history, and rejects with ErrStreamWindowMismatch a frame from a
+// sender with a larger window than its own.
+//
+// A DeflateStreamCodec carries the history of one direction, so each side of a
+// connection needs one codec to Compress what it sends and another to Decompress
+// what it receives. It is not safe for concurrent use.
+type DeflateStreamCodec struct {
+	shared  *DeflateFrameCodec
+	window  int
+	history []byte
+	// dict is scratch space for the shared dictionary followed by the history,
+	// which is the preset dictionary of the reader on the Decompress side.
+	dict []byte
+}
+
+// NewDeflateStreamCodec creates a DeflateStreamCodec for one direction of a
+// connection, on top of the shared codec all connections of the same
+// dictionary use. window is the history budget in bytes, see DeflateStreamCodec.
+func NewDeflateStreamCodec(shared *DeflateFrameCodec, window int) (*DeflateStreamCodec, error) {
+	if window < minStreamWindow {
+		return nil, ErrStreamWindowTooSmall
+	}
+	if window > maxDictionarySize {
+		window = maxDictionarySize
+	}
+	return &DeflateStreamCodec{shared: shared, window: window}, nil
+}
+
+// ID returns the id of the shared dictionary.
+func (c *DeflateStreamCodec) ID() string { return c.shared.ID() }
+
+// Window returns the history budget in bytes.
+func (c *DeflateStreamCodec) Window() int { return c.window }
+
+// Reset drops the history, as if no frame had been sent yet. Both sides of a
+// direction must do it at the same point of the stream.
+func (c *DeflateStreamCodec) Reset() {
+	c.history = c.history[:0]
+}
+
+// remember appends a frame to the history, keeping at most window bytes.
+func (c *DeflateStreamCodec) remember(frame []byte) {
+	if len(frame) >= c.window {
+		c.history = append(c.history[:0], frame[len(frame)-c.window:]...)
+		return
+	}
+	if keep := c.window - len(frame); len(c.history) > keep {
+		// Shift rather than reslice, so history never grows beyond twice the
+		// window however long the connection lives.
+		n := copy(c.history, c.history[len(c.history)-keep:])
+		c.history = c.history[:n]
+	}
+	c.history = append(c.history, frame...)
+}
+
+// streamSink collects the output of a DEFLATE writer, dropping it while discard
+// is set - the part of the stream encoding the history, which the receiver
+// already has.
+type streamSink struct {
+	buf     []byte
+	discard bool
+}
+
+func (s *streamSink) Write(p []byte) (int, error) {
+	if !s.discard {
+		s.buf = append(s.buf, p...)
+	}
+	return len(p), nil
+}
+
+// Compress encodes src into a framed payload appended to dst, and adds src to
+// the history. Like DeflateFrameCodec.Compress it falls back to FrameCodecRaw
+// when compression does not shrink the frame.
+func (c *DeflateStreamCodec) Compress(dst, src []byte) []byte {
+	sink := &streamSink{buf: make([]byte, 0, len(src)/2+16), discard: true}
+	w := c.shared.wPool.Get().(*flate.Writer)
+	w.Reset(sink)
+	var err error
+	if len(c.history) > 0 {
+		_, err = w.Write(c.history)
+		if err == nil {
+			err = w.Flush()
+		}
+	}
+	sink.discard = false
+	if err == nil {
+		_, err = w.Write(src)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	c.shared.wPool.Put(w)
+	c.remember(src)
+
+	out := bytes.TrimSuffix(sink.buf, deflateSyncTail)
+	var window [binary.MaxVarintLen64]byte
+	windowLen := binary.PutUvarint(window[:], uint64(c.window))
+	if err != nil || windowLen+len(out) >= len(src) {
+		dst = append(dst, FrameCodecRaw)
+		return append(dst, src...)
+	}
+	dst = append(dst, FrameCodecCompressed)
+	dst = append(dst, window[:windowLen]...)
+	return append(dst, out...)
+}
+
+// Decompress decodes the next framed payload produced by the peer's Compress,
+// appending the result to dst and to the history. maxSize bounds the
+// decompressed output, pass 0 to leave it unbounded.
+func (c *DeflateStreamCodec) Decompress(dst, frame []byte, maxSize int) ([]byte, error) {
+	if len(frame) == 0 {
+		return nil, ErrEmptyFrame
+	}
+	switch frame[0] {
+	case FrameCodecRaw:
+		if maxSize > 0 && len(frame)-1 > maxSize {
+			return nil, ErrFrameTooLarge
+		}
+		c.remember(frame[1:])
+		return append(dst, frame[1:]...), nil
+	case FrameCodecCompressed:
+	default:
+		return nil, ErrUnknownFrameCodec
+	}
+
+	window, windowLen := binary.Uvarint(frame[1:])
+	if windowLen <= 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if window > uint64(c.window) {
+		return nil, ErrStreamWindowMismatch
+	}
+	payload := frame[1+windowLen:]
+	// What the sender had of the history, see DeflateStreamCodec.
+	history := c.history
+	if len(history) > int(window) {
+		history = history[len(history)-int(window):]
+	}
+	c.dict = append(append(c.dict[:0], c.shared.dict...), history...)
+	r := c.shared.rPool.Get().(io.ReadCloser)
+	defer c.shared.rPool.Put(r)
+	// The reader is put back into the shared pool, where other codecs expect it
+	// to use the shared dictionary, so it has to be reset with that one again
+	// before it goes - and must not keep a reference to c.dict meanwhile.
+	defer func() { _ = r.(flate.Resetter).Reset(bytes.NewReader(nil), c.shared.dict) }()
+	src := io.MultiReader(bytes.NewReader(payload), bytes.NewReader(deflateSyncTail), bytes.NewReader(deflateFinalBlock))
+	if err := r.(flate.Resetter).Reset(src, c.dict); err != nil {
+		return nil, err
+	}
+	var rd io.Reader = r
+	if maxSize > 0 {
+		rd = io.LimitReader(r, int64(maxSize)+1)
+	}
+	start := len(dst)
+	out := bytes.NewBuffer(dst)
+	n, err := out.ReadFrom(rd)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && int(n) > maxSize {
+		return nil, ErrFrameTooLarge
+	}
+	res := out.Bytes()
+	c.remember(res[start:])
+	return res, nil
+}
@@ -0,0 +1,164 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func streamTestFrame(i int) []byte {
+	return []byte(fmt.Sprintf(`{"push":{"channel":"prices:EURUSD","pub":{"data":{"bid":1.%04d,"ask":1.%04d},"offset":%d}}}`, 1000+i%37, 1002+i%37, 5000+i))
+}
+
+func TestDeflateStreamCodec_RoundTrip(t *testing.T) {
+	shared := NewDeflateFrameCodec("v1", []byte(`{"push":{"channel":"","pub":{"data":`))
+	sender, err := NewDeflateStreamCodec(shared, 4096)
+	require.NoError(t, err)
+	receiver, err := NewDeflateStreamCodec(shared, 4096)
+	require.NoError(t, err)
+
+	var frame, out []byte
+	for i := 0; i < 500; i++ {
+		msg := streamTestFrame(i)
+		if i%50 == 0 {
+			// Incompressible frames go raw, and still become history.
+			msg = make([]byte, 40)
+			for j := range msg {
+				msg[j] = byte(i*31 + j*j*17)
+			}
+		}
+		frame = sender.Compress(frame[:0], msg)
+		out, err = receiver.Decompress(out[:0], frame, 1<<20)
+		require.NoError(t, err)
+		require.Equal(t, msg, out, "frame %d", i)
+	}
+	require.LessOrEqual(t, len(sender.history), 4096)
+	require.Equal(t, sender.history, receiver.history)
+}
+
+// A receiver with a larger window than the sender must decode against what the
+// sender had of the history only.
+func TestDeflateStreamCodec_MismatchedWindows(t *testing.T) {
+	shared := NewDeflateFrameCodec("v1", []byte(`{"push":{"channel":"","pub":{"data":`))
+	sender, err := NewDeflateStreamCodec(shared, 256)
+	require.NoError(t, err)
+	receiver, err := NewDeflateStreamCodec(shared, 4096)
+	require.NoError(t, err)
+
+	var frame, out []byte
+	for i := 0; i < 100; i++ {
+		// Frames longer than the sender window, whose envelope only the shared
+		// dictionary holds for the sender, while the receiver still has it in
+		// its history, at another distance.
+		var data strings.Builder
+		for j := 0; j < 40; j++ {
+			fmt.Fprintf(&data, "%08x", uint32(i*1000+j)*2654435761)
+		}
+		msg := []byte(`{"push":{"channel":"","pub":{"data":"` + data.String() + `"}}}`)
+		frame = sender.Compress(frame[:0], msg)
+		require.Equal(t, FrameCodecCompressed, frame[0])
+		out, err = receiver.Decompress(out[:0], frame, 1<<20)
+		require.NoError(t, err)
+		require.Equal(t, msg, out, "frame %d", i)
+	}
+
+	// The other way round the receiver lacks history the sender references.
+	sender, receiver = receiver, sender
+	sender.Reset()
+	receiver.Reset()
+	_, err = receiver.Decompress(nil, sender.Compress(nil, streamTestFrame(0)), 0)
+	require.ErrorIs(t, err, ErrStreamWindowMismatch)
+}
+
+// Referencing earlier frames is the whole point, it must pay off clearly over
+// compressing every frame on its own.
+func TestDeflateStreamCodec_BeatsPerFrameCompression(t *testing.T) {
+	shared := NewDeflateFrameCodec("v1", nil)
+	sender, err := NewDeflateStreamCodec(shared, 8192)
+	require.NoError(t, err)
+
+	perFrame, stream := 0, 0
+	for i := 0; i < 200; i++ {
+		msg := streamTestFrame(i)
+		perFrame += len(shared.Compress(nil, msg))
+		stream += len(sender.Compress(nil, msg))
+	}
+	require.Less(t, float64(stream), 0.5*float64(perFrame))
+	t.Logf("per frame %d B, with context takeover %d B (%.2fx)", perFrame, stream, float64(perFrame)/float64(stream))
+}
+
+// A bigger window must not compress worse, that is the trade the budget offers.
+func TestDeflateStreamCodec_Window(t *testing.T) {
+	shared := NewDeflateFrameCodec("v1", nil)
+	_, err := NewDeflateStreamCodec(shared, 10)
+	require.ErrorIs(t, err, ErrStreamWindowTooSmall)
+
+	c, err := NewDeflateStreamCodec(shared, 1<<20)
+	require.NoError(t, err)
+	require.Equal(t, maxDictionarySize, c.Window())
+
+	total := func(window int) int {
+		c, err := NewDeflateStreamCodec(shared, window)
+		require.NoError(t, err)
+		n := 0
+		for i := 0; i < 200; i++ {
+			// Frames distinct enough that a small window forgets them.
+			msg := []byte(fmt.Sprintf(`{"channel":"news:%d","data":{"title":"headline number %d"}}`, i%40, i%40))
+			n += len(c.Compress(nil, msg))
+		}
+		return n
+	}
+	require.LessOrEqual(t, total(8192), total(256))
+}
+
+func TestDeflateStreamCodec_Reset(t *testing.T) {
+	shared := NewDeflateFrameCodec("v1", nil)
+	sender, _ := NewDeflateStreamCodec(shared, 4096)
+	receiver, _ := NewDeflateStreamCodec(shared, 4096)
+	for i := 0; i < 10; i++ {
+		_, err := receiver.Decompress(nil, sender.Compress(nil, streamTestFrame(i)), 0)
+		require.NoError(t, err)
+	}
+	sender.Reset()
+	receiver.Reset()
+	msg := streamTestFrame(10)
+	out, err := receiver.Decompress(nil, sender.Compress(nil, msg), 0)
+	require.NoError(t, err)
+	require.Equal(t, msg, out)
+}
+
+func TestDeflateStreamCodec_Errors(t *testing.T) {
+	shared := NewDeflateFrameCodec("v1", nil)
+	sender, _ := NewDeflateStreamCodec(shared, 4096)
+	receiver, _ := NewDeflateStreamCodec(shared, 4096)
+
+	_, err := receiver.Decompress(nil, nil, 0)
+	require.ErrorIs(t, err, ErrEmptyFrame)
+	_, err = receiver.Decompress(nil, []byte{0x7f}, 0)
+	require.ErrorIs(t, err, ErrUnknownFrameCodec)
+
+	big := bytes.Repeat([]byte("A"), 100000)
+	_, err = receiver.Decompress(nil, sender.Compress(nil, big), 1000)
+	require.ErrorIs(t, err, ErrFrameTooLarge)
+	_, err = receiver.Decompress(nil, append([]byte{FrameCodecRaw}, big...), 1000)
+	require.ErrorIs(t, err, ErrFrameTooLarge)
+}
+
+// Stream codecs borrow readers and writers from the shared codec, which must
+// come back unaffected by the history they were used with.
+func TestDeflateStreamCodec_SharedPoolsUnaffected(t *testing.T) {
+	shared := NewDeflateFrameCodec("v1", []byte(`{"push":{"channel":"","pub":{"data":`))
+	sender, _ := NewDeflateStreamCodec(shared, 4096)
+	receiver, _ := NewDeflateStreamCodec(shared, 4096)
+	for i := 0; i < 20; i++ {
+		msg := streamTestFrame(i)
+		_, err := receiver.Decompress(nil, sender.Compress(nil, msg), 0)
+		require.NoError(t, err)
+		out, err := shared.Decompress(nil, shared.Compress(nil, msg), 0)
+		require.NoError(t, err)
+		require.Equal(t, msg, out)
+	}
+}
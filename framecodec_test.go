@@ -90,3 +90,64 @@ func TestFrameCodecDictionaryActuallyApplies(t *testing.T) {
 	}
 	t.Logf("dictionary effect: %d B with vs %d B without (%.2fx)", got, baseline, float64(baseline)/float64(got))
 }
+
+func TestFrameCodecDecompressTo(t *testing.T) {
+	dict := []byte(`{"push":{"id":,"pub":{"data":`)
+	c := NewDeflateFrameCodec("v1", dict)
+	msg := bytes.Repeat([]byte(`{"push":{"id":7,"pub":{"data":{"price":123.45}}}}`), 50)
+	fr := c.Compress(nil, msg)
+	if fr[0] != FrameCodecCompressed {
+		t.Fatalf("expected compressed frame, got marker %#x", fr[0])
+	}
+
+	bb := GetByteBuffer(64)
+	bb.B = append(bb.B, "head"...)
+	if err := c.DecompressTo(bb, fr, len(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bb.B, append([]byte("head"), msg...)) {
+		t.Fatal("round trip mismatch")
+	}
+
+	// A refused frame leaves the buffer as it was.
+	bb.B = append(bb.B[:0], "head"...)
+	if err := c.DecompressTo(bb, fr, len(msg)-1); err != ErrFrameTooLarge {
+		t.Fatalf("expected ErrFrameTooLarge, got %v", err)
+	}
+	if string(bb.B) != "head" {
+		t.Fatalf("buffer modified on error: %q", bb.B)
+	}
+	if err := c.DecompressTo(bb, []byte{FrameCodecCompressed, 0xff, 0xff}, 0); err == nil {
+		t.Fatal("expected error on corrupt frame")
+	}
+	if string(bb.B) != "head" {
+		t.Fatalf("buffer modified on error: %q", bb.B)
+	}
+	PutByteBuffer(bb)
+
+	raw := []byte{FrameCodecRaw, 'h', 'i'}
+	bb = GetByteBuffer(0)
+	if err := c.DecompressTo(bb, raw, 0); err != nil || string(bb.B) != "hi" {
+		t.Fatalf("raw frame: %q %v", bb.B, err)
+	}
+	PutByteBuffer(bb)
+}
+
+func TestFrameCodecDecompressToAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("pooled buffers are dropped under the race detector")
+	}
+	c := NewDeflateFrameCodec("v1", []byte(`{"push":{"id":,"pub":{"data":`))
+	msg := bytes.Repeat([]byte(`{"push":{"id":7,"pub":{"data":{"price":123.45}}}}`), 20)
+	fr := c.Compress(nil, msg)
+	allocs := testing.AllocsPerRun(100, func() {
+		bb := GetByteBuffer(len(msg))
+		if err := c.DecompressTo(bb, fr, 1<<20); err != nil {
+			t.Fatal(err)
+		}
+		PutByteBuffer(bb)
+	})
+	if allocs > 0 {
+		t.Fatalf("expected no allocations, got %v per frame", allocs)
+	}
+}
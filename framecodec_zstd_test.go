@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestZstdFrameCodec_RoundTrip(t *testing.T) {
+	dict := []byte(`{"push":{"channel":"","pub":{"data":`)
+	c, err := NewZstdFrameCodec(DictionaryID(dict), dict)
+	require.NoError(t, err)
+	require.Equal(t, DictionaryID(dict), c.ID())
+	require.Equal(t, dict, c.Dict())
+
+	for i := 0; i < 100; i++ {
+		msg := streamTestFrame(i)
+		frame := c.Compress(nil, msg)
+		out, err := c.Decompress(nil, frame, 1<<20)
+		require.NoError(t, err)
+		require.Equal(t, msg, out)
+	}
+}
+
+func TestZstdFrameCodec_AppendsToDst(t *testing.T) {
+	c, err := NewZstdFrameCodec("", nil)
+	require.NoError(t, err)
+	msg := bytes.Repeat([]byte(`{"channel":"news","data":{}}`), 10)
+	frame := c.Compress([]byte("prefix"), msg)
+	require.Equal(t, []byte("prefix"), frame[:6])
+	require.Equal(t, FrameCodecCompressed, frame[6])
+	out, err := c.Decompress([]byte("head"), frame[6:], 0)
+	require.NoError(t, err)
+	require.Equal(t, append([]byte("head"), msg...), out)
+}
+
+func TestZstdFrameCodec_RawFallback(t *testing.T) {
+	c, err := NewZstdFrameCodec("", nil)
+	require.NoError(t, err)
+	msg := make([]byte, 64)
+	_, _ = rand.Read(msg)
+	frame := c.Compress(nil, msg)
+	require.Equal(t, FrameCodecRaw, frame[0])
+	require.Equal(t, msg, frame[1:])
+	out, err := c.Decompress(nil, frame, 0)
+	require.NoError(t, err)
+	require.Equal(t, msg, out)
+}
+
+func TestZstdFrameCodec_DictionaryApplies(t *testing.T) {
+	dict := bytes.Repeat([]byte(`{"push":{"channel":"prices:EURUSD","pub":{"data":{"bid":1.1000,"ask":1.1002}}}}`), 4)
+	with, err := NewZstdFrameCodec(DictionaryID(dict), dict)
+	require.NoError(t, err)
+	without, err := NewZstdFrameCodec("", nil)
+	require.NoError(t, err)
+
+	msg := streamTestFrame(1)
+	require.Less(t, len(with.Compress(nil, msg)), len(without.Compress(nil, msg)))
+
+	// A frame needs the dictionary it was compressed against.
+	_, err = without.Decompress(nil, with.Compress(nil, msg), 0)
+	require.Error(t, err)
+}
+
+func TestZstdFrameCodec_Errors(t *testing.T) {
+	c, err := NewZstdFrameCodec("", nil)
+	require.NoError(t, err)
+
+	_, err = c.Decompress(nil, nil, 0)
+	require.ErrorIs(t, err, ErrEmptyFrame)
+	_, err = c.Decompress(nil, []byte{0x7f}, 0)
+	require.ErrorIs(t, err, ErrUnknownFrameCodec)
+	_, err = c.Decompress(nil, []byte{FrameCodecCompressed, 1, 2, 3}, 0)
+	require.Error(t, err)
+
+	big := bytes.Repeat([]byte("A"), 100000)
+	frame := c.Compress(nil, big)
+	require.Less(t, len(frame), 1000)
+	_, err = c.Decompress(nil, frame, 1000)
+	require.ErrorIs(t, err, ErrFrameTooLarge)
+	out, err := c.Decompress(nil, frame, len(big))
+	require.NoError(t, err)
+	require.Equal(t, big, out)
+}
+
+func TestZstdFrameCodec_Concurrent(t *testing.T) {
+	dict := []byte(`{"push":{"channel":"","pub":{"data":`)
+	c, err := NewZstdFrameCodec(DictionaryID(dict), dict)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				msg := streamTestFrame(g*1000 + i)
+				out, err := c.Decompress(nil, c.Compress(nil, msg), 1<<20)
+				if err != nil || !bytes.Equal(msg, out) {
+					t.Errorf("goroutine %d frame %d: %v", g, i, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
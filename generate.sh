@@ -44,7 +44,7 @@ require goimports
 echo "generating Protobuf code..."
 protoc --go_out=. --plugin protoc-gen-go="$(command -v protoc-gen-go)" --go-vtproto_out=. \
   --plugin protoc-gen-go-vtproto="$(command -v protoc-gen-go-vtproto)" \
-  --go-vtproto_opt=features=marshal+unmarshal+size \
+  --go-vtproto_opt=features=marshal+unmarshal+size+unmarshal_unsafe \
   client.proto
 
 # protoc writes into a directory tree matching go_package, move results to the repo root.
@@ -79,7 +79,12 @@ rm -rf build
 # Replace usage of jwriter.Writer with the custom writer from encode_writer.go and
 # usage of jwriter package constants with local writer constants. Note: not using
 # `sed -i` here since its syntax differs between GNU and BSD sed.
-sed -e 's/jwriter\.W/w/g' -e 's/jwriter\.N/n/g' client.pb_easyjson.go > client.pb_easyjson.go.tmp
+#
+# Raw payloads are written with writer.RawPayload rather than with their
+# MarshalJSON, so that encoders of TypeJSONLengthPrefixed can keep them intact.
+sed -e 's/jwriter\.W/w/g' -e 's/jwriter\.N/n/g' \
+  -e 's/out\.Raw((in\.\([A-Za-z]*\))\.MarshalJSON())/out.RawPayload(in.\1)/' \
+  client.pb_easyjson.go > client.pb_easyjson.go.tmp
 mv client.pb_easyjson.go.tmp client.pb_easyjson.go
 # Cleanup formatting.
 goimports -w client.pb_easyjson.go
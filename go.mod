@@ -3,6 +3,7 @@ module github.com/centrifugal/protocol
 go 1.25.0
 
 require (
+	github.com/klauspost/compress v1.20.1
 	github.com/mailru/easyjson v0.7.7
 	github.com/planetscale/vtprotobuf v0.6.0
 	github.com/segmentio/encoding v0.5.3
@@ -0,0 +1,412 @@
+package protocol
+
+import (
+	"io"
+	"sync"
+)
+
+// Observer receives what the instrumented wrappers measure: InstrumentPushEncoder,
+// InstrumentReplyEncoder, InstrumentDataEncoder, InstrumentCommandDecoder,
+// InstrumentStreamCommandDecoder and InstrumentDeflateFrameCodec.
+//
+// It is the bridge to whatever metrics library a server uses, which this
+// package does not depend on. Methods are called synchronously on the encoding
+// and decoding paths, possibly from many goroutines at once, so an
+// implementation must be safe for concurrent use and should do little more than
+// update counters. MemoryObserver is an implementation keeping everything in
+// memory, meant for tests.
+type Observer interface {
+	// ObserveEncode is called for every message encoded, with its frame type
+	// and its size in bytes.
+	ObserveEncode(frameType FrameType, size int)
+	// ObserveDecode is called for every message decoded, with its frame type
+	// and the number of bytes it took in the frame or stream, framing included.
+	ObserveDecode(frameType FrameType, size int)
+	// ObserveFrame is called for every transport frame built, with the number
+	// of messages in it and its size in bytes.
+	ObserveFrame(messages, size int)
+	// ObserveCompress is called for every frame compressed, with its size
+	// before and after. The compressed size includes the codec marker, so it
+	// exceeds the raw size by one for frames sent raw.
+	ObserveCompress(rawSize, frameSize int)
+	// ObserveDecompress is called for every frame decompressed, with its size
+	// before and after.
+	ObserveDecompress(frameSize, rawSize int)
+}
+
+// InstrumentPushEncoder returns a PushEncoder encoding with e and reporting
+// every push encoded to o.
+func InstrumentPushEncoder(e PushEncoder, o Observer) PushEncoder {
+	return &instrumentedPushEncoder{e: e, o: o}
+}
+
+type instrumentedPushEncoder struct {
+	e PushEncoder
+	o Observer
+}
+
+func (e *instrumentedPushEncoder) observe(frameType FrameType, data []byte, err error) ([]byte, error) {
+	if err == nil {
+		e.o.ObserveEncode(frameType, len(data))
+	}
+	return data, err
+}
+
+func (e *instrumentedPushEncoder) Encode(message *Push) ([]byte, error) {
+	data, err := e.e.Encode(message)
+	return e.observe(FrameTypeOfPush(message), data, err)
+}
+
+func (e *instrumentedPushEncoder) EncodeMessage(message *Message, reuse ...[]byte) ([]byte, error) {
+	data, err := e.e.EncodeMessage(message, reuse...)
+	return e.observe(FrameTypePushMessage, data, err)
+}
+
+func (e *instrumentedPushEncoder) EncodePublication(message *Publication, reuse ...[]byte) ([]byte, error) {
+	data, err := e.e.EncodePublication(message, reuse...)
+	return e.observe(FrameTypePushPublication, data, err)
+}
+
+func (e *instrumentedPushEncoder) EncodeJoin(message *Join, reuse ...[]byte) ([]byte, error) {
+	data, err := e.e.EncodeJoin(message, reuse...)
+	return e.observe(FrameTypePushJoin, data, err)
+}
+
+func (e *instrumentedPushEncoder) EncodeLeave(message *Leave, reuse ...[]byte) ([]byte, error) {
+	data, err := e.e.EncodeLeave(message, reuse...)
+	return e.observe(FrameTypePushLeave, data, err)
+}
+
+func (e *instrumentedPushEncoder) EncodeUnsubscribe(message *Unsubscribe, reuse ...[]byte) ([]byte, error) {
+	data, err := e.e.EncodeUnsubscribe(message, reuse...)
+	return e.observe(FrameTypePushUnsubscribe, data, err)
+}
+
+func (e *instrumentedPushEncoder) EncodeSubscribe(message *Subscribe, reuse ...[]byte) ([]byte, error) {
+	data, err := e.e.EncodeSubscribe(message, reuse...)
+	return e.observe(FrameTypePushSubscribe, data, err)
+}
+
+func (e *instrumentedPushEncoder) EncodeConnect(message *Connect, reuse ...[]byte) ([]byte, error) {
+	data, err := e.e.EncodeConnect(message, reuse...)
+	return e.observe(FrameTypePushConnect, data, err)
+}
+
+func (e *instrumentedPushEncoder) EncodeDisconnect(message *Disconnect, reuse ...[]byte) ([]byte, error) {
+	data, err := e.e.EncodeDisconnect(message, reuse...)
+	return e.observe(FrameTypePushDisconnect, data, err)
+}
+
+func (e *instrumentedPushEncoder) EncodeRefresh(message *Refresh, reuse ...[]byte) ([]byte, error) {
+	data, err := e.e.EncodeRefresh(message, reuse...)
+	return e.observe(FrameTypePushRefresh, data, err)
+}
+
+// InstrumentReplyEncoder returns a ReplyEncoder encoding with e and reporting
+// every reply encoded to o, under FrameTypeOfReply.
+func InstrumentReplyEncoder(e ReplyEncoder, o Observer) ReplyEncoder {
+	return &instrumentedReplyEncoder{e: e, o: o}
+}
+
+type instrumentedReplyEncoder struct {
+	e ReplyEncoder
+	o Observer
+}
+
+func (e *instrumentedReplyEncoder) Encode(r *Reply) ([]byte, error) {
+	data, err := e.e.Encode(r)
+	if err == nil {
+		e.o.ObserveEncode(FrameTypeOfReply(r), len(data))
+	}
+	return data, err
+}
+
+// InstrumentDataEncoder returns a DataEncoder building frames with e and
+// reporting every frame finished to o.
+//
+// The wrapper is not pooled: return e, not the wrapper, to PutDataEncoder.
+func InstrumentDataEncoder(e DataEncoder, o Observer) DataEncoder {
+	return &instrumentedDataEncoder{e: e, o: o}
+}
+
+type instrumentedDataEncoder struct {
+	e        DataEncoder
+	o        Observer
+	messages int
+}
+
+func (e *instrumentedDataEncoder) Reset() {
+	e.messages = 0
+	e.e.Reset()
+}
+
+func (e *instrumentedDataEncoder) Encode(data []byte) error {
+	if err := e.e.Encode(data); err != nil {
+		return err
+	}
+	e.messages++
+	return nil
+}
+
+func (e *instrumentedDataEncoder) Finish() []byte {
+	data := e.e.Finish()
+	e.o.ObserveFrame(e.messages, len(data))
+	return data
+}
+
+func (e *instrumentedDataEncoder) FinishNoCopy() []byte {
+	data := e.e.FinishNoCopy()
+	e.o.ObserveFrame(e.messages, len(data))
+	return data
+}
+
+// framePositioner is implemented by the CommandDecoders of this package, which
+// report the offset in the frame of the next command to decode.
+type framePositioner interface {
+	framePosition() int
+}
+
+// InstrumentCommandDecoder returns a CommandDecoder decoding with d and
+// reporting every command decoded to o, under FrameTypeOfCommand. Sizes are
+// known for the decoders of this package only, commands decoded by others are
+// reported with a zero size.
+//
+// The wrapper is not pooled: return d, not the wrapper, to PutCommandDecoder.
+func InstrumentCommandDecoder(d CommandDecoder, o Observer) CommandDecoder {
+	return &instrumentedCommandDecoder{d: d, o: o}
+}
+
+type instrumentedCommandDecoder struct {
+	d        CommandDecoder
+	o        Observer
+	frameLen int
+}
+
+func (d *instrumentedCommandDecoder) Reset(data []byte) error {
+	d.frameLen = len(data)
+	return d.d.Reset(data)
+}
+
+func (d *instrumentedCommandDecoder) Decode() (*Command, error) {
+	positioner, ok := d.d.(framePositioner)
+	var start int
+	if ok {
+		start = positioner.framePosition()
+	}
+	cmd, err := d.d.Decode()
+	if cmd != nil {
+		var size int
+		if ok {
+			if err == io.EOF {
+				// The last command takes the rest of the frame.
+				size = d.frameLen - start
+			} else {
+				size = positioner.framePosition() - start
+			}
+		}
+		d.o.ObserveDecode(FrameTypeOfCommand(cmd), size)
+	}
+	return cmd, err
+}
+
+// InstrumentStreamCommandDecoder returns a StreamCommandDecoder decoding with d
+// and reporting every command decoded to o, under FrameTypeOfCommand and with
+// the size d attributes to it.
+//
+// The wrapper is not pooled: return d, not the wrapper, to
+// PutStreamCommandDecoder.
+func InstrumentStreamCommandDecoder(d StreamCommandDecoder, o Observer) StreamCommandDecoder {
+	return &instrumentedStreamCommandDecoder{d: d, o: o}
+}
+
+type instrumentedStreamCommandDecoder struct {
+	d StreamCommandDecoder
+	o Observer
+}
+
+func (d *instrumentedStreamCommandDecoder) Decode() (*Command, int, error) {
+	cmd, size, err := d.d.Decode()
+	if cmd != nil {
+		d.o.ObserveDecode(FrameTypeOfCommand(cmd), size)
+	}
+	return cmd, size, err
+}
+
+func (d *instrumentedStreamCommandDecoder) Reset(reader io.Reader, messageSizeLimit int64) {
+	d.d.Reset(reader, messageSizeLimit)
+}
+
+// InstrumentedDeflateFrameCodec is a DeflateFrameCodec reporting every frame it
+// compresses and decompresses to an Observer, see InstrumentDeflateFrameCodec.
+type InstrumentedDeflateFrameCodec struct {
+	*DeflateFrameCodec
+	o Observer
+}
+
+// InstrumentDeflateFrameCodec returns a codec compressing and decompressing
+// with c and reporting every frame to o. Like c it is safe for concurrent use.
+func InstrumentDeflateFrameCodec(c *DeflateFrameCodec, o Observer) *InstrumentedDeflateFrameCodec {
+	return &InstrumentedDeflateFrameCodec{DeflateFrameCodec: c, o: o}
+}
+
+// Compress is DeflateFrameCodec.Compress.
+func (c *InstrumentedDeflateFrameCodec) Compress(dst, src []byte) []byte {
+	n := len(dst)
+	dst = c.DeflateFrameCodec.Compress(dst, src)
+	c.o.ObserveCompress(len(src), len(dst)-n)
+	return dst
+}
+
+// Decompress is DeflateFrameCodec.Decompress.
+func (c *InstrumentedDeflateFrameCodec) Decompress(dst, frame []byte, maxSize int) ([]byte, error) {
+	n := len(dst)
+	dst, err := c.DeflateFrameCodec.Decompress(dst, frame, maxSize)
+	if err == nil {
+		c.o.ObserveDecompress(len(frame), len(dst)-n)
+	}
+	return dst, err
+}
+
+// DecompressTo is DeflateFrameCodec.DecompressTo.
+func (c *InstrumentedDeflateFrameCodec) DecompressTo(bb *ByteBuffer, frame []byte, maxSize int) error {
+	n := len(bb.B)
+	if err := c.DeflateFrameCodec.DecompressTo(bb, frame, maxSize); err != nil {
+		return err
+	}
+	c.o.ObserveDecompress(len(frame), len(bb.B)-n)
+	return nil
+}
+
+// MessageStats counts messages of one frame type.
+type MessageStats struct {
+	Count int
+	Bytes int
+}
+
+// FrameStats counts transport frames.
+type FrameStats struct {
+	Count    int
+	Messages int
+	Bytes    int
+}
+
+// CompressionStats counts frames compressed or decompressed.
+type CompressionStats struct {
+	Count int
+	// RawBytes is the total size of the frames uncompressed, and FrameBytes
+	// compressed.
+	RawBytes   int
+	FrameBytes int
+}
+
+// Ratio returns RawBytes divided by FrameBytes, or zero if nothing was counted.
+func (s CompressionStats) Ratio() float64 {
+	if s.FrameBytes == 0 {
+		return 0
+	}
+	return float64(s.RawBytes) / float64(s.FrameBytes)
+}
+
+// MemoryObserver is an Observer keeping totals in memory. It is meant for tests
+// - a server should report to its metrics library instead. The zero value is
+// ready to use, and a MemoryObserver is safe for concurrent use.
+type MemoryObserver struct {
+	mu           sync.Mutex
+	encoded      map[FrameType]MessageStats
+	decoded      map[FrameType]MessageStats
+	frames       FrameStats
+	compressed   CompressionStats
+	decompressed CompressionStats
+}
+
+var _ Observer = (*MemoryObserver)(nil)
+
+// ObserveEncode implements Observer.
+func (o *MemoryObserver) ObserveEncode(frameType FrameType, size int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.encoded == nil {
+		o.encoded = make(map[FrameType]MessageStats)
+	}
+	s := o.encoded[frameType]
+	s.Count++
+	s.Bytes += size
+	o.encoded[frameType] = s
+}
+
+// ObserveDecode implements Observer.
+func (o *MemoryObserver) ObserveDecode(frameType FrameType, size int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.decoded == nil {
+		o.decoded = make(map[FrameType]MessageStats)
+	}
+	s := o.decoded[frameType]
+	s.Count++
+	s.Bytes += size
+	o.decoded[frameType] = s
+}
+
+// ObserveFrame implements Observer.
+func (o *MemoryObserver) ObserveFrame(messages, size int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.frames.Count++
+	o.frames.Messages += messages
+	o.frames.Bytes += size
+}
+
+// ObserveCompress implements Observer.
+func (o *MemoryObserver) ObserveCompress(rawSize, frameSize int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.compressed.Count++
+	o.compressed.RawBytes += rawSize
+	o.compressed.FrameBytes += frameSize
+}
+
+// ObserveDecompress implements Observer.
+func (o *MemoryObserver) ObserveDecompress(frameSize, rawSize int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.decompressed.Count++
+	o.decompressed.RawBytes += rawSize
+	o.decompressed.FrameBytes += frameSize
+}
+
+// Encoded returns the totals of messages of the given frame type encoded so
+// far.
+func (o *MemoryObserver) Encoded(frameType FrameType) MessageStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.encoded[frameType]
+}
+
+// Decoded returns the totals of messages of the given frame type decoded so
+// far.
+func (o *MemoryObserver) Decoded(frameType FrameType) MessageStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.decoded[frameType]
+}
+
+// Frames returns the totals of frames built so far.
+func (o *MemoryObserver) Frames() FrameStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.frames
+}
+
+// Compressed returns the totals of frames compressed so far.
+func (o *MemoryObserver) Compressed() CompressionStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.compressed
+}
+
+// Decompressed returns the totals of frames decompressed so far.
+func (o *MemoryObserver) Decompressed() CompressionStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.decompressed
+}
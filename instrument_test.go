@@ -0,0 +1,157 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumentPushEncoder(t *testing.T) {
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		o := &MemoryObserver{}
+		e := InstrumentPushEncoder(GetPushEncoder(protoType), o)
+
+		data, err := e.EncodePublication(&Publication{Data: []byte(`{}`)})
+		require.NoError(t, err)
+		joinData, err := e.Encode(&Push{Channel: "a", Join: &Join{Info: &ClientInfo{User: "u"}}})
+		require.NoError(t, err)
+		_, err = e.EncodePublication(&Publication{Data: []byte(`{"a":1}`)})
+		require.NoError(t, err)
+		_, err = e.EncodeMessage(&Message{Data: []byte(`{}`)})
+		require.NoError(t, err)
+
+		pubs := o.Encoded(FrameTypePushPublication)
+		require.Equal(t, 2, pubs.Count)
+		require.Greater(t, pubs.Bytes, len(data))
+		require.Equal(t, MessageStats{1, len(joinData)}, o.Encoded(FrameTypePushJoin))
+		require.Equal(t, 1, o.Encoded(FrameTypePushMessage).Count)
+		require.Zero(t, o.Encoded(FrameTypePushLeave).Count)
+	}
+}
+
+func TestInstrumentReplyEncoder(t *testing.T) {
+	o := &MemoryObserver{}
+	e := InstrumentReplyEncoder(GetReplyEncoder(TypeJSON), o)
+	data, err := e.Encode(&Reply{Id: 1, Subscribe: &SubscribeResult{}})
+	require.NoError(t, err)
+	_, err = e.Encode(&Reply{})
+	require.NoError(t, err)
+	_, err = e.Encode(&Reply{Push: &Push{Pub: &Publication{}}})
+	require.NoError(t, err)
+	require.Equal(t, MessageStats{1, len(data)}, o.Encoded(FrameTypeSubscribe))
+	require.Equal(t, MessageStats{1, 2}, o.Encoded(FrameTypeServerPing))
+	require.Equal(t, 1, o.Encoded(FrameTypePushPublication).Count)
+}
+
+func TestInstrumentDataEncoder(t *testing.T) {
+	o := &MemoryObserver{}
+	inner := GetDataEncoder(TypeJSON)
+	defer PutDataEncoder(TypeJSON, inner)
+	e := InstrumentDataEncoder(inner, o)
+	require.NoError(t, e.Encode([]byte(`{}`)))
+	require.NoError(t, e.Encode([]byte(`{}`)))
+	require.Len(t, e.Finish(), 5)
+	e.Reset()
+	require.NoError(t, e.Encode([]byte(`{"id":1}`)))
+	require.Len(t, e.FinishNoCopy(), 8)
+	require.Equal(t, FrameStats{Count: 2, Messages: 3, Bytes: 13}, o.Frames())
+}
+
+func TestInstrumentCommandDecoder(t *testing.T) {
+	cmds := []*Command{
+		{Id: 1, Subscribe: &SubscribeRequest{Channel: "a"}},
+		{Id: 2, Publish: &PublishRequest{Channel: "a", Data: []byte(`{"input":"hello"}`)}},
+		{Id: 3, Subscribe: &SubscribeRequest{Channel: "b"}},
+	}
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		frame := encodeCommands(t, protoType, cmds...)
+		o := &MemoryObserver{}
+		inner := GetCommandDecoder(protoType, nil)
+		d := InstrumentCommandDecoder(inner, o)
+		require.NoError(t, d.Reset(frame))
+		for {
+			_, err := d.Decode()
+			if err != nil {
+				require.Equal(t, io.EOF, err)
+				break
+			}
+		}
+		PutCommandDecoder(protoType, inner)
+
+		subs, pubs := o.Decoded(FrameTypeSubscribe), o.Decoded(FrameTypePublish)
+		require.Equal(t, 2, subs.Count, protoType)
+		require.Equal(t, 1, pubs.Count, protoType)
+		// Every byte of the frame is attributed to one of the commands.
+		require.Equal(t, len(frame), subs.Bytes+pubs.Bytes, protoType)
+		want := len(encodeCommands(t, protoType, cmds[1]))
+		if protoType == TypeJSON {
+			// The delimiter after it.
+			want++
+		}
+		require.Equal(t, want, pubs.Bytes, protoType)
+	}
+}
+
+func TestInstrumentStreamCommandDecoder(t *testing.T) {
+	stream := encodeCommands(t, TypeProtobuf, &Command{Id: 1, Rpc: &RPCRequest{}}, &Command{Id: 2, Rpc: &RPCRequest{}})
+	o := &MemoryObserver{}
+	inner := GetStreamCommandDecoderLimited(TypeProtobuf, bytes.NewReader(stream), 1024)
+	defer PutStreamCommandDecoder(TypeProtobuf, inner)
+	d := InstrumentStreamCommandDecoder(inner, o)
+	total := 0
+	for {
+		cmd, size, err := d.Decode()
+		if err != nil {
+			require.Equal(t, io.EOF, err)
+			break
+		}
+		require.NotNil(t, cmd)
+		total += size
+	}
+	require.Equal(t, MessageStats{2, total}, o.Decoded(FrameTypeRPC))
+}
+
+func TestInstrumentDeflateFrameCodec(t *testing.T) {
+	o := &MemoryObserver{}
+	c := InstrumentDeflateFrameCodec(NewDeflateFrameCodec("v1", nil), o)
+	require.Equal(t, "v1", c.ID())
+
+	src := bytes.Repeat([]byte(`{"channel":"news","data":{"value":1}}`), 20)
+	frame := c.Compress([]byte("prefix"), src)[len("prefix"):]
+	require.Equal(t, CompressionStats{1, len(src), len(frame)}, o.Compressed())
+	require.Greater(t, o.Compressed().Ratio(), 5.0)
+
+	out, err := c.Decompress(nil, frame, 0)
+	require.NoError(t, err)
+	require.Equal(t, src, out)
+	bb := GetByteBuffer(len(src))
+	defer PutByteBuffer(bb)
+	require.NoError(t, c.DecompressTo(bb, frame, 0))
+	_, err = c.Decompress(nil, frame, 10)
+	require.ErrorIs(t, err, ErrFrameTooLarge)
+	require.Equal(t, CompressionStats{2, 2 * len(src), 2 * len(frame)}, o.Decompressed())
+}
+
+func TestMemoryObserver_Concurrent(t *testing.T) {
+	o := &MemoryObserver{}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				o.ObserveEncode(FrameTypePushPublication, 10)
+				o.ObserveDecode(FrameTypePublish, 5)
+				o.ObserveFrame(2, 20)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, MessageStats{800, 8000}, o.Encoded(FrameTypePushPublication))
+	require.Equal(t, MessageStats{800, 4000}, o.Decoded(FrameTypePublish))
+	require.Equal(t, FrameStats{800, 1600, 16000}, o.Frames())
+	require.Zero(t, CompressionStats{}.Ratio())
+}
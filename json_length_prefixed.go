@@ -0,0 +1,322 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// NewJSONLengthPrefixedPushEncoder creates a new JSONPushEncoder for
+// TypeJSONLengthPrefixed, which keeps Raw payloads intact. It's safe to use the
+// returned encoder concurrently, see also DefaultJsonLengthPrefixedPushEncoder.
+func NewJSONLengthPrefixedPushEncoder() *JSONPushEncoder {
+	return &JSONPushEncoder{flags: rawIntact}
+}
+
+// NewJSONLengthPrefixedReplyEncoder creates a new JSONReplyEncoder for
+// TypeJSONLengthPrefixed, which keeps Raw payloads intact. It's safe to use the
+// returned encoder concurrently, see also DefaultJsonLengthPrefixedReplyEncoder.
+func NewJSONLengthPrefixedReplyEncoder() *JSONReplyEncoder {
+	return &JSONReplyEncoder{flags: rawIntact}
+}
+
+// NewJSONLengthPrefixedResultEncoder creates a new JSONResultEncoder for
+// TypeJSONLengthPrefixed, which keeps Raw payloads intact. It's safe to use the
+// returned encoder concurrently.
+func NewJSONLengthPrefixedResultEncoder() *JSONResultEncoder {
+	return &JSONResultEncoder{flags: rawIntact}
+}
+
+// NewJSONLengthPrefixedCommandEncoder creates a new JSONCommandEncoder for
+// TypeJSONLengthPrefixed, which keeps Raw payloads intact. The result contains
+// no framing, put commands into a frame with NewJSONLengthPrefixedDataEncoder.
+// It's safe to use the returned encoder concurrently.
+func NewJSONLengthPrefixedCommandEncoder() *JSONCommandEncoder {
+	return &JSONCommandEncoder{flags: rawIntact}
+}
+
+// NewJSONLengthPrefixedDataEncoder creates a new DataEncoder for
+// TypeJSONLengthPrefixed. Its framing – each message prefixed with its length
+// encoded as a varint – is the one of TypeProtobuf, so it is a
+// ProtobufDataEncoder.
+func NewJSONLengthPrefixedDataEncoder() *ProtobufDataEncoder {
+	return NewProtobufDataEncoder()
+}
+
+// nextLengthPrefixed returns the message of a length prefixed frame starting at
+// offset, and the offset of the one after it. It returns io.EOF at the end of
+// the frame, and io.ErrShortBuffer if the length prefix does not match the data
+// which follows it.
+func nextLengthPrefixed(data []byte, offset int) ([]byte, int, error) {
+	if offset >= len(data) {
+		return nil, offset, io.EOF
+	}
+	l, n := binary.Uvarint(data[offset:])
+	if n <= 0 {
+		// Length prefix is truncated or overflows uint64, treat the frame as
+		// fully processed, as ProtobufCommandDecoder does.
+		return nil, offset, io.EOF
+	}
+	from := offset + n
+	to := from + int(l)
+	// The from <= to part also catches an int overflow of the addition above.
+	if l > uint64(len(data)) || from > to || to > len(data) {
+		return nil, offset, io.ErrShortBuffer
+	}
+	return data[from:to], to, nil
+}
+
+// JSONLengthPrefixedCommandDecoder is a CommandDecoder for JSON commands
+// prefixed with their length encoded as a varint.
+//
+// Decoding is zero-copy under the same contract as JSONCommandDecoder: string
+// fields of the returned Command point into the frame, while Raw payload fields
+// are copies.
+type JSONLengthPrefixedCommandDecoder struct {
+	data   []byte
+	offset int
+	index  int
+	limits DecodeLimits
+}
+
+// NewJSONLengthPrefixedCommandDecoder creates a new
+// JSONLengthPrefixedCommandDecoder for the given frame.
+func NewJSONLengthPrefixedCommandDecoder(data []byte) *JSONLengthPrefixedCommandDecoder {
+	return &JSONLengthPrefixedCommandDecoder{
+		data: data,
+	}
+}
+
+// Reset makes the decoder ready to decode commands from the given frame.
+func (d *JSONLengthPrefixedCommandDecoder) Reset(data []byte) error {
+	d.data = data
+	d.offset = 0
+	d.index = 0
+	return nil
+}
+
+// SetLimits makes the decoder enforce the given limits, from the next Decode
+// on. They are kept across Reset.
+func (d *JSONLengthPrefixedCommandDecoder) SetLimits(limits DecodeLimits) {
+	d.limits = limits
+}
+
+// framePosition returns the offset of the next command in the frame.
+func (d *JSONLengthPrefixedCommandDecoder) framePosition() int {
+	return d.offset
+}
+
+func (d *JSONLengthPrefixedCommandDecoder) decodeError(err error) error {
+	return &DecodeError{Type: TypeJSONLengthPrefixed, Index: d.index, Offset: int64(d.offset), Err: err}
+}
+
+// Decode returns the next Command in the frame. The last Command is returned
+// together with io.EOF, see the CommandDecoder interface.
+func (d *JSONLengthPrefixedCommandDecoder) Decode() (*Command, error) {
+	return d.DecodeInto(&Command{})
+}
+
+// DecodeInto is Decode decoding into c rather than into a new Command, see
+// JSONCommandDecoder.DecodeInto.
+func (d *JSONLengthPrefixedCommandDecoder) DecodeInto(c *Command) (*Command, error) {
+	*c = Command{}
+	cmdBytes, next, err := nextLengthPrefixed(d.data, d.offset)
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil {
+		return nil, d.decodeError(err)
+	}
+	if err := d.limits.checkCommandCount(d.index); err != nil {
+		return nil, d.decodeError(err)
+	}
+	if err := d.limits.checkEncodedCommand(TypeJSONLengthPrefixed, cmdBytes); err != nil {
+		return nil, d.decodeError(err)
+	}
+	if _, err := json.Parse(cmdBytes, c, json.ZeroCopy); err != nil {
+		return nil, d.decodeError(err)
+	}
+	d.offset = next
+	d.index++
+	if d.offset == len(d.data) {
+		return c, io.EOF
+	}
+	return c, nil
+}
+
+var _ ReplyDecoder = NewJSONLengthPrefixedReplyDecoder(nil)
+
+// JSONLengthPrefixedReplyDecoder is a ReplyDecoder for JSON replies prefixed
+// with their length encoded as a varint, such as the frame produced by the
+// encoder NewJSONLengthPrefixedDataEncoder returns.
+//
+// Decoding is zero-copy under the same contract as JSONReplyDecoder.
+type JSONLengthPrefixedReplyDecoder struct {
+	data   []byte
+	offset int
+	index  int
+}
+
+// NewJSONLengthPrefixedReplyDecoder creates a new
+// JSONLengthPrefixedReplyDecoder for the given frame.
+func NewJSONLengthPrefixedReplyDecoder(data []byte) *JSONLengthPrefixedReplyDecoder {
+	return &JSONLengthPrefixedReplyDecoder{
+		data: data,
+	}
+}
+
+// Reset makes the decoder ready to decode replies from the given frame.
+func (d *JSONLengthPrefixedReplyDecoder) Reset(data []byte) error {
+	d.data = data
+	d.offset = 0
+	d.index = 0
+	return nil
+}
+
+// framePosition returns the offset of the next reply in the frame.
+func (d *JSONLengthPrefixedReplyDecoder) framePosition() int {
+	return d.offset
+}
+
+// Decode returns the next Reply in the frame, or io.EOF if there are no replies
+// left. It returns a DecodeError wrapping io.ErrShortBuffer if a length prefix
+// does not match the data which follows it.
+func (d *JSONLengthPrefixedReplyDecoder) Decode() (*Reply, error) {
+	replyBytes, next, err := nextLengthPrefixed(d.data, d.offset)
+	if err == io.EOF {
+		return nil, err
+	}
+	if err == nil {
+		var r Reply
+		if _, err = json.Parse(replyBytes, &r, json.ZeroCopy); err == nil {
+			d.offset = next
+			d.index++
+			return &r, nil
+		}
+	}
+	return nil, &DecodeError{Type: TypeJSONLengthPrefixed, Index: d.index, Offset: int64(d.offset), Err: err}
+}
+
+// jsonMessage decodes a message from JSON where a vtUnmarshaler is expected, so
+// that the streams of TypeJSONLengthPrefixed are read as Protobuf streams are,
+// see readStreamMessage.
+type jsonMessage struct {
+	m any
+}
+
+// UnmarshalVT decodes data into the message. It copies what it keeps, as
+// readStreamMessage requires.
+func (j jsonMessage) UnmarshalVT(data []byte) error {
+	_, err := json.Parse(data, j.m, 0)
+	return err
+}
+
+// JSONLengthPrefixedStreamCommandDecoder is a StreamCommandDecoder which reads
+// JSON commands prefixed with their length encoded as a varint.
+type JSONLengthPrefixedStreamCommandDecoder struct {
+	reader           *bufio.Reader
+	messageSizeLimit int64
+	// index and offset locate the next message in the stream, for DecodeError.
+	index  int
+	offset int64
+	limits DecodeLimits
+}
+
+// NewJSONLengthPrefixedStreamCommandDecoder creates a new
+// JSONLengthPrefixedStreamCommandDecoder reading from reader. messageSizeLimit
+// must be positive; a zero or negative value panics, see
+// NewProtobufStreamCommandDecoder.
+func NewJSONLengthPrefixedStreamCommandDecoder(reader io.Reader, messageSizeLimit int64) *JSONLengthPrefixedStreamCommandDecoder {
+	if messageSizeLimit <= 0 {
+		panic(errNonPositiveMessageSizeLimit)
+	}
+	return &JSONLengthPrefixedStreamCommandDecoder{reader: bufio.NewReader(reader), messageSizeLimit: messageSizeLimit}
+}
+
+// Decode returns the next Command from the stream, see the StreamCommandDecoder
+// interface. The size limit is checked against the length prefix before the
+// command is read, so an oversized command is rejected without buffering it.
+func (d *JSONLengthPrefixedStreamCommandDecoder) Decode() (*Command, int, error) {
+	return d.DecodeInto(&Command{})
+}
+
+// DecodeInto is Decode decoding into c rather than into a new Command, see
+// JSONStreamCommandDecoder.DecodeInto.
+func (d *JSONLengthPrefixedStreamCommandDecoder) DecodeInto(c *Command) (*Command, int, error) {
+	*c = Command{}
+	if limitErr := d.limits.checkCommandCount(d.index); limitErr != nil {
+		// Only a command actually there crosses the limit, a stream ending right
+		// at it is not an error.
+		if _, err := d.reader.Peek(1); err != nil {
+			return nil, 0, streamDecodeError(TypeJSONLengthPrefixed, err, d.index, d.offset)
+		}
+		return nil, 0, streamDecodeError(TypeJSONLengthPrefixed, limitErr, d.index, d.offset)
+	}
+	m := limitedCommand{protoType: TypeJSONLengthPrefixed, limits: &d.limits, m: jsonMessage{c}}
+	msgLength, prefixLength, err := readStreamMessage(d.reader, d.messageSizeLimit, m)
+	if err != nil {
+		return nil, 0, streamDecodeError(TypeJSONLengthPrefixed, err, d.index, d.offset)
+	}
+	d.index++
+	d.offset += int64(prefixLength + msgLength)
+	return c, prefixLength + msgLength, nil
+}
+
+// SetLimits makes the decoder enforce the given limits, from the next Decode
+// on. They are kept across Reset.
+func (d *JSONLengthPrefixedStreamCommandDecoder) SetLimits(limits DecodeLimits) {
+	d.limits = limits
+}
+
+// Reset makes the decoder read from the given reader, applying the given message
+// size limit.
+func (d *JSONLengthPrefixedStreamCommandDecoder) Reset(reader io.Reader, messageSizeLimit int64) {
+	d.messageSizeLimit = messageSizeLimit
+	d.reader.Reset(reader)
+	d.index, d.offset = 0, 0
+}
+
+// JSONLengthPrefixedStreamReplyDecoder is a StreamReplyDecoder which reads JSON
+// replies prefixed with their length encoded as a varint, as written by the
+// encoder NewJSONLengthPrefixedDataEncoder returns.
+type JSONLengthPrefixedStreamReplyDecoder struct {
+	reader           *bufio.Reader
+	messageSizeLimit int64
+	index            int
+	offset           int64
+}
+
+// NewJSONLengthPrefixedStreamReplyDecoder creates a new
+// JSONLengthPrefixedStreamReplyDecoder reading from reader. messageSizeLimit
+// must be positive; a zero or negative value panics, see
+// NewProtobufStreamCommandDecoder.
+func NewJSONLengthPrefixedStreamReplyDecoder(reader io.Reader, messageSizeLimit int64) *JSONLengthPrefixedStreamReplyDecoder {
+	if messageSizeLimit <= 0 {
+		panic(errNonPositiveMessageSizeLimit)
+	}
+	return &JSONLengthPrefixedStreamReplyDecoder{reader: bufio.NewReader(reader), messageSizeLimit: messageSizeLimit}
+}
+
+// Decode returns the next Reply from the stream, see the StreamReplyDecoder
+// interface. The size limit is checked against the length prefix before the
+// reply is read, so an oversized reply is rejected without buffering it.
+func (d *JSONLengthPrefixedStreamReplyDecoder) Decode() (*Reply, int, error) {
+	var r Reply
+	msgLength, prefixLength, err := readStreamMessage(d.reader, d.messageSizeLimit, jsonMessage{&r})
+	if err != nil {
+		return nil, 0, streamDecodeError(TypeJSONLengthPrefixed, err, d.index, d.offset)
+	}
+	d.index++
+	d.offset += int64(prefixLength + msgLength)
+	return &r, prefixLength + msgLength, nil
+}
+
+// Reset makes the decoder read from the given reader, applying the given message
+// size limit.
+func (d *JSONLengthPrefixedStreamReplyDecoder) Reset(reader io.Reader, messageSizeLimit int64) {
+	d.messageSizeLimit = messageSizeLimit
+	d.reader.Reset(reader)
+	d.index, d.offset = 0, 0
+}
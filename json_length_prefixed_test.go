@@ -0,0 +1,193 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// multilinePayload is a JSON payload with raw newlines, which TypeJSON strips.
+var multilinePayload = Raw("{\n  \"text\": \"hi\",\r\n  \"n\": 1\n}")
+
+func TestJSONLengthPrefixed_ReplyPayloadIntact(t *testing.T) {
+	reply := &Reply{Push: &Push{Channel: "news", Pub: &Publication{Data: multilinePayload, Offset: 1}}}
+
+	encoder := GetDataEncoder(TypeJSONLengthPrefixed)
+	defer PutDataEncoder(TypeJSONLengthPrefixed, encoder)
+	for range 2 {
+		data, err := GetReplyEncoder(TypeJSONLengthPrefixed).Encode(reply)
+		require.NoError(t, err)
+		require.NoError(t, encoder.Encode(data))
+	}
+	frame := encoder.Finish()
+
+	decoder := GetReplyDecoder(TypeJSONLengthPrefixed, frame)
+	defer PutReplyDecoder(TypeJSONLengthPrefixed, decoder)
+	for range 2 {
+		r, err := decoder.Decode()
+		require.NoError(t, err)
+		require.Equal(t, []byte(multilinePayload), []byte(r.Push.Pub.Data))
+	}
+	_, err := decoder.Decode()
+	require.Equal(t, io.EOF, err)
+
+	// TypeJSON still strips the newlines.
+	data, err := GetReplyEncoder(TypeJSON).Encode(reply)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "\n")
+}
+
+func TestJSONLengthPrefixed_PushAndResultEncoders(t *testing.T) {
+	pub := &Publication{Data: multilinePayload}
+	data, err := GetPushEncoder(TypeJSONLengthPrefixed).EncodePublication(pub)
+	require.NoError(t, err)
+	require.Contains(t, string(data), string(multilinePayload))
+
+	data, err = GetResultEncoder(TypeJSONLengthPrefixed).EncodeRPCResult(&RPCResult{Data: multilinePayload})
+	require.NoError(t, err)
+	require.Contains(t, string(data), string(multilinePayload))
+
+	data, err = GetPushEncoder(TypeJSON).EncodePublication(pub)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "\n")
+}
+
+// encodeJSONLengthPrefixedCommands returns a TypeJSONLengthPrefixed frame of
+// cmds.
+func encodeJSONLengthPrefixedCommands(t *testing.T, cmds ...*Command) []byte {
+	t.Helper()
+	encoder := NewJSONLengthPrefixedDataEncoder()
+	for _, cmd := range cmds {
+		data, err := NewJSONLengthPrefixedCommandEncoder().Encode(cmd)
+		require.NoError(t, err)
+		require.NoError(t, encoder.Encode(data))
+	}
+	return encoder.Finish()
+}
+
+var jsonLengthPrefixedCommands = []*Command{
+	{Id: 1, Publish: &PublishRequest{Channel: "news", Data: multilinePayload}},
+	{Id: 2, Subscribe: &SubscribeRequest{Channel: "news"}},
+	{Id: 3, Rpc: &RPCRequest{Method: "m", Data: multilinePayload}},
+}
+
+func TestJSONLengthPrefixedCommandDecoder(t *testing.T) {
+	frame := encodeJSONLengthPrefixedCommands(t, jsonLengthPrefixedCommands...)
+	cmds := decodeAllCommands(t, TypeJSONLengthPrefixed, frame)
+	require.Len(t, cmds, len(jsonLengthPrefixedCommands))
+	for i := range cmds {
+		require.True(t, proto.Equal(jsonLengthPrefixedCommands[i], cmds[i]), i)
+	}
+	require.Equal(t, []byte(multilinePayload), []byte(cmds[0].Publish.Data))
+
+	decoder := NewJSONLengthPrefixedCommandDecoder(nil)
+	cmd, err := decoder.Decode()
+	require.Nil(t, cmd)
+	require.Equal(t, io.EOF, err)
+}
+
+func TestJSONLengthPrefixedCommandDecoder_Errors(t *testing.T) {
+	frame := encodeJSONLengthPrefixedCommands(t, jsonLengthPrefixedCommands...)
+
+	decoder := NewJSONLengthPrefixedCommandDecoder(frame[:len(frame)-1])
+	var err error
+	for range 2 {
+		_, err = decoder.Decode()
+		require.NoError(t, err)
+	}
+	_, err = decoder.Decode()
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	require.Equal(t, TypeJSONLengthPrefixed, decodeErr.Type)
+	require.Equal(t, 2, decodeErr.Index)
+	require.ErrorIs(t, err, io.ErrShortBuffer)
+
+	_, err = NewJSONLengthPrefixedCommandDecoder([]byte("\x02{x")).Decode()
+	require.True(t, errors.As(err, &decodeErr))
+
+	limited := GetCommandDecoderWithLimits(TypeJSONLengthPrefixed, frame, DecodeLimits{MaxCommands: 2})
+	defer PutCommandDecoder(TypeJSONLengthPrefixed, limited)
+	for range 2 {
+		_, err = limited.Decode()
+		require.NoError(t, err)
+	}
+	_, err = limited.Decode()
+	require.ErrorIs(t, err, ErrDecodeLimitExceeded)
+}
+
+func TestJSONLengthPrefixedStreamCommandDecoder(t *testing.T) {
+	frame := encodeJSONLengthPrefixedCommands(t, jsonLengthPrefixedCommands...)
+	decoder := GetStreamCommandDecoderLimited(TypeJSONLengthPrefixed, bytes.NewReader(frame), 1024)
+	defer PutStreamCommandDecoder(TypeJSONLengthPrefixed, decoder)
+
+	var total int
+	for i := range jsonLengthPrefixedCommands {
+		cmd, size, err := decoder.Decode()
+		require.NoError(t, err)
+		require.True(t, proto.Equal(jsonLengthPrefixedCommands[i], cmd), i)
+		total += size
+	}
+	require.Equal(t, len(frame), total)
+	_, _, err := decoder.Decode()
+	require.Equal(t, io.EOF, err)
+
+	decoder.Reset(bytes.NewReader(frame), 10)
+	_, _, err = decoder.Decode()
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	require.Equal(t, TypeJSONLengthPrefixed, decodeErr.Type)
+	require.ErrorIs(t, err, ErrMessageTooLarge)
+
+	require.Panics(t, func() { NewJSONLengthPrefixedStreamCommandDecoder(bytes.NewReader(frame), 0) })
+}
+
+func TestJSONLengthPrefixedStreamReplyDecoder(t *testing.T) {
+	replies := []*Reply{
+		{Id: 1, Rpc: &RPCResult{Data: multilinePayload}},
+		{Push: &Push{Channel: "news", Pub: &Publication{Data: multilinePayload}}},
+	}
+	frame := encodeReplies(t, TypeJSONLengthPrefixed, replies...)
+
+	decoder := GetStreamReplyDecoderLimited(TypeJSONLengthPrefixed, bytes.NewReader(frame), 1024)
+	defer PutStreamReplyDecoder(TypeJSONLengthPrefixed, decoder)
+	for i := range replies {
+		r, _, err := decoder.Decode()
+		require.NoError(t, err)
+		require.True(t, proto.Equal(replies[i], r), i)
+	}
+	_, _, err := decoder.Decode()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestJSONLengthPrefixed_Transcode(t *testing.T) {
+	frame := encodeJSONLengthPrefixedCommands(t, jsonLengthPrefixedCommands...)
+	protobufFrame, err := TranscodeCommands(nil, frame, TypeJSONLengthPrefixed, TypeProtobuf)
+	require.NoError(t, err)
+	back, err := TranscodeCommands(nil, protobufFrame, TypeProtobuf, TypeJSONLengthPrefixed)
+	require.NoError(t, err)
+	require.Equal(t, frame, back)
+
+	jsonFrame, err := TranscodeCommands(nil, frame, TypeJSONLengthPrefixed, TypeJSON)
+	require.NoError(t, err)
+	cmds := decodeAllCommands(t, TypeJSON, jsonFrame)
+	require.Len(t, cmds, len(jsonLengthPrefixedCommands))
+	require.JSONEq(t, string(multilinePayload), string(cmds[0].Publish.Data))
+}
+
+func TestJSONLengthPrefixed_PeekCommands(t *testing.T) {
+	frame := encodeJSONLengthPrefixedCommands(t, jsonLengthPrefixedCommands...)
+	var peeks []CommandPeek
+	require.NoError(t, PeekCommands(TypeJSONLengthPrefixed, frame, func(p CommandPeek) bool {
+		peeks = append(peeks, p)
+		return true
+	}))
+	require.Equal(t, []CommandPeek{
+		{ID: 1, FrameType: FrameTypePublish, Channel: "news"},
+		{ID: 2, FrameType: FrameTypeSubscribe, Channel: "news"},
+		{ID: 3, FrameType: FrameTypeRPC},
+	}, peeks)
+}
@@ -0,0 +1,451 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/segmentio/encoding/json"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// LegacyMethod is the method of a command in the legacy envelope, which named
+// the request type in a method field and carried the request itself, encoded
+// on its own, in a params field. Those are the reserved fields 2 and 3 of
+// Command, and the result of the matching reply the reserved field 3 of Reply.
+//
+// Values are those of the legacy MethodType enum, and must not change.
+type LegacyMethod int32
+
+const (
+	LegacyMethodConnect LegacyMethod = iota
+	LegacyMethodSubscribe
+	LegacyMethodUnsubscribe
+	LegacyMethodPublish
+	LegacyMethodPresence
+	LegacyMethodPresenceStats
+	LegacyMethodHistory
+	LegacyMethodPing
+	LegacyMethodSend
+	LegacyMethodRPC
+	LegacyMethodRefresh
+	LegacyMethodSubRefresh
+)
+
+// legacyPushType is the type of a push in the legacy envelope, where a push was
+// a reply whose result was a message of its own carrying the type, the channel
+// and the push encoded on its own.
+type legacyPushType int32
+
+const (
+	legacyPushTypePublication legacyPushType = iota
+	legacyPushTypeJoin
+	legacyPushTypeLeave
+	legacyPushTypeUnsubscribe
+	legacyPushTypeMessage
+	legacyPushTypeSubscribe
+	legacyPushTypeConnect
+	legacyPushTypeDisconnect
+	legacyPushTypeRefresh
+)
+
+// Field numbers of the legacy envelope.
+const (
+	legacyIDField            = 1
+	legacyCommandMethodField = 2
+	legacyCommandParamsField = 3
+	legacyReplyErrorField    = 2
+	legacyReplyResultField   = 3
+	legacyPushTypeField      = 1
+	legacyPushChannelField   = 2
+	legacyPushDataField      = 3
+)
+
+// ErrUnknownLegacyMethod is returned by LegacyCommandDecoder, wrapped in a
+// DecodeError, for a command with a method it does not know.
+var ErrUnknownLegacyMethod = errors.New("centrifugal: unknown legacy method")
+
+// legacyCommandRequest sets the request of c matching method to a new request,
+// and returns it for params to be decoded into.
+func legacyCommandRequest(c *Command, method LegacyMethod) (vtUnmarshaler, error) {
+	switch method {
+	case LegacyMethodConnect:
+		c.Connect = &ConnectRequest{}
+		return c.Connect, nil
+	case LegacyMethodSubscribe:
+		c.Subscribe = &SubscribeRequest{}
+		return c.Subscribe, nil
+	case LegacyMethodUnsubscribe:
+		c.Unsubscribe = &UnsubscribeRequest{}
+		return c.Unsubscribe, nil
+	case LegacyMethodPublish:
+		c.Publish = &PublishRequest{}
+		return c.Publish, nil
+	case LegacyMethodPresence:
+		c.Presence = &PresenceRequest{}
+		return c.Presence, nil
+	case LegacyMethodPresenceStats:
+		c.PresenceStats = &PresenceStatsRequest{}
+		return c.PresenceStats, nil
+	case LegacyMethodHistory:
+		c.History = &HistoryRequest{}
+		return c.History, nil
+	case LegacyMethodPing:
+		c.Ping = &PingRequest{}
+		return c.Ping, nil
+	case LegacyMethodSend:
+		c.Send = &SendRequest{}
+		return c.Send, nil
+	case LegacyMethodRPC:
+		c.Rpc = &RPCRequest{}
+		return c.Rpc, nil
+	case LegacyMethodRefresh:
+		c.Refresh = &RefreshRequest{}
+		return c.Refresh, nil
+	case LegacyMethodSubRefresh:
+		c.SubRefresh = &SubRefreshRequest{}
+		return c.SubRefresh, nil
+	default:
+		return nil, ErrUnknownLegacyMethod
+	}
+}
+
+// LegacyCommandDecoder is a CommandDecoder for frames of commands in the legacy
+// method/params envelope, see LegacyMethod. Each command is mapped onto the
+// typed request field of Command its method names, so that what follows the
+// decoder does not tell legacy clients apart.
+//
+// Framing is that of the protocol type, see DataEncoder. Commands in the
+// current shape are not accepted: a server tells legacy clients apart by
+// other means, an endpoint of their own typically.
+type LegacyCommandDecoder struct {
+	protoType Type
+	data      []byte
+	offset    int
+	index     int
+}
+
+var _ CommandDecoder = (*LegacyCommandDecoder)(nil)
+
+// NewLegacyCommandDecoder creates a new LegacyCommandDecoder for the given
+// frame. Any type other than TypeJSON is treated as TypeProtobuf, save for
+// TypeJSONLengthPrefixed, which panics, see legacyType.
+func NewLegacyCommandDecoder(protoType Type, data []byte) *LegacyCommandDecoder {
+	return &LegacyCommandDecoder{protoType: legacyType(protoType), data: data}
+}
+
+// errLegacyJSONLengthPrefixed is the panic value used when a legacy codec is
+// created for TypeJSONLengthPrefixed. Legacy clients predate it, so a server
+// asking for it has mixed up connections – and treating it as either JSON or
+// Protobuf would garble what is exchanged with the client.
+const errLegacyJSONLengthPrefixed = "protocol: legacy codecs do not support TypeJSONLengthPrefixed"
+
+// legacyType returns TypeProtobuf for any type other than TypeJSON, and panics
+// for TypeJSONLengthPrefixed.
+func legacyType(t Type) Type {
+	switch t {
+	case TypeJSON:
+		return TypeJSON
+	case TypeJSONLengthPrefixed:
+		panic(errLegacyJSONLengthPrefixed)
+	}
+	return TypeProtobuf
+}
+
+// Reset makes the decoder ready to decode commands from the given frame.
+func (d *LegacyCommandDecoder) Reset(data []byte) error {
+	d.data = data
+	d.offset = 0
+	d.index = 0
+	return nil
+}
+
+func (d *LegacyCommandDecoder) decodeError(err error) error {
+	return &DecodeError{Type: d.protoType, Index: d.index, Offset: int64(d.offset), Err: err}
+}
+
+// Decode returns the next Command in the frame. The last Command is returned
+// together with io.EOF, see the CommandDecoder interface.
+func (d *LegacyCommandDecoder) Decode() (*Command, error) {
+	if d.protoType == TypeJSON && len(d.data) == 0 {
+		return nil, d.decodeError(io.ErrUnexpectedEOF)
+	}
+	if d.offset >= len(d.data) {
+		return nil, io.EOF
+	}
+	var (
+		c   Command
+		n   int
+		err error
+	)
+	if d.protoType == TypeJSON {
+		n, err = d.decodeJSON(&c)
+	} else {
+		n, err = d.decodeProtobuf(&c)
+	}
+	if err != nil {
+		return nil, d.decodeError(err)
+	}
+	d.offset += n
+	d.index++
+	if d.offset >= len(d.data) {
+		return &c, io.EOF
+	}
+	return &c, nil
+}
+
+// legacyJSONCommand is the legacy envelope in JSON, where params is the request
+// as a JSON object.
+type legacyJSONCommand struct {
+	ID     uint32       `json:"id"`
+	Method LegacyMethod `json:"method"`
+	Params Raw          `json:"params"`
+}
+
+// decodeJSON decodes the command at the current offset into c, and returns the
+// number of bytes it takes in the frame, delimiter included.
+func (d *LegacyCommandDecoder) decodeJSON(c *Command) (int, error) {
+	cmdBytes := d.data[d.offset:]
+	n := len(cmdBytes)
+	if i := bytes.IndexByte(cmdBytes, '\n'); i >= 0 {
+		cmdBytes, n = cmdBytes[:i], i+1
+	}
+	var legacy legacyJSONCommand
+	if _, err := json.Parse(cmdBytes, &legacy, 0); err != nil {
+		return 0, err
+	}
+	req, err := legacyCommandRequest(c, legacy.Method)
+	if err != nil {
+		return 0, err
+	}
+	c.Id = legacy.ID
+	if len(legacy.Params) > 0 {
+		if _, err := json.Parse(legacy.Params, req, 0); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// decodeProtobuf decodes the command at the current offset into c, and returns
+// the number of bytes it takes in the frame, length prefix included.
+func (d *LegacyCommandDecoder) decodeProtobuf(c *Command) (int, error) {
+	cmdBytes, n := protowire.ConsumeBytes(d.data[d.offset:])
+	if n < 0 {
+		return 0, io.ErrShortBuffer
+	}
+	var (
+		method LegacyMethod
+		params []byte
+	)
+	for len(cmdBytes) > 0 {
+		num, typ, m := protowire.ConsumeTag(cmdBytes)
+		if m < 0 {
+			return 0, protowire.ParseError(m)
+		}
+		cmdBytes = cmdBytes[m:]
+		switch {
+		case num == legacyIDField && typ == protowire.VarintType:
+			var v uint64
+			v, m = protowire.ConsumeVarint(cmdBytes)
+			c.Id = uint32(v)
+		case num == legacyCommandMethodField && typ == protowire.VarintType:
+			var v uint64
+			v, m = protowire.ConsumeVarint(cmdBytes)
+			method = LegacyMethod(v)
+		case num == legacyCommandParamsField && typ == protowire.BytesType:
+			params, m = protowire.ConsumeBytes(cmdBytes)
+		default:
+			m = protowire.ConsumeFieldValue(num, typ, cmdBytes)
+		}
+		if m < 0 {
+			return 0, protowire.ParseError(m)
+		}
+		cmdBytes = cmdBytes[m:]
+	}
+	req, err := legacyCommandRequest(c, method)
+	if err != nil {
+		return 0, err
+	}
+	if err := req.UnmarshalVT(params); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// LegacyReplyEncoder is a ReplyEncoder rendering replies in the legacy
+// id/error/result envelope, the counterpart of LegacyCommandDecoder. The result
+// of a reply is encoded on its own into the result field, and so is a push,
+// wrapped into the legacy push envelope, in the result of a reply with no id.
+type LegacyReplyEncoder struct {
+	protoType Type
+}
+
+var _ ReplyEncoder = (*LegacyReplyEncoder)(nil)
+
+// NewLegacyReplyEncoder creates a new LegacyReplyEncoder. Any type other than
+// TypeJSON is treated as TypeProtobuf, save for TypeJSONLengthPrefixed, which
+// panics, see legacyType. It's safe to use the returned encoder concurrently.
+func NewLegacyReplyEncoder(protoType Type) *LegacyReplyEncoder {
+	return &LegacyReplyEncoder{protoType: legacyType(protoType)}
+}
+
+// legacyMessage is a message of the protocol, which both encodings support.
+type legacyMessage interface {
+	MarshalEasyJSON(*writer)
+	MarshalVT() ([]byte, error)
+}
+
+// legacyReplyResult returns the result or the push of r, nil if it has none.
+// For a push it also returns its legacy type and channel.
+func legacyReplyResult(r *Reply) (result legacyMessage, push *Push, pushType legacyPushType) {
+	switch {
+	case r.Push != nil:
+		p := r.Push
+		switch {
+		case p.Pub != nil:
+			return p.Pub, p, legacyPushTypePublication
+		case p.Join != nil:
+			return p.Join, p, legacyPushTypeJoin
+		case p.Leave != nil:
+			return p.Leave, p, legacyPushTypeLeave
+		case p.Unsubscribe != nil:
+			return p.Unsubscribe, p, legacyPushTypeUnsubscribe
+		case p.Message != nil:
+			return p.Message, p, legacyPushTypeMessage
+		case p.Subscribe != nil:
+			return p.Subscribe, p, legacyPushTypeSubscribe
+		case p.Connect != nil:
+			return p.Connect, p, legacyPushTypeConnect
+		case p.Disconnect != nil:
+			return p.Disconnect, p, legacyPushTypeDisconnect
+		case p.Refresh != nil:
+			return p.Refresh, p, legacyPushTypeRefresh
+		}
+		return nil, p, 0
+	case r.Connect != nil:
+		return r.Connect, nil, 0
+	case r.Subscribe != nil:
+		return r.Subscribe, nil, 0
+	case r.Unsubscribe != nil:
+		return r.Unsubscribe, nil, 0
+	case r.Publish != nil:
+		return r.Publish, nil, 0
+	case r.Presence != nil:
+		return r.Presence, nil, 0
+	case r.PresenceStats != nil:
+		return r.PresenceStats, nil, 0
+	case r.History != nil:
+		return r.History, nil, 0
+	case r.Ping != nil:
+		return r.Ping, nil, 0
+	case r.Rpc != nil:
+		return r.Rpc, nil, 0
+	case r.Refresh != nil:
+		return r.Refresh, nil, 0
+	case r.SubRefresh != nil:
+		return r.SubRefresh, nil, 0
+	}
+	return nil, nil, 0
+}
+
+// Encode Reply to bytes in the legacy envelope.
+func (e *LegacyReplyEncoder) Encode(r *Reply) ([]byte, error) {
+	if e.protoType == TypeJSON {
+		return e.encodeJSON(r)
+	}
+	return e.encodeProtobuf(r)
+}
+
+func (e *LegacyReplyEncoder) encodeJSON(r *Reply) ([]byte, error) {
+	result, push, pushType := legacyReplyResult(r)
+	jw := newWriter()
+	jw.RawByte('{')
+	sep := ""
+	if r.Id != 0 {
+		jw.RawString(`"id":`)
+		jw.Uint32(r.Id)
+		sep = ","
+	}
+	if r.Error != nil {
+		jw.RawString(sep + `"error":`)
+		r.Error.MarshalEasyJSON(jw)
+		sep = ","
+	}
+	if push != nil {
+		jw.RawString(sep + `"result":{`)
+		pushSep := ""
+		if pushType != legacyPushTypePublication {
+			jw.RawString(`"type":`)
+			jw.Int32(int32(pushType))
+			pushSep = ","
+		}
+		if push.Channel != "" {
+			jw.RawString(pushSep + `"channel":`)
+			jw.String(push.Channel)
+			pushSep = ","
+		}
+		if result != nil {
+			jw.RawString(pushSep + `"data":`)
+			result.MarshalEasyJSON(jw)
+		}
+		jw.RawByte('}')
+	} else if result != nil {
+		jw.RawString(sep + `"result":`)
+		result.MarshalEasyJSON(jw)
+	}
+	jw.RawByte('}')
+	data, err := jw.BuildBytes()
+	if err != nil {
+		return nil, err
+	}
+	if err := isValidJSON(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (e *LegacyReplyEncoder) encodeProtobuf(r *Reply) ([]byte, error) {
+	result, push, pushType := legacyReplyResult(r)
+	var data []byte
+	if r.Id != 0 {
+		data = protowire.AppendTag(data, legacyIDField, protowire.VarintType)
+		data = protowire.AppendVarint(data, uint64(r.Id))
+	}
+	if r.Error != nil {
+		errBytes, err := r.Error.MarshalVT()
+		if err != nil {
+			return nil, err
+		}
+		data = protowire.AppendTag(data, legacyReplyErrorField, protowire.BytesType)
+		data = protowire.AppendBytes(data, errBytes)
+	}
+	var resultBytes []byte
+	if result != nil {
+		var err error
+		if resultBytes, err = result.MarshalVT(); err != nil {
+			return nil, err
+		}
+	}
+	if push != nil {
+		var pushBytes []byte
+		if pushType != legacyPushTypePublication {
+			pushBytes = protowire.AppendTag(pushBytes, legacyPushTypeField, protowire.VarintType)
+			pushBytes = protowire.AppendVarint(pushBytes, uint64(pushType))
+		}
+		if push.Channel != "" {
+			pushBytes = protowire.AppendTag(pushBytes, legacyPushChannelField, protowire.BytesType)
+			pushBytes = protowire.AppendString(pushBytes, push.Channel)
+		}
+		if len(resultBytes) > 0 {
+			pushBytes = protowire.AppendTag(pushBytes, legacyPushDataField, protowire.BytesType)
+			pushBytes = protowire.AppendBytes(pushBytes, resultBytes)
+		}
+		resultBytes = pushBytes
+	}
+	if len(resultBytes) > 0 {
+		data = protowire.AppendTag(data, legacyReplyResultField, protowire.BytesType)
+		data = protowire.AppendBytes(data, resultBytes)
+	}
+	return data, nil
+}
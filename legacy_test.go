@@ -0,0 +1,184 @@
+package protocol
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// encodeLegacyCommand returns a Protobuf legacy command, length prefix
+// included.
+func encodeLegacyCommand(t *testing.T, id uint32, method LegacyMethod, params interface{ MarshalVT() ([]byte, error) }) []byte {
+	t.Helper()
+	var cmd []byte
+	cmd = protowire.AppendTag(cmd, 1, protowire.VarintType)
+	cmd = protowire.AppendVarint(cmd, uint64(id))
+	if method != LegacyMethodConnect {
+		cmd = protowire.AppendTag(cmd, 2, protowire.VarintType)
+		cmd = protowire.AppendVarint(cmd, uint64(method))
+	}
+	data, err := params.MarshalVT()
+	require.NoError(t, err)
+	if len(data) > 0 {
+		cmd = protowire.AppendTag(cmd, 3, protowire.BytesType)
+		cmd = protowire.AppendBytes(cmd, data)
+	}
+	return protowire.AppendBytes(nil, cmd)
+}
+
+var legacyCommands = []*Command{
+	{Id: 1, Connect: &ConnectRequest{Token: "t", Data: Raw(`{"a":1}`)}},
+	{Id: 2, Subscribe: &SubscribeRequest{Channel: "news", Recover: true, Offset: 5}},
+	{Id: 3, Publish: &PublishRequest{Channel: "news", Data: Raw(`{"text":"hi"}`)}},
+	{Id: 4, Ping: &PingRequest{}},
+	{Send: &SendRequest{Data: Raw(`{}`)}},
+}
+
+func TestLegacyCommandDecoder_JSON(t *testing.T) {
+	frame := []byte(`{"id":1,"params":{"token":"t","data":{"a":1}}}
+{"id":2,"method":1,"params":{"channel":"news","recover":true,"offset":5}}
+{"id":3,"method":3,"params":{"channel":"news","data":{"text":"hi"}}}
+{"id":4,"method":7}
+{"method":8,"params":{"data":{}}}
+`)
+	requireLegacyCommands(t, NewLegacyCommandDecoder(TypeJSON, frame))
+}
+
+func TestLegacyCommandDecoder_Protobuf(t *testing.T) {
+	var frame []byte
+	frame = append(frame, encodeLegacyCommand(t, 1, LegacyMethodConnect, legacyCommands[0].Connect)...)
+	frame = append(frame, encodeLegacyCommand(t, 2, LegacyMethodSubscribe, legacyCommands[1].Subscribe)...)
+	frame = append(frame, encodeLegacyCommand(t, 3, LegacyMethodPublish, legacyCommands[2].Publish)...)
+	frame = append(frame, encodeLegacyCommand(t, 4, LegacyMethodPing, legacyCommands[3].Ping)...)
+	frame = append(frame, encodeLegacyCommand(t, 0, LegacyMethodSend, legacyCommands[4].Send)...)
+	requireLegacyCommands(t, NewLegacyCommandDecoder(TypeProtobuf, frame))
+}
+
+func requireLegacyCommands(t *testing.T, decoder CommandDecoder) {
+	t.Helper()
+	for i, want := range legacyCommands {
+		cmd, err := decoder.Decode()
+		if i == len(legacyCommands)-1 {
+			require.Equal(t, io.EOF, err)
+		} else {
+			require.NoError(t, err)
+		}
+		require.True(t, proto.Equal(want, cmd), "%v != %v", want, cmd)
+	}
+}
+
+func TestLegacyCommandDecoder_Errors(t *testing.T) {
+	decoder := NewLegacyCommandDecoder(TypeJSON, []byte("{\"id\":1,\"method\":7}\n{\"id\":2,\"method\":42}"))
+	_, err := decoder.Decode()
+	require.NoError(t, err)
+	_, err = decoder.Decode()
+	require.ErrorIs(t, err, ErrUnknownLegacyMethod)
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	require.Equal(t, 1, decodeErr.Index)
+	require.Equal(t, int64(20), decodeErr.Offset)
+
+	_, err = NewLegacyCommandDecoder(TypeJSON, nil).Decode()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	frame := encodeLegacyCommand(t, 1, LegacyMethodPublish, &PublishRequest{Channel: "news"})
+	decoder = NewLegacyCommandDecoder(TypeProtobuf, frame[:len(frame)-1])
+	_, err = decoder.Decode()
+	require.ErrorIs(t, err, io.ErrShortBuffer)
+
+	require.NoError(t, decoder.Reset(frame))
+	cmd, err := decoder.Decode()
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, "news", cmd.Publish.Channel)
+}
+
+func TestLegacy_JSONLengthPrefixed(t *testing.T) {
+	require.PanicsWithValue(t, errLegacyJSONLengthPrefixed, func() {
+		NewLegacyCommandDecoder(TypeJSONLengthPrefixed, nil)
+	})
+	require.PanicsWithValue(t, errLegacyJSONLengthPrefixed, func() {
+		NewLegacyReplyEncoder(TypeJSONLengthPrefixed)
+	})
+}
+
+func TestLegacyReplyEncoder_JSON(t *testing.T) {
+	e := NewLegacyReplyEncoder(TypeJSON)
+	tests := []struct {
+		reply *Reply
+		want  string
+	}{
+		{&Reply{Id: 1, Subscribe: &SubscribeResult{Recoverable: true}}, `{"id":1,"result":{"recoverable":true}}`},
+		{&Reply{Id: 2, Error: &Error{Code: 100, Message: "internal"}}, `{"id":2,"error":{"code":100,"message":"internal"}}`},
+		{&Reply{Id: 3, Ping: &PingResult{}}, `{"id":3,"result":{}}`},
+		{&Reply{}, `{}`},
+		{&Reply{Push: &Push{Channel: "news", Pub: &Publication{Data: Raw(`{"a":1}`)}}}, `{"result":{"channel":"news","data":{"data":{"a":1}}}}`},
+		{&Reply{Push: &Push{Channel: "news", Join: &Join{Info: &ClientInfo{User: "u"}}}}, `{"result":{"type":1,"channel":"news","data":{"info":{"user":"u","client":""}}}}`},
+		{&Reply{Push: &Push{Disconnect: &Disconnect{Code: 3000}}}, `{"result":{"type":7,"data":{"code":3000}}}`},
+	}
+	for _, tt := range tests {
+		data, err := e.Encode(tt.reply)
+		require.NoError(t, err)
+		require.JSONEq(t, tt.want, string(data))
+	}
+}
+
+func TestLegacyReplyEncoder_Protobuf(t *testing.T) {
+	e := NewLegacyReplyEncoder(TypeProtobuf)
+
+	result := &SubscribeResult{Recoverable: true, Epoch: "e"}
+	data, err := e.Encode(&Reply{Id: 7, Subscribe: result})
+	require.NoError(t, err)
+	fields := consumeFields(t, data)
+	require.Equal(t, uint64(7), fields[1])
+	var got SubscribeResult
+	require.NoError(t, got.UnmarshalVT(fields[3].([]byte)))
+	require.True(t, proto.Equal(result, &got))
+
+	data, err = e.Encode(&Reply{Id: 8, Error: &Error{Code: 101}})
+	require.NoError(t, err)
+	var gotErr Error
+	require.NoError(t, gotErr.UnmarshalVT(consumeFields(t, data)[2].([]byte)))
+	require.Equal(t, uint32(101), gotErr.Code)
+
+	leave := &Leave{Info: &ClientInfo{Client: "c"}}
+	data, err = e.Encode(&Reply{Push: &Push{Channel: "news", Leave: leave}})
+	require.NoError(t, err)
+	fields = consumeFields(t, data)
+	require.NotContains(t, fields, protowire.Number(1))
+	push := consumeFields(t, fields[3].([]byte))
+	require.Equal(t, uint64(legacyPushTypeLeave), push[1])
+	require.Equal(t, []byte("news"), push[2])
+	var gotLeave Leave
+	require.NoError(t, gotLeave.UnmarshalVT(push[3].([]byte)))
+	require.True(t, proto.Equal(leave, &gotLeave))
+
+	data, err = e.Encode(&Reply{})
+	require.NoError(t, err)
+	require.Empty(t, data)
+}
+
+// consumeFields returns the varint and bytes fields of an encoded message.
+func consumeFields(t *testing.T, data []byte) map[protowire.Number]any {
+	t.Helper()
+	fields := map[protowire.Number]any{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		require.Positive(t, n)
+		data = data[n:]
+		switch typ {
+		case protowire.VarintType:
+			fields[num], n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			fields[num], n = protowire.ConsumeBytes(data)
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		require.Positive(t, n)
+		data = data[n:]
+	}
+	return fields
+}
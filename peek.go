@@ -0,0 +1,308 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/segmentio/encoding/json"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// CommandPeek is what PeekCommand extracts from an encoded command.
+type CommandPeek struct {
+	// ID is the command id.
+	ID uint32
+	// FrameType is the type of the request the command carries. A command
+	// carrying neither a request nor an id, which is how a client answers a
+	// server ping, is FrameTypeClientPong; with an id it is FrameType(0). A
+	// command carrying a PingRequest, which has no frame type of its own, is
+	// FrameTypeClientPong too. A command carrying several requests is given the
+	// type of the one a server handles, the first in field number order.
+	FrameType FrameType
+	// Channel is the channel of the request, empty for requests which do not
+	// address one.
+	Channel string
+}
+
+// commandRequests describes the requests of Command, indexed by their field
+// number minus commandRequestField.
+var commandRequests = [...]struct {
+	name      string
+	frameType FrameType
+	channel   bool
+}{
+	{"connect", FrameTypeConnect, false},
+	{"subscribe", FrameTypeSubscribe, true},
+	{"unsubscribe", FrameTypeUnsubscribe, true},
+	{"publish", FrameTypePublish, true},
+	{"presence", FrameTypePresence, true},
+	{"presence_stats", FrameTypePresenceStats, true},
+	{"history", FrameTypeHistory, true},
+	{"ping", FrameTypeClientPong, false},
+	{"send", FrameTypeSend, false},
+	{"rpc", FrameTypeRPC, false},
+	{"refresh", FrameTypeRefresh, false},
+	{"sub_refresh", FrameTypeSubRefresh, true},
+}
+
+// commandRequestField is the field number of the first request of Command.
+const commandRequestField = 4
+
+// commandIDField and requestChannelField are the field numbers of Command.id
+// and of the channel field of every request which has one.
+const (
+	commandIDField      = 1
+	requestChannelField = 1
+)
+
+// errPeekNotObject is returned by PeekCommand for JSON input which is not an
+// object.
+var errPeekNotObject = errors.New("centrifugal: command is not a JSON object")
+
+// peekState collects what was seen of the requests of a command so far.
+type peekState struct {
+	id      uint32
+	present [len(commandRequests)]bool
+	channel [len(commandRequests)][]byte
+}
+
+func (s *peekState) result() CommandPeek {
+	p := CommandPeek{ID: s.id}
+	if s.id == 0 {
+		// The empty command is a pong, see FrameTypeOfCommand.
+		p.FrameType = FrameTypeClientPong
+	}
+	for i, present := range s.present {
+		if present {
+			p.FrameType = commandRequests[i].frameType
+			if len(s.channel[i]) > 0 {
+				p.Channel = string(s.channel[i])
+			}
+			break
+		}
+	}
+	return p
+}
+
+// PeekCommand extracts the id, the request type and the channel of a single
+// encoded command – a Command as marshaled, without the length prefix or the
+// delimiter of a frame – without decoding it.
+//
+// It is meant for rate limiting and routing, which must be cheap enough to run
+// before deciding whether a command is worth decoding at all: payloads, tags,
+// filters and connect subscriptions are skipped over without being decoded,
+// and nothing but the channel is allocated. The result matches what decoding
+// the command would give, but PeekCommand validates only the parts of the
+// input it reads, so a command which peeks fine may still fail to decode.
+// Any type other than TypeJSON and TypeJSONLengthPrefixed is treated as
+// TypeProtobuf.
+func PeekCommand(protoType Type, data []byte) (CommandPeek, error) {
+	if isJSON(protoType) {
+		return peekJSONCommand(data)
+	}
+	return peekProtobufCommand(data)
+}
+
+// PeekCommands calls fn with the result of PeekCommand for every command of a
+// frame, see DataEncoder for the framing, until fn returns false. Errors are
+// DecodeError, as CommandDecoder would return for the same frame.
+func PeekCommands(protoType Type, frame []byte, fn func(CommandPeek) bool) error {
+	if protoType == TypeJSON {
+		if len(frame) == 0 {
+			return &DecodeError{Type: TypeJSON, Err: io.ErrUnexpectedEOF}
+		}
+		for index, offset := 0, 0; offset < len(frame); index++ {
+			end := bytes.IndexByte(frame[offset:], '\n')
+			if end < 0 {
+				end = len(frame)
+			} else {
+				end += offset
+			}
+			p, err := peekJSONCommand(frame[offset:end])
+			if err != nil {
+				return &DecodeError{Type: TypeJSON, Index: index, Offset: int64(offset), Err: err}
+			}
+			if !fn(p) {
+				return nil
+			}
+			offset = end + 1
+		}
+		return nil
+	}
+	protoType = normalizeType(protoType)
+	for index, offset := 0, 0; offset < len(frame); index++ {
+		cmdBytes, n := protowire.ConsumeBytes(frame[offset:])
+		if n < 0 {
+			return &DecodeError{Type: protoType, Index: index, Offset: int64(offset), Err: io.ErrShortBuffer}
+		}
+		p, err := PeekCommand(protoType, cmdBytes)
+		if err != nil {
+			return &DecodeError{Type: protoType, Index: index, Offset: int64(offset), Err: err}
+		}
+		if !fn(p) {
+			return nil
+		}
+		offset += n
+	}
+	return nil
+}
+
+func peekProtobufCommand(data []byte) (CommandPeek, error) {
+	var s peekState
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return CommandPeek{}, protowire.ParseError(n)
+		}
+		data = data[n:]
+		switch i := int(num) - commandRequestField; {
+		case num == commandIDField && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(data)
+			s.id = uint32(v)
+		case i >= 0 && i < len(commandRequests) && typ == protowire.BytesType:
+			var req []byte
+			req, n = protowire.ConsumeBytes(data)
+			if n < 0 {
+				break
+			}
+			// A message field repeated on the wire is merged, so every
+			// occurrence counts and the last channel wins.
+			s.present[i] = true
+			if commandRequests[i].channel {
+				channel, err := peekProtobufChannel(req)
+				if err != nil {
+					return CommandPeek{}, err
+				}
+				if channel != nil {
+					s.channel[i] = channel
+				}
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return CommandPeek{}, protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return s.result(), nil
+}
+
+// peekProtobufChannel returns the channel field of an encoded request, or nil if
+// it has none.
+func peekProtobufChannel(data []byte) ([]byte, error) {
+	var channel []byte
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		if num == requestChannelField && typ == protowire.BytesType {
+			channel, n = protowire.ConsumeBytes(data)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return channel, nil
+}
+
+func peekJSONCommand(data []byte) (CommandPeek, error) {
+	var (
+		s         peekState
+		tokenizer json.Tokenizer
+		// field is the top level key whose value comes next, as an index into
+		// commandRequests, or one of the values below.
+		field = peekSkip
+		// request is the request whose object is being read, -1 outside of
+		// one, and inChannel tells whether its next value is the channel.
+		request   = -1
+		inChannel bool
+		// closed tells whether the command object was read to its end, which
+		// the tokenizer does not check.
+		closed bool
+	)
+	tokenizer.Reset(data)
+	// Release the tokenizer stack to its pool.
+	defer tokenizer.Reset(nil)
+
+	for tokenizer.Next() {
+		if tokenizer.Delim == ':' || tokenizer.Delim == ',' {
+			continue
+		}
+		switch tokenizer.Depth {
+		case 0:
+			if closed || tokenizer.Delim != '{' && tokenizer.Delim != '}' {
+				return CommandPeek{}, errPeekNotObject
+			}
+			closed = tokenizer.Delim == '}'
+		case 1:
+			if tokenizer.IsKey {
+				field = peekJSONField(tokenizer.String())
+				continue
+			}
+			switch {
+			case field == peekID:
+				if tokenizer.Kind().Class() == json.Num {
+					s.id = uint32(tokenizer.Uint())
+				}
+			case field >= 0:
+				// Decoding leaves a request set to null nil.
+				s.present[field] = tokenizer.Delim == '{'
+				if tokenizer.Delim == '{' {
+					request = field
+				}
+			}
+			if tokenizer.Delim == '}' {
+				request = -1
+			}
+			field = peekSkip
+		case 2:
+			if request < 0 || !commandRequests[request].channel {
+				continue
+			}
+			if tokenizer.IsKey {
+				inChannel = bytes.EqualFold(tokenizer.String(), []byte("channel"))
+				continue
+			}
+			if inChannel && tokenizer.Kind().Class() == json.String {
+				s.channel[request] = tokenizer.String()
+			}
+			inChannel = false
+		}
+	}
+	if tokenizer.Err != nil {
+		return CommandPeek{}, tokenizer.Err
+	}
+	if !closed {
+		return CommandPeek{}, io.ErrUnexpectedEOF
+	}
+	return s.result(), nil
+}
+
+// Values of the top level JSON key being read, other than indexes into
+// commandRequests.
+const (
+	peekSkip = -1
+	peekID   = -2
+)
+
+// peekJSONField returns what the top level key names. Keys are matched without
+// regard to case, as decoding does.
+func peekJSONField(key []byte) int {
+	if bytes.EqualFold(key, []byte("id")) {
+		return peekID
+	}
+	for i := range commandRequests {
+		if bytes.EqualFold(key, []byte(commandRequests[i].name)) {
+			return i
+		}
+	}
+	return peekSkip
+}
@@ -0,0 +1,126 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var peekTests = []struct {
+	cmd  *Command
+	peek CommandPeek
+}{
+	{&Command{Id: 1, Connect: &ConnectRequest{Token: "t", Subs: map[string]*SubscribeRequest{"a": {Channel: "b"}}}}, CommandPeek{1, FrameTypeConnect, ""}},
+	{&Command{Id: 2, Subscribe: &SubscribeRequest{Channel: "news", Data: []byte(`{"channel":"nope"}`), Tf: filterWide(2)}}, CommandPeek{2, FrameTypeSubscribe, "news"}},
+	{&Command{Id: 3, Unsubscribe: &UnsubscribeRequest{Channel: "news"}}, CommandPeek{3, FrameTypeUnsubscribe, "news"}},
+	{&Command{Id: 4, Publish: &PublishRequest{Channel: "chat:1", Data: []byte(`{"input":[1,{"a":"b"}]}`)}}, CommandPeek{4, FrameTypePublish, "chat:1"}},
+	{&Command{Id: 5, Presence: &PresenceRequest{Channel: "news"}}, CommandPeek{5, FrameTypePresence, "news"}},
+	{&Command{Id: 6, PresenceStats: &PresenceStatsRequest{Channel: "news"}}, CommandPeek{6, FrameTypePresenceStats, "news"}},
+	{&Command{Id: 7, History: &HistoryRequest{Channel: "news", Limit: 10}}, CommandPeek{7, FrameTypeHistory, "news"}},
+	{&Command{Id: 8, Ping: &PingRequest{}}, CommandPeek{8, FrameTypeClientPong, ""}},
+	{&Command{Send: &SendRequest{Data: []byte(`"hello"`)}}, CommandPeek{0, FrameTypeSend, ""}},
+	{&Command{Id: 10, Rpc: &RPCRequest{Method: "m", Data: []byte(`{}`)}}, CommandPeek{10, FrameTypeRPC, ""}},
+	{&Command{Id: 11, Refresh: &RefreshRequest{Token: "t"}}, CommandPeek{11, FrameTypeRefresh, ""}},
+	{&Command{Id: 12, SubRefresh: &SubRefreshRequest{Channel: "news", Type: SubRefreshTypeUntrack, Untrack: []string{"k"}}}, CommandPeek{12, FrameTypeSubRefresh, "news"}},
+	{&Command{}, CommandPeek{0, FrameTypeClientPong, ""}},
+	{&Command{Id: 14}, CommandPeek{14, 0, ""}},
+	{&Command{Id: 4294967295, Publish: &PublishRequest{}}, CommandPeek{4294967295, FrameTypePublish, ""}},
+	{&Command{Id: 13, History: &HistoryRequest{Channel: "b"}, Subscribe: &SubscribeRequest{Channel: "a"}}, CommandPeek{13, FrameTypeSubscribe, "a"}},
+}
+
+// encodeCommand returns cmd encoded as PeekCommand expects it.
+func encodeCommand(t *testing.T, protoType Type, cmd *Command) []byte {
+	t.Helper()
+	if protoType == TypeJSON {
+		return encodeCommands(t, protoType, cmd)
+	}
+	data, err := cmd.MarshalVT()
+	require.NoError(t, err)
+	return data
+}
+
+func TestPeekCommand(t *testing.T) {
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		for _, tt := range peekTests {
+			data := encodeCommand(t, protoType, tt.cmd)
+			peek, err := PeekCommand(protoType, data)
+			require.NoError(t, err)
+			require.Equal(t, tt.peek, peek, "%s %s", protoType, data)
+		}
+	}
+}
+
+func TestPeekCommands(t *testing.T) {
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		var cmds []*Command
+		var want []CommandPeek
+		for _, tt := range peekTests {
+			cmds = append(cmds, tt.cmd)
+			want = append(want, tt.peek)
+		}
+		frame := encodeCommands(t, protoType, cmds...)
+		var got []CommandPeek
+		require.NoError(t, PeekCommands(protoType, frame, func(p CommandPeek) bool {
+			got = append(got, p)
+			return true
+		}))
+		require.Equal(t, want, got)
+
+		got = got[:0]
+		require.NoError(t, PeekCommands(protoType, frame, func(p CommandPeek) bool {
+			got = append(got, p)
+			return len(got) < 2
+		}))
+		require.Len(t, got, 2)
+	}
+}
+
+func TestPeekCommand_JSON(t *testing.T) {
+	peek, err := PeekCommand(TypeJSON, []byte(`{"ID":5,"Publish":{"Channel":"a","data":{"channel":"b"}},"unknown":{"channel":"c"}}`))
+	require.NoError(t, err)
+	require.Equal(t, CommandPeek{5, FrameTypePublish, "a"}, peek)
+
+	peek, err = PeekCommand(TypeJSON, []byte(`{"id":5,"subscribe":null,"history":{"channel":"ab"}}`))
+	require.NoError(t, err)
+	require.Equal(t, CommandPeek{5, FrameTypeHistory, "ab"}, peek)
+
+	_, err = PeekCommand(TypeJSON, []byte(`[1]`))
+	require.Error(t, err)
+	_, err = PeekCommand(TypeJSON, []byte(`{"id":1,"publish":{"channel":`))
+	require.Error(t, err)
+	_, err = PeekCommand(TypeJSON, []byte(`{"id":1}{}`))
+	require.Error(t, err)
+}
+
+func TestPeekCommand_Malformed(t *testing.T) {
+	data := encodeCommand(t, TypeProtobuf, &Command{Id: 1, Publish: &PublishRequest{Channel: "news"}})
+	for i := 0; i < len(data); i++ {
+		// Every truncation is either a command missing some fields or an
+		// error, never a panic.
+		_, _ = PeekCommand(TypeProtobuf, data[:i])
+	}
+	_, err := PeekCommand(TypeProtobuf, data[:len(data)-1])
+	require.Error(t, err)
+
+	frame := encodeCommands(t, TypeProtobuf, &Command{Id: 1, Publish: &PublishRequest{Channel: "news"}})
+	err = PeekCommands(TypeProtobuf, frame[:len(frame)-1], func(CommandPeek) bool { return true })
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	require.Equal(t, TypeProtobuf, decodeErr.Type)
+
+	err = PeekCommands(TypeJSON, []byte("{\"id\":1}\n{"), func(CommandPeek) bool { return true })
+	require.True(t, errors.As(err, &decodeErr))
+	require.Equal(t, 1, decodeErr.Index)
+	require.Equal(t, int64(9), decodeErr.Offset)
+}
+
+func TestPeekCommand_Allocs(t *testing.T) {
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		data := encodeCommand(t, protoType, &Command{Id: 1, Rpc: &RPCRequest{Method: "m", Data: []byte(`{"a":[1,2,3]}`)}})
+		allocs := testing.AllocsPerRun(100, func() {
+			_, _ = PeekCommand(protoType, data)
+		})
+		require.Zero(t, allocs, protoType)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package protocol
+
+// raceEnabled tells whether the race detector is on. It drops sync.Pool items
+// at random on purpose, so allocation assertions relying on pools can not hold.
+const raceEnabled = true
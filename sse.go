@@ -0,0 +1,307 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// ErrInvalidSSEEventID is returned by SSEWriter for an event id which can not be
+// sent: Server-Sent Events ids must not contain line breaks or NUL.
+var ErrInvalidSSEEventID = errors.New("centrifugal: invalid SSE event id")
+
+// SSEWriter writes replies to an io.Writer as Server-Sent Events, one reply per
+// event.
+//
+// The framing of DataEncoder does not fit SSE, where a line break ends a field
+// and a blank line ends an event, so replies are put into events of their own
+// instead. A JSON reply is the event data as is – it is split over several data
+// lines at line breaks, which valid JSON only contains as whitespace between
+// tokens. A Protobuf reply is binary, which SSE can not carry, so it is base64
+// encoded (standard encoding, with padding).
+//
+// An SSEWriter writes every event with a single Write call, and does not flush:
+// with net/http, flush the http.ResponseWriter once the events at hand are
+// written. It is not safe for concurrent use.
+type SSEWriter struct {
+	w         io.Writer
+	protoType Type
+	encoder   ReplyEncoder
+	eventID   func(*Reply) string
+	buf       []byte
+}
+
+// NewSSEWriter creates a new SSEWriter writing replies of the given protocol
+// type to w. Any type other than TypeJSON and TypeJSONLengthPrefixed is treated
+// as TypeProtobuf. TypeJSONLengthPrefixed replies are written as JSON ones, with
+// payloads kept intact – save for `\r` and `\r\n` line breaks in them, which come
+// out of SSEReplyDecoder as `\n`.
+func NewSSEWriter(protoType Type, w io.Writer) *SSEWriter {
+	protoType = normalizeType(protoType)
+	return &SSEWriter{w: w, protoType: protoType, encoder: GetReplyEncoder(protoType)}
+}
+
+// SetEventID makes WriteReply give events the id fn returns for their reply, and
+// no id when it returns an empty string. A browser sends the id of the last
+// event it got in the Last-Event-ID header when it reconnects, which a server
+// may use to recover what was missed. See PublicationEventID.
+func (w *SSEWriter) SetEventID(fn func(*Reply) string) {
+	w.eventID = fn
+}
+
+// PublicationEventID returns the offset of a publication push as an event id,
+// and an empty string for any other reply, see SSEWriter.SetEventID.
+//
+// An offset only locates a publication within its channel, so the id is only
+// meaningful to a connection subscribed to a single channel – as with
+// unidirectional transports subscribing on connect to one channel. Use an id of
+// your own otherwise.
+func PublicationEventID(r *Reply) string {
+	if r.Push == nil || r.Push.Pub == nil || r.Push.Pub.Offset == 0 {
+		return ""
+	}
+	return strconv.FormatUint(r.Push.Pub.Offset, 10)
+}
+
+// WriteReply encodes r and writes it as an event.
+func (w *SSEWriter) WriteReply(r *Reply) error {
+	data, err := w.encoder.Encode(r)
+	if err != nil {
+		return err
+	}
+	var id string
+	if w.eventID != nil {
+		id = w.eventID(r)
+	}
+	return w.WriteEvent(data, id)
+}
+
+// WriteEvent writes a reply already encoded with a ReplyEncoder of the writer
+// type as an event, with the given id if not empty. It lets a server encode a
+// reply once for many connections.
+func (w *SSEWriter) WriteEvent(data []byte, id string) error {
+	if strings.ContainsAny(id, "\r\n\x00") {
+		return ErrInvalidSSEEventID
+	}
+	buf := w.buf[:0]
+	if id != "" {
+		buf = append(buf, "id: "...)
+		buf = append(buf, id...)
+		buf = append(buf, '\n')
+	}
+	if isJSON(w.protoType) {
+		buf = appendSSEData(buf, data)
+	} else {
+		buf = append(buf, "data: "...)
+		buf = base64.StdEncoding.AppendEncode(buf, data)
+		buf = append(buf, '\n')
+	}
+	buf = append(buf, '\n')
+	w.buf = buf
+	_, err := w.w.Write(buf)
+	return err
+}
+
+// appendSSEData appends data as data lines, one per line of data.
+func appendSSEData(buf, data []byte) []byte {
+	for {
+		end := bytes.IndexAny(data, "\r\n")
+		if end < 0 {
+			break
+		}
+		buf = append(buf, "data: "...)
+		buf = append(buf, data[:end]...)
+		buf = append(buf, '\n')
+		if data[end] == '\r' && end+1 < len(data) && data[end+1] == '\n' {
+			end++
+		}
+		data = data[end+1:]
+	}
+	buf = append(buf, "data: "...)
+	buf = append(buf, data...)
+	return append(buf, '\n')
+}
+
+// sseFieldOverhead is how much longer than the data it carries a line of an
+// event may be: the longest field prefix, "data: ", and a `\r\n` line ending.
+const sseFieldOverhead = len("data: \r\n")
+
+// SSEReplyDecoder is a StreamReplyDecoder which reads replies from a stream of
+// Server-Sent Events, as written by SSEWriter. It's meant for Go clients of the
+// SSE transport, such as tests and bots.
+//
+// Events are parsed as the SSE specification says, with one exception: lines
+// must end with `\n` or `\r\n` – a lone `\r` is not taken as a line ending,
+// which no server in practice relies on. Comments, retry fields and events
+// without data are skipped, and so are events of a type other than "message",
+// which SSEWriter never writes. An event stream ending in the middle of an event
+// drops it.
+type SSEReplyDecoder struct {
+	reader           *bufio.Reader
+	protoType        Type
+	messageSizeLimit int64
+	lastEventID      string
+	// data accumulates the data of the event being read, and line a line which
+	// does not fit into the bufio.Reader buffer.
+	data []byte
+	line []byte
+	// index and offset locate the next event in the stream, for DecodeError.
+	index  int
+	offset int64
+}
+
+var _ StreamReplyDecoder = (*SSEReplyDecoder)(nil)
+
+// NewSSEReplyDecoder creates a new SSEReplyDecoder reading replies of the given
+// protocol type from reader. Any type other than TypeJSON and
+// TypeJSONLengthPrefixed is treated as TypeProtobuf.
+//
+// Events carrying more than messageSizeLimit bytes of data – as sent, that is
+// base64 encoded for Protobuf – are rejected with ErrMessageTooLarge.
+// messageSizeLimit must be positive; a zero or negative value panics.
+func NewSSEReplyDecoder(protoType Type, reader io.Reader, messageSizeLimit int64) *SSEReplyDecoder {
+	if messageSizeLimit <= 0 {
+		panic(errNonPositiveMessageSizeLimit)
+	}
+	return &SSEReplyDecoder{
+		reader:           bufio.NewReader(reader),
+		protoType:        normalizeType(protoType),
+		messageSizeLimit: messageSizeLimit,
+	}
+}
+
+// Decode returns the reply of the next event from the stream, see the
+// StreamReplyDecoder interface. The number of bytes returned is the size of the
+// whole event on the wire.
+func (d *SSEReplyDecoder) Decode() (*Reply, int, error) {
+	var (
+		size      int
+		eventType []byte
+		hasData   bool
+	)
+	d.data = d.data[:0]
+	for {
+		line, err := d.readLine()
+		size += len(line)
+		if err != nil {
+			// Including io.EOF in the middle of an event, which drops it.
+			return nil, 0, d.decodeError(err)
+		}
+		line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+		if len(line) > 0 {
+			if line[0] == ':' {
+				continue
+			}
+			name, value := line, []byte(nil)
+			if i := bytes.IndexByte(line, ':'); i >= 0 {
+				name, value = line[:i], bytes.TrimPrefix(line[i+1:], []byte(" "))
+			}
+			switch string(name) {
+			case "data":
+				if hasData {
+					d.data = append(d.data, '\n')
+				}
+				hasData = true
+				d.data = append(d.data, value...)
+				if int64(len(d.data)) > d.messageSizeLimit {
+					return nil, 0, d.decodeError(ErrMessageTooLarge)
+				}
+			case "event":
+				eventType = append(eventType[:0], value...)
+			case "id":
+				if bytes.IndexByte(value, 0) < 0 {
+					d.lastEventID = string(value)
+				}
+			}
+			continue
+		}
+		// A blank line ends the event.
+		if !hasData || len(eventType) > 0 && string(eventType) != "message" {
+			d.offset += int64(size)
+			size, eventType, hasData = 0, eventType[:0], false
+			d.data = d.data[:0]
+			continue
+		}
+		reply, err := d.decodeReply()
+		if err != nil {
+			return nil, 0, d.decodeError(err)
+		}
+		d.index++
+		d.offset += int64(size)
+		return reply, size, nil
+	}
+}
+
+func (d *SSEReplyDecoder) decodeReply() (*Reply, error) {
+	var r Reply
+	if isJSON(d.protoType) {
+		if _, err := json.Parse(d.data, &r, 0); err != nil {
+			return nil, err
+		}
+		return &r, nil
+	}
+	data, err := base64.StdEncoding.AppendDecode(nil, d.data)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.UnmarshalVT(data); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// LastEventID returns the id of the last event read which had one, which is
+// what a client reconnecting should send in the Last-Event-ID header.
+func (d *SSEReplyDecoder) LastEventID() string {
+	return d.lastEventID
+}
+
+// readLine returns the next line, including its line ending. Lines longer than
+// an event may be are rejected with ErrMessageTooLarge. The returned slice is
+// only valid until the next call.
+func (d *SSEReplyDecoder) readLine() ([]byte, error) {
+	chunk, err := d.reader.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return chunk, err
+	}
+	maxLine := d.messageSizeLimit + int64(sseFieldOverhead)
+	d.line = append(d.line[:0], chunk...)
+	for {
+		if int64(len(d.line)) > maxLine {
+			return nil, ErrMessageTooLarge
+		}
+		chunk, err = d.reader.ReadSlice('\n')
+		d.line = append(d.line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return d.line, err
+		}
+	}
+}
+
+func (d *SSEReplyDecoder) decodeError(err error) error {
+	if err == io.EOF {
+		return err
+	}
+	return &DecodeError{Type: d.protoType, Index: d.index, Offset: d.offset, Err: err}
+}
+
+// Reset makes the decoder read from the given reader, applying the given message
+// size limit. The protocol type is kept, and the last event id is cleared.
+func (d *SSEReplyDecoder) Reset(reader io.Reader, messageSizeLimit int64) {
+	d.reader.Reset(reader)
+	d.messageSizeLimit = messageSizeLimit
+	d.lastEventID = ""
+	if cap(d.data) > maxRetainedLineBuffer {
+		d.data = nil
+	}
+	if cap(d.line) > maxRetainedLineBuffer {
+		d.line = nil
+	}
+	d.index, d.offset = 0, 0
+}
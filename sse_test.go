@@ -0,0 +1,143 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+var sseReplies = []*Reply{
+	{Id: 1, Connect: &ConnectResult{Client: "c", Version: "1"}},
+	{Push: &Push{Channel: "news", Pub: &Publication{Data: Raw(`{"text":"a\nb"}`), Offset: 7}}},
+	{Push: &Push{Channel: "news", Join: &Join{Info: &ClientInfo{User: "u"}}}},
+	{Id: 2, Error: &Error{Code: 100, Message: "internal"}},
+}
+
+func TestSSE_RoundTrip(t *testing.T) {
+	for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+		t.Run(string(protoType), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewSSEWriter(protoType, &buf)
+			w.SetEventID(PublicationEventID)
+			for _, r := range sseReplies {
+				require.NoError(t, w.WriteReply(r))
+			}
+
+			d := NewSSEReplyDecoder(protoType, &buf, 1024)
+			for i, expected := range sseReplies {
+				r, size, err := d.Decode()
+				require.NoError(t, err)
+				require.Positive(t, size)
+				if expected.Push != nil && expected.Push.Pub != nil {
+					// The newline is stripped from the JSON payload, see Raw.
+					require.JSONEq(t, string(expected.Push.Pub.Data), string(r.Push.Pub.Data))
+					require.Equal(t, "7", d.LastEventID())
+					continue
+				}
+				require.True(t, proto.Equal(expected, r), i)
+			}
+			_, _, err := d.Decode()
+			require.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestSSEWriter_Format(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewSSEWriter(TypeJSON, &buf)
+	require.NoError(t, w.WriteEvent([]byte("{\"id\":1,\r\n\"connect\":{}}"), "5"))
+	require.Equal(t, "id: 5\ndata: {\"id\":1,\ndata: \"connect\":{}}\n\n", buf.String())
+
+	buf.Reset()
+	w = NewSSEWriter(TypeProtobuf, &buf)
+	require.NoError(t, w.WriteEvent([]byte{0, 1, 2}, ""))
+	require.Equal(t, "data: AAEC\n\n", buf.String())
+
+	require.ErrorIs(t, w.WriteEvent([]byte{0}, "a\nb"), ErrInvalidSSEEventID)
+}
+
+func TestPublicationEventID(t *testing.T) {
+	require.Equal(t, "42", PublicationEventID(&Reply{Push: &Push{Pub: &Publication{Offset: 42}}}))
+	require.Empty(t, PublicationEventID(&Reply{Push: &Push{Pub: &Publication{}}}))
+	require.Empty(t, PublicationEventID(&Reply{Push: &Push{Join: &Join{}}}))
+	require.Empty(t, PublicationEventID(&Reply{Id: 1}))
+}
+
+func TestSSEReplyDecoder_Parsing(t *testing.T) {
+	stream := ": keepalive\r\n" +
+		"retry: 1000\r\n" +
+		"\r\n" +
+		"event: other\n" +
+		"data: {\"id\":9}\n" +
+		"\n" +
+		"id: 3\n" +
+		"data:{\"id\":1,\n" +
+		"data: \"rpc\":{}}\n" +
+		"\n" +
+		"id\n" +
+		"event: message\n" +
+		"data: {\"id\":2}\n" +
+		"\n" +
+		"data: {\"id\":3}\n"
+
+	d := NewSSEReplyDecoder(TypeJSON, strings.NewReader(stream), 1024)
+	r, _, err := d.Decode()
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), r.Id)
+	require.NotNil(t, r.Rpc)
+	require.Equal(t, "3", d.LastEventID())
+
+	r, _, err = d.Decode()
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), r.Id)
+	require.Empty(t, d.LastEventID())
+
+	// The last event is not terminated by a blank line, so it is dropped.
+	_, _, err = d.Decode()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestSSEReplyDecoder_Errors(t *testing.T) {
+	d := NewSSEReplyDecoder(TypeJSON, strings.NewReader("data: {\"id\":1}\n\ndata: {\n\n"), 1024)
+	_, _, err := d.Decode()
+	require.NoError(t, err)
+	_, _, err = d.Decode()
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	require.Equal(t, 1, decodeErr.Index)
+	require.Equal(t, int64(len("data: {\"id\":1}\n\n")), decodeErr.Offset)
+
+	d = NewSSEReplyDecoder(TypeProtobuf, strings.NewReader("data: !!\n\n"), 1024)
+	_, _, err = d.Decode()
+	require.True(t, errors.As(err, &decodeErr))
+
+	long := "data: " + strings.Repeat("a", 8192) + "\n\n"
+	d = NewSSEReplyDecoder(TypeJSON, strings.NewReader(long), 100)
+	_, _, err = d.Decode()
+	require.ErrorIs(t, err, ErrMessageTooLarge)
+
+	d = NewSSEReplyDecoder(TypeJSON, strings.NewReader("data: 12345\ndata: 12345\n\n"), 10)
+	_, _, err = d.Decode()
+	require.ErrorIs(t, err, ErrMessageTooLarge)
+
+	require.Panics(t, func() { NewSSEReplyDecoder(TypeJSON, strings.NewReader(""), 0) })
+}
+
+func TestSSEReplyDecoder_Reset(t *testing.T) {
+	d := NewSSEReplyDecoder(TypeJSON, strings.NewReader("id: 1\ndata: {\"id\":1}\n\n"), 1024)
+	_, _, err := d.Decode()
+	require.NoError(t, err)
+	require.Equal(t, "1", d.LastEventID())
+
+	d.Reset(strings.NewReader("data: {\"id\":2}\n\n"), 1024)
+	require.Empty(t, d.LastEventID())
+	r, size, err := d.Decode()
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), r.Id)
+	require.Equal(t, len("data: {\"id\":2}\n\n"), size)
+}
@@ -0,0 +1,191 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/segmentio/encoding/json"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ErrRawNotJSON is returned by the transcoders, wrapped in a DecodeError, for a
+// Protobuf message carrying a payload which is not valid JSON. A JSON frame
+// embeds payloads as they are, so there is no way to carry such a payload to a
+// JSON peer.
+var ErrRawNotJSON = errors.New("centrifugal: payload is not valid JSON")
+
+// TranscodeCommands converts a frame of commands encoded as from into a frame
+// encoded as to, appending the result to dst. Both the messages and their
+// framing are converted, see DataEncoder.
+//
+// It is meant for a proxy between clients and a server speaking different
+// protocol types. Payloads are carried over as they are: a JSON payload becomes
+// the bytes of a Protobuf one, and a Protobuf payload must be valid JSON to be
+// sent to JSON, or ErrRawNotJSON is returned. Errors are DecodeError, locating
+// the message in the source frame. Any type other than TypeJSON and
+// TypeJSONLengthPrefixed is treated as TypeProtobuf.
+//
+// Converting TypeJSONLengthPrefixed to TypeJSON strips raw newlines from
+// payloads, as TypeJSON encoding always does, see Raw.MarshalJSON.
+func TranscodeCommands(dst, frame []byte, from, to Type) ([]byte, error) {
+	if normalizeType(from) == normalizeType(to) {
+		return append(dst, frame...), nil
+	}
+	decoder := GetCommandDecoder(from, frame)
+	defer PutCommandDecoder(from, decoder)
+	encoder := GetDataEncoder(to)
+	defer PutDataEncoder(to, encoder)
+
+	for index := 0; ; index++ {
+		offset := decoder.(framePositioner).framePosition()
+		cmd, err := decoder.Decode()
+		if cmd != nil {
+			if prepErr := prepareTranscode(cmd.ProtoReflect(), to); prepErr != nil {
+				return nil, &DecodeError{Type: normalizeType(from), Index: index, Offset: int64(offset), Err: prepErr}
+			}
+			data, encErr := encodeTranscodedCommand(cmd, to)
+			if encErr != nil {
+				return nil, encErr
+			}
+			_ = encoder.Encode(data)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(dst, encoder.FinishNoCopy()...), nil
+}
+
+func encodeTranscodedCommand(cmd *Command, to Type) ([]byte, error) {
+	switch to {
+	case TypeJSON:
+		return NewJSONCommandEncoder().Encode(cmd)
+	case TypeJSONLengthPrefixed:
+		return NewJSONLengthPrefixedCommandEncoder().Encode(cmd)
+	}
+	// Not ProtobufCommandEncoder, which prefixes the command with its length:
+	// the DataEncoder does that.
+	return cmd.MarshalVT()
+}
+
+// TranscodeReplies converts a frame of replies encoded as from into a frame
+// encoded as to, appending the result to dst. See TranscodeCommands, the same
+// rules apply.
+//
+// The dictionary of a connect reply is the one payload carried differently by
+// the two protocol types, in Dictionary.data or Dictionary.data_b64, and it is
+// moved from one to the other.
+func TranscodeReplies(dst, frame []byte, from, to Type) ([]byte, error) {
+	if normalizeType(from) == normalizeType(to) {
+		return append(dst, frame...), nil
+	}
+	decoder := GetReplyDecoder(from, frame)
+	defer PutReplyDecoder(from, decoder)
+	replyEncoder := GetReplyEncoder(to)
+	encoder := GetDataEncoder(to)
+	defer PutDataEncoder(to, encoder)
+
+	for index := 0; ; index++ {
+		offset := decoder.(framePositioner).framePosition()
+		reply, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := prepareTranscode(reply.ProtoReflect(), to); err != nil {
+			return nil, &DecodeError{Type: normalizeType(from), Index: index, Offset: int64(offset), Err: err}
+		}
+		data, err := replyEncoder.Encode(reply)
+		if err != nil {
+			return nil, err
+		}
+		_ = encoder.Encode(data)
+	}
+	return append(dst, encoder.FinishNoCopy()...), nil
+}
+
+// normalizeType returns TypeProtobuf for any type other than TypeJSON and
+// TypeJSONLengthPrefixed.
+func normalizeType(t Type) Type {
+	if isJSON(t) {
+		return t
+	}
+	return TypeProtobuf
+}
+
+// isJSON tells whether messages of the given protocol type are encoded as JSON,
+// whatever the framing.
+func isJSON(t Type) bool {
+	return t == TypeJSON || t == TypeJSONLengthPrefixed
+}
+
+// prepareTranscode makes m, decoded from one protocol type, ready to be encoded
+// as to: it checks that payloads going to JSON are JSON, and moves dictionaries
+// to the field the target type carries them in.
+//
+// It walks messages reflectively rather than knowing which of them carry
+// payloads, so that a payload field added to the schema is not missed.
+func prepareTranscode(m protoreflect.Message, to Type) error {
+	if d, ok := m.Interface().(*Dictionary); ok {
+		if err := transcodeDictionary(d, to); err != nil {
+			return err
+		}
+	}
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.Kind() == protoreflect.BytesKind:
+			if isJSON(to) && !fd.IsList() && !fd.IsMap() {
+				if b := v.Bytes(); len(b) > 0 && !json.Valid(b) {
+					err = fmt.Errorf("%w: %s.%s", ErrRawNotJSON, fd.ContainingMessage().Name(), fd.Name())
+				}
+			}
+		case fd.IsMap():
+			if fd.MapValue().Kind() == protoreflect.MessageKind {
+				v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+					err = prepareTranscode(v.Message(), to)
+					return err == nil
+				})
+			}
+		case fd.Kind() == protoreflect.MessageKind:
+			if fd.IsList() {
+				list := v.List()
+				for i := 0; i < list.Len() && err == nil; i++ {
+					err = prepareTranscode(list.Get(i).Message(), to)
+				}
+			} else {
+				err = prepareTranscode(v.Message(), to)
+			}
+		}
+		return err == nil
+	})
+	return err
+}
+
+// transcodeDictionary moves the dictionary content to Dictionary.data_b64 for
+// JSON, and to Dictionary.data for Protobuf.
+func transcodeDictionary(d *Dictionary, to Type) error {
+	if isJSON(to) {
+		if len(d.Data) > 0 {
+			d.DataB64 = base64.StdEncoding.EncodeToString(d.Data)
+			d.Data = nil
+		}
+		return nil
+	}
+	if d.DataB64 != "" {
+		data, err := base64.StdEncoding.DecodeString(d.DataB64)
+		if err != nil {
+			return err
+		}
+		d.Data = data
+		d.DataB64 = ""
+	}
+	return nil
+}
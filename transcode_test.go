@@ -0,0 +1,147 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func encodeReplies(t *testing.T, protoType Type, replies ...*Reply) []byte {
+	t.Helper()
+	encoder := NewJSONDataEncoder()
+	var dataEncoder DataEncoder = encoder
+	if protoType != TypeJSON {
+		dataEncoder = NewProtobufDataEncoder()
+	}
+	for _, reply := range replies {
+		data, err := GetReplyEncoder(protoType).Encode(reply)
+		require.NoError(t, err)
+		require.NoError(t, dataEncoder.Encode(data))
+	}
+	return dataEncoder.Finish()
+}
+
+func decodeAllCommands(t *testing.T, protoType Type, frame []byte) []*Command {
+	t.Helper()
+	decoder := GetCommandDecoder(protoType, frame)
+	defer PutCommandDecoder(protoType, decoder)
+	var cmds []*Command
+	for {
+		cmd, err := decoder.Decode()
+		if cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+		if err == io.EOF {
+			return cmds
+		}
+		require.NoError(t, err)
+	}
+}
+
+func decodeAllReplies(t *testing.T, protoType Type, frame []byte) []*Reply {
+	t.Helper()
+	decoder := GetReplyDecoder(protoType, frame)
+	defer PutReplyDecoder(protoType, decoder)
+	var replies []*Reply
+	for {
+		reply, err := decoder.Decode()
+		if err == io.EOF {
+			return replies
+		}
+		require.NoError(t, err)
+		replies = append(replies, reply)
+	}
+}
+
+var transcodeCommands = []*Command{
+	{Id: 1, Connect: &ConnectRequest{Token: "t", Data: Raw(`{"a":1}`), Subs: map[string]*SubscribeRequest{"news": {Data: Raw(`[1,2]`)}}}},
+	{Id: 2, Subscribe: &SubscribeRequest{Channel: "news", Tf: filterWide(2)}},
+	{Id: 3, Publish: &PublishRequest{Channel: "news", Data: Raw(`{"text":"line\nbreak"}`)}},
+	{Send: &SendRequest{Data: Raw(`"hello"`)}},
+}
+
+func TestTranscodeCommands(t *testing.T) {
+	for _, from := range []Type{TypeJSON, TypeProtobuf} {
+		to := TypeProtobuf
+		if from == TypeProtobuf {
+			to = TypeJSON
+		}
+		frame := encodeCommands(t, from, transcodeCommands...)
+		out, err := TranscodeCommands([]byte("x"), frame, from, to)
+		require.NoError(t, err)
+		require.Equal(t, byte('x'), out[0])
+		got := decodeAllCommands(t, to, out[1:])
+		require.Len(t, got, len(transcodeCommands))
+		for i := range got {
+			require.True(t, proto.Equal(transcodeCommands[i], got[i]), "%s to %s: %v != %v", from, to, transcodeCommands[i], got[i])
+		}
+
+		// And back.
+		back, err := TranscodeCommands(nil, out[1:], to, from)
+		require.NoError(t, err)
+		require.Equal(t, frame, back)
+	}
+}
+
+func TestTranscodeReplies(t *testing.T) {
+	dict := []byte{0x78, 0x01, 0x00, 0xff, 0xfe, '\n'}
+	replies := []*Reply{
+		{Id: 1, Connect: &ConnectResult{Client: "c", Data: Raw(`{"a":1}`), Dict: &Dictionary{Id: "d", Data: dict}}},
+		{Push: &Push{Channel: "news", Pub: &Publication{Data: Raw(`{"b":2}`), Tags: map[string]string{"k": "v"}}}},
+		{},
+		{Id: 2, Error: &Error{Code: 100, Message: "internal"}},
+	}
+	frame := encodeReplies(t, TypeProtobuf, replies...)
+	out, err := TranscodeReplies(nil, frame, TypeProtobuf, TypeJSON)
+	require.NoError(t, err)
+	got := decodeAllReplies(t, TypeJSON, out)
+	require.Len(t, got, len(replies))
+	require.Empty(t, got[0].Connect.Dict.Data)
+	require.NotEmpty(t, got[0].Connect.Dict.DataB64)
+	require.Equal(t, base64.StdEncoding.EncodeToString(dict), got[0].Connect.Dict.DataB64)
+	for i := 1; i < len(replies); i++ {
+		require.True(t, proto.Equal(replies[i], got[i]), "%v != %v", replies[i], got[i])
+	}
+
+	back, err := TranscodeReplies(nil, out, TypeJSON, TypeProtobuf)
+	require.NoError(t, err)
+	require.Equal(t, frame, back)
+}
+
+func TestTranscode_RawNotJSON(t *testing.T) {
+	frame := encodeReplies(t, TypeProtobuf,
+		&Reply{Push: &Push{Channel: "news", Pub: &Publication{Data: Raw(`{}`)}}},
+		&Reply{Push: &Push{Channel: "news", Pub: &Publication{Data: Raw{0xff, 0x00}}}},
+	)
+	_, err := TranscodeReplies(nil, frame, TypeProtobuf, TypeJSON)
+	require.ErrorIs(t, err, ErrRawNotJSON)
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	require.Equal(t, TypeProtobuf, decodeErr.Type)
+	require.Equal(t, 1, decodeErr.Index)
+	require.Contains(t, err.Error(), "payload is not valid JSON: Publication.data")
+
+	cmdFrame := encodeCommands(t, TypeProtobuf, &Command{Id: 1, Rpc: &RPCRequest{Data: Raw("not json")}})
+	_, err = TranscodeCommands(nil, cmdFrame, TypeProtobuf, TypeJSON)
+	require.ErrorIs(t, err, ErrRawNotJSON)
+
+	// Binary payloads are fine the other way around.
+	out, err := TranscodeCommands(nil, encodeCommands(t, TypeJSON, &Command{Id: 1, Rpc: &RPCRequest{Data: Raw(`"x"`)}}), TypeJSON, TypeProtobuf)
+	require.NoError(t, err)
+	require.Len(t, decodeAllCommands(t, TypeProtobuf, out), 1)
+}
+
+func TestTranscode_Malformed(t *testing.T) {
+	_, err := TranscodeCommands(nil, []byte("{\"id\":1}\n{"), TypeJSON, TypeProtobuf)
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	require.Equal(t, 1, decodeErr.Index)
+
+	out, err := TranscodeCommands([]byte("a"), []byte("{}"), TypeJSON, TypeJSON)
+	require.NoError(t, err)
+	require.Equal(t, []byte("a{}"), out)
+}
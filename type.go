@@ -10,6 +10,12 @@ const (
 	TypeJSON Type = "json"
 	// TypeProtobuf means Protobuf protocol.
 	TypeProtobuf Type = "protobuf"
+	// TypeJSONLengthPrefixed means JSON protocol with messages framed as in
+	// TypeProtobuf, prefixed with their length encoded as a varint, rather than
+	// separated by a `\n` delimiter. With no delimiter to protect, Raw payloads
+	// are kept intact – TypeJSON strips raw newlines from them, see
+	// Raw.MarshalJSON – so a subscriber gets the very bytes a publisher sent.
+	TypeJSONLengthPrefixed Type = "json-length-prefixed"
 )
 
 // FrameType describes the type of a protocol frame. It's not a part of the wire
@@ -102,18 +108,130 @@ func (f FrameType) String() string {
 	}
 }
 
+// FrameTypeOfCommand returns the frame type of a command: the type of its
+// request, or FrameTypeClientPong for the empty command a client answers server
+// pings with. A command carrying a PingRequest is FrameTypeClientPong too. A
+// command carrying several requests is given the type of the first in field
+// number order, the one a server handles.
+//
+// A command with an id but no request is not a pong, which has no id, and is
+// given no type: FrameType(0), as FrameTypeOfReply does for such replies.
+func FrameTypeOfCommand(c *Command) FrameType {
+	switch {
+	case c.Connect != nil:
+		return FrameTypeConnect
+	case c.Subscribe != nil:
+		return FrameTypeSubscribe
+	case c.Unsubscribe != nil:
+		return FrameTypeUnsubscribe
+	case c.Publish != nil:
+		return FrameTypePublish
+	case c.Presence != nil:
+		return FrameTypePresence
+	case c.PresenceStats != nil:
+		return FrameTypePresenceStats
+	case c.History != nil:
+		return FrameTypeHistory
+	case c.Ping != nil:
+		return FrameTypeClientPong
+	case c.Send != nil:
+		return FrameTypeSend
+	case c.Rpc != nil:
+		return FrameTypeRPC
+	case c.Refresh != nil:
+		return FrameTypeRefresh
+	case c.SubRefresh != nil:
+		return FrameTypeSubRefresh
+	case c.Id == 0:
+		return FrameTypeClientPong
+	default:
+		return 0
+	}
+}
+
+// FrameTypeOfReply returns the frame type of a reply: the type of the command
+// it answers for a reply carrying a result, that of its push for a reply
+// carrying one, and FrameTypeServerPing for the empty reply a server pings
+// clients with. A reply carrying a PingResult is FrameTypeServerPing too.
+//
+// A reply carrying an error rather than a result does not tell which command it
+// answers, and is given no type: FrameType(0), whose String is "unknown".
+func FrameTypeOfReply(r *Reply) FrameType {
+	switch {
+	case r.Push != nil:
+		return FrameTypeOfPush(r.Push)
+	case r.Connect != nil:
+		return FrameTypeConnect
+	case r.Subscribe != nil:
+		return FrameTypeSubscribe
+	case r.Unsubscribe != nil:
+		return FrameTypeUnsubscribe
+	case r.Publish != nil:
+		return FrameTypePublish
+	case r.Presence != nil:
+		return FrameTypePresence
+	case r.PresenceStats != nil:
+		return FrameTypePresenceStats
+	case r.History != nil:
+		return FrameTypeHistory
+	case r.Ping != nil:
+		return FrameTypeServerPing
+	case r.Rpc != nil:
+		return FrameTypeRPC
+	case r.Refresh != nil:
+		return FrameTypeRefresh
+	case r.SubRefresh != nil:
+		return FrameTypeSubRefresh
+	case r.Id == 0 && r.Error == nil:
+		return FrameTypeServerPing
+	default:
+		return 0
+	}
+}
+
+// FrameTypeOfPush returns the frame type of a push, or FrameType(0) for a push
+// carrying nothing.
+func FrameTypeOfPush(p *Push) FrameType {
+	switch {
+	case p.Pub != nil:
+		return FrameTypePushPublication
+	case p.Join != nil:
+		return FrameTypePushJoin
+	case p.Leave != nil:
+		return FrameTypePushLeave
+	case p.Unsubscribe != nil:
+		return FrameTypePushUnsubscribe
+	case p.Message != nil:
+		return FrameTypePushMessage
+	case p.Subscribe != nil:
+		return FrameTypePushSubscribe
+	case p.Connect != nil:
+		return FrameTypePushConnect
+	case p.Disconnect != nil:
+		return FrameTypePushDisconnect
+	case p.Refresh != nil:
+		return FrameTypePushRefresh
+	default:
+		return 0
+	}
+}
+
 // Default push encoders returned by GetPushEncoder. They are stateless, so a
 // single instance per protocol type is shared by all connections.
 var (
-	DefaultJsonPushEncoder     = NewJSONPushEncoder()
-	DefaultProtobufPushEncoder = NewProtobufPushEncoder()
+	DefaultJsonPushEncoder               = NewJSONPushEncoder()
+	DefaultProtobufPushEncoder           = NewProtobufPushEncoder()
+	DefaultJsonLengthPrefixedPushEncoder = NewJSONLengthPrefixedPushEncoder()
 )
 
 // GetPushEncoder returns a PushEncoder for the given protocol type. Any type
-// other than TypeJSON is treated as TypeProtobuf.
+// other than TypeJSON and TypeJSONLengthPrefixed is treated as TypeProtobuf.
 func GetPushEncoder(protoType Type) PushEncoder {
-	if protoType == TypeJSON {
+	switch protoType {
+	case TypeJSON:
 		return DefaultJsonPushEncoder
+	case TypeJSONLengthPrefixed:
+		return DefaultJsonLengthPrefixedPushEncoder
 	}
 	return DefaultProtobufPushEncoder
 }
@@ -121,15 +239,19 @@ func GetPushEncoder(protoType Type) PushEncoder {
 // Default reply encoders returned by GetReplyEncoder. They are stateless, so a
 // single instance per protocol type is shared by all connections.
 var (
-	DefaultJsonReplyEncoder     = NewJSONReplyEncoder()
-	DefaultProtobufReplyEncoder = NewProtobufReplyEncoder()
+	DefaultJsonReplyEncoder               = NewJSONReplyEncoder()
+	DefaultProtobufReplyEncoder           = NewProtobufReplyEncoder()
+	DefaultJsonLengthPrefixedReplyEncoder = NewJSONLengthPrefixedReplyEncoder()
 )
 
 // GetReplyEncoder returns a ReplyEncoder for the given protocol type. Any type
-// other than TypeJSON is treated as TypeProtobuf.
+// other than TypeJSON and TypeJSONLengthPrefixed is treated as TypeProtobuf.
 func GetReplyEncoder(protoType Type) ReplyEncoder {
-	if protoType == TypeJSON {
+	switch protoType {
+	case TypeJSON:
 		return DefaultJsonReplyEncoder
+	case TypeJSONLengthPrefixed:
+		return DefaultJsonLengthPrefixedReplyEncoder
 	}
 	return DefaultProtobufReplyEncoder
 }
@@ -139,11 +261,17 @@ var (
 	protobufDataEncoderPool    sync.Pool
 	jsonCommandDecoderPool     sync.Pool
 	protobufCommandDecoderPool sync.Pool
+	jsonReplyDecoderPool       sync.Pool
+	protobufReplyDecoderPool   sync.Pool
+
+	jsonLengthPrefixedCommandDecoderPool sync.Pool
+	jsonLengthPrefixedReplyDecoderPool   sync.Pool
 )
 
 // GetDataEncoder returns a DataEncoder for the given protocol type, taking it
 // from a pool and resetting it. Return it with PutDataEncoder once the frame is
-// built. Any type other than TypeJSON is treated as TypeProtobuf.
+// built. Any type other than TypeJSON and TypeJSONLengthPrefixed is treated as
+// TypeProtobuf.
 func GetDataEncoder(protoType Type) DataEncoder {
 	if protoType == TypeJSON {
 		e := jsonDataEncoderPool.Get()
@@ -154,6 +282,7 @@ func GetDataEncoder(protoType Type) DataEncoder {
 		protoEncoder.Reset()
 		return protoEncoder
 	}
+	// TypeJSONLengthPrefixed frames messages as TypeProtobuf does.
 	e := protobufDataEncoderPool.Get()
 	if e == nil {
 		return NewProtobufDataEncoder()
@@ -177,41 +306,121 @@ func PutDataEncoder(protoType Type, e DataEncoder) {
 // GetCommandDecoder returns a CommandDecoder for the given protocol type, taking
 // it from a pool and resetting it to the given frame. Return it with
 // PutCommandDecoder once the frame is fully processed. Any type other than
-// TypeJSON is treated as TypeProtobuf.
-func GetCommandDecoder(protoType Type, data []byte) CommandDecoder {
-	if protoType == TypeJSON {
-		e := jsonCommandDecoderPool.Get()
-		if e == nil {
-			return NewJSONCommandDecoder(data)
+// TypeJSON and TypeJSONLengthPrefixed is treated as TypeProtobuf.
+func GetCommandDecoder(protoType Type, data []byte) CommandIntoDecoder {
+	return GetCommandDecoderWithLimits(protoType, data, DecodeLimits{})
+}
+
+// GetCommandDecoderWithLimits is GetCommandDecoder returning a decoder which
+// enforces the given DecodeLimits.
+func GetCommandDecoderWithLimits(protoType Type, data []byte, limits DecodeLimits) CommandIntoDecoder {
+	switch protoType {
+	case TypeJSON:
+		var commandDecoder *JSONCommandDecoder
+		if e := jsonCommandDecoderPool.Get(); e != nil {
+			commandDecoder = e.(*JSONCommandDecoder)
+			_ = commandDecoder.Reset(data)
+		} else {
+			commandDecoder = NewJSONCommandDecoder(data)
 		}
-		commandDecoder := e.(*JSONCommandDecoder)
-		_ = commandDecoder.Reset(data)
+		commandDecoder.SetLimits(limits)
+		return commandDecoder
+	case TypeJSONLengthPrefixed:
+		var commandDecoder *JSONLengthPrefixedCommandDecoder
+		if e := jsonLengthPrefixedCommandDecoderPool.Get(); e != nil {
+			commandDecoder = e.(*JSONLengthPrefixedCommandDecoder)
+			_ = commandDecoder.Reset(data)
+		} else {
+			commandDecoder = NewJSONLengthPrefixedCommandDecoder(data)
+		}
+		commandDecoder.SetLimits(limits)
 		return commandDecoder
 	}
-	e := protobufCommandDecoderPool.Get()
-	if e == nil {
-		return NewProtobufCommandDecoder(data)
+	var commandDecoder *ProtobufCommandDecoder
+	if e := protobufCommandDecoderPool.Get(); e != nil {
+		commandDecoder = e.(*ProtobufCommandDecoder)
+		// A decoder created with NewProtobufCommandDecoderUnsafe may have been
+		// put here, pooled decoders always copy.
+		commandDecoder.unsafe = false
+		_ = commandDecoder.Reset(data)
+	} else {
+		commandDecoder = NewProtobufCommandDecoder(data)
 	}
-	commandDecoder := e.(*ProtobufCommandDecoder)
-	_ = commandDecoder.Reset(data)
+	commandDecoder.SetLimits(limits)
 	return commandDecoder
 }
 
 // PutCommandDecoder returns a CommandDecoder obtained with GetCommandDecoder to
 // the pool. The decoder must not be used after that.
 func PutCommandDecoder(protoType Type, e CommandDecoder) {
-	if protoType == TypeJSON {
+	switch protoType {
+	case TypeJSON:
 		jsonCommandDecoderPool.Put(e)
 		return
+	case TypeJSONLengthPrefixed:
+		jsonLengthPrefixedCommandDecoderPool.Put(e)
+		return
 	}
 	protobufCommandDecoderPool.Put(e)
 }
 
+// GetReplyDecoder returns a ReplyDecoder for the given protocol type, taking it
+// from a pool and resetting it to the given frame. Return it with
+// PutReplyDecoder once the frame is fully processed. Any type other than
+// TypeJSON and TypeJSONLengthPrefixed is treated as TypeProtobuf.
+func GetReplyDecoder(protoType Type, data []byte) ReplyDecoder {
+	switch protoType {
+	case TypeJSON:
+		e := jsonReplyDecoderPool.Get()
+		if e == nil {
+			return NewJSONReplyDecoder(data)
+		}
+		replyDecoder := e.(*JSONReplyDecoder)
+		_ = replyDecoder.Reset(data)
+		return replyDecoder
+	case TypeJSONLengthPrefixed:
+		e := jsonLengthPrefixedReplyDecoderPool.Get()
+		if e == nil {
+			return NewJSONLengthPrefixedReplyDecoder(data)
+		}
+		replyDecoder := e.(*JSONLengthPrefixedReplyDecoder)
+		_ = replyDecoder.Reset(data)
+		return replyDecoder
+	}
+	e := protobufReplyDecoderPool.Get()
+	if e == nil {
+		return NewProtobufReplyDecoder(data)
+	}
+	replyDecoder := e.(*ProtobufReplyDecoder)
+	_ = replyDecoder.Reset(data)
+	return replyDecoder
+}
+
+// PutReplyDecoder returns a ReplyDecoder obtained with GetReplyDecoder to the
+// pool. The decoder must not be used after that. Replies it decoded stay valid,
+// under the lifetime contract of the decoder type.
+func PutReplyDecoder(protoType Type, e ReplyDecoder) {
+	// Drop the frame so a pooled decoder does not pin it.
+	_ = e.Reset(nil)
+	switch protoType {
+	case TypeJSON:
+		jsonReplyDecoderPool.Put(e)
+		return
+	case TypeJSONLengthPrefixed:
+		jsonLengthPrefixedReplyDecoderPool.Put(e)
+		return
+	}
+	protobufReplyDecoderPool.Put(e)
+}
+
 // GetResultEncoder returns a ResultEncoder for the given protocol type. Any type
-// other than TypeJSON is treated as TypeProtobuf.
+// other than TypeJSON and TypeJSONLengthPrefixed is treated as TypeProtobuf.
 func GetResultEncoder(protoType Type) ResultEncoder {
-	if protoType == TypeJSON {
+	switch protoType {
+	case TypeJSON:
 		return NewJSONResultEncoder()
+	case TypeJSONLengthPrefixed:
+		return NewJSONLengthPrefixedResultEncoder()
 	}
 	return NewProtobufResultEncoder()
 }
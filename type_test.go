@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// withField returns m with only the given message field set, to an empty
+// message.
+func withField[M interface{ ProtoReflect() protoreflect.Message }](m M, fd protoreflect.FieldDescriptor) M {
+	r := m.ProtoReflect()
+	r.Set(fd, r.NewField(fd))
+	return m
+}
+
+// requireFrameTypes checks that every message field of m has an entry in want,
+// so that adding a field to the schema fails until it is classified, and that
+// frameTypeOf returns that entry for a message with only that field set.
+func requireFrameTypes[M interface{ ProtoReflect() protoreflect.Message }](t *testing.T, newM func() M, frameTypeOf func(M) FrameType, want map[protoreflect.Name]FrameType) {
+	t.Helper()
+	fields := newM().ProtoReflect().Descriptor().Fields()
+	seen := 0
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.Kind() != protoreflect.MessageKind {
+			continue
+		}
+		frameType, ok := want[fd.Name()]
+		require.True(t, ok, "field %s has no frame type, add it to the FrameTypeOf function and to this test", fd.FullName())
+		require.Equal(t, frameType, frameTypeOf(withField(newM(), fd)), "field %s", fd.FullName())
+		seen++
+	}
+	require.Equal(t, len(want), seen, "frame types expected for fields not in the schema")
+}
+
+func TestFrameTypeOfCommand(t *testing.T) {
+	requireFrameTypes(t, func() *Command { return &Command{Id: 1} }, FrameTypeOfCommand, map[protoreflect.Name]FrameType{
+		"connect":        FrameTypeConnect,
+		"subscribe":      FrameTypeSubscribe,
+		"unsubscribe":    FrameTypeUnsubscribe,
+		"publish":        FrameTypePublish,
+		"presence":       FrameTypePresence,
+		"presence_stats": FrameTypePresenceStats,
+		"history":        FrameTypeHistory,
+		"ping":           FrameTypeClientPong,
+		"send":           FrameTypeSend,
+		"rpc":            FrameTypeRPC,
+		"refresh":        FrameTypeRefresh,
+		"sub_refresh":    FrameTypeSubRefresh,
+	})
+	require.Equal(t, FrameTypeClientPong, FrameTypeOfCommand(&Command{}))
+	require.Equal(t, FrameType(0), FrameTypeOfCommand(&Command{Id: 5}))
+	require.Equal(t, FrameTypeSubscribe, FrameTypeOfCommand(&Command{
+		History:   &HistoryRequest{},
+		Subscribe: &SubscribeRequest{},
+	}))
+}
+
+// PeekCommand must classify commands the same way.
+func TestFrameTypeOfCommand_Peek(t *testing.T) {
+	fields := (&Command{}).ProtoReflect().Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.Kind() != protoreflect.MessageKind {
+			continue
+		}
+		cmd := withField(&Command{Id: 1}, fd)
+		for _, protoType := range []Type{TypeJSON, TypeProtobuf} {
+			peek, err := PeekCommand(protoType, encodeCommand(t, protoType, cmd))
+			require.NoError(t, err)
+			require.Equal(t, FrameTypeOfCommand(cmd), peek.FrameType, "%s %s", protoType, fd.Name())
+		}
+	}
+}
+
+func TestFrameTypeOfReply(t *testing.T) {
+	requireFrameTypes(t, func() *Reply { return &Reply{Id: 1} }, FrameTypeOfReply, map[protoreflect.Name]FrameType{
+		// An empty push, see TestFrameTypeOfReply_Push.
+		"push":           0,
+		"error":          0,
+		"connect":        FrameTypeConnect,
+		"subscribe":      FrameTypeSubscribe,
+		"unsubscribe":    FrameTypeUnsubscribe,
+		"publish":        FrameTypePublish,
+		"presence":       FrameTypePresence,
+		"presence_stats": FrameTypePresenceStats,
+		"history":        FrameTypeHistory,
+		"ping":           FrameTypeServerPing,
+		"rpc":            FrameTypeRPC,
+		"refresh":        FrameTypeRefresh,
+		"sub_refresh":    FrameTypeSubRefresh,
+	})
+	require.Equal(t, FrameTypeServerPing, FrameTypeOfReply(&Reply{}))
+	require.Equal(t, FrameType(0), FrameTypeOfReply(&Reply{Id: 1}))
+	require.Equal(t, FrameType(0), FrameTypeOfReply(&Reply{Error: &Error{Code: 100}}))
+}
+
+func TestFrameTypeOfReply_Push(t *testing.T) {
+	require.Equal(t, FrameTypePushJoin, FrameTypeOfReply(&Reply{Push: &Push{Channel: "a", Join: &Join{}}}))
+}
+
+func TestFrameTypeOfPush(t *testing.T) {
+	requireFrameTypes(t, func() *Push { return &Push{Channel: "a"} }, FrameTypeOfPush, map[protoreflect.Name]FrameType{
+		"pub":         FrameTypePushPublication,
+		"join":        FrameTypePushJoin,
+		"leave":       FrameTypePushLeave,
+		"unsubscribe": FrameTypePushUnsubscribe,
+		"message":     FrameTypePushMessage,
+		"subscribe":   FrameTypePushSubscribe,
+		"connect":     FrameTypePushConnect,
+		"disconnect":  FrameTypePushDisconnect,
+		"refresh":     FrameTypePushRefresh,
+	})
+	require.Equal(t, FrameType(0), FrameTypeOfPush(&Push{}))
+}
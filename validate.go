@@ -0,0 +1,221 @@
+package protocol
+
+import "fmt"
+
+// Values of SubRefreshRequest.type.
+const (
+	// SubRefreshTypeRefresh refreshes the subscription token.
+	SubRefreshTypeRefresh int32 = 0
+	// SubRefreshTypeTrack starts tracking the keys of SubRefreshRequest.track.
+	SubRefreshTypeTrack int32 = 1
+	// SubRefreshTypeUntrack stops tracking the keys of SubRefreshRequest.untrack.
+	SubRefreshTypeUntrack int32 = 2
+)
+
+// ViolationKind tells which protocol invariant a Violation breaks.
+type ViolationKind int
+
+const (
+	// ViolationNoRequest is a Command with no request set.
+	ViolationNoRequest ViolationKind = iota + 1
+	// ViolationSeveralRequests is a Command with more than one request set.
+	ViolationSeveralRequests
+	// ViolationMissingChannel is a request addressing a channel without naming
+	// one.
+	ViolationMissingChannel
+	// ViolationNegativeLimit is a negative limit.
+	ViolationNegativeLimit
+	// ViolationBadSubRefreshType is a SubRefreshRequest.type outside of the
+	// SubRefreshType values.
+	ViolationBadSubRefreshType
+)
+
+var violationKindNames = map[ViolationKind]string{
+	ViolationNoRequest:         "no request",
+	ViolationSeveralRequests:   "several requests",
+	ViolationMissingChannel:    "missing channel",
+	ViolationNegativeLimit:     "negative limit",
+	ViolationBadSubRefreshType: "bad sub_refresh type",
+}
+
+// String returns a short description of the kind.
+func (k ViolationKind) String() string {
+	if name, ok := violationKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("violation(%d)", int(k))
+}
+
+// Violation is the error Validate methods return for a message breaking a
+// protocol invariant. Use errors.As to get at it:
+//
+//	if err := cmd.Validate(); err != nil {
+//		var v *Violation
+//		if errors.As(err, &v) && v.Kind == ViolationMissingChannel {
+//			// ...
+//		}
+//	}
+type Violation struct {
+	Kind ViolationKind
+	// Field is the offending field, as a path of protocol field names such as
+	// "subscribe.channel". It is empty for violations of Command as a whole.
+	Field string
+}
+
+// Error implements the error interface.
+func (v *Violation) Error() string {
+	if v.Field == "" {
+		return "centrifugal: invalid command: " + v.Kind.String()
+	}
+	return "centrifugal: invalid command: " + v.Kind.String() + " in " + v.Field
+}
+
+// prefixed returns err with its Violation field placed under the given parent
+// field.
+func prefixed(parent string, err error) error {
+	if v, ok := err.(*Violation); ok {
+		return &Violation{Kind: v.Kind, Field: parent + "." + v.Field}
+	}
+	return err
+}
+
+// Validate checks that c carries exactly one request, and that this request is
+// valid. It returns a *Violation describing the first problem found, or nil.
+//
+// Decoders do not validate: a server calls Validate on each decoded command
+// before dispatching it, so that malformed input is rejected the same way
+// regardless of which handler it is meant for.
+func (c *Command) Validate() error {
+	requests := [...]struct {
+		field   string
+		present bool
+		req     validator
+	}{
+		{"connect", c.Connect != nil, c.Connect},
+		{"subscribe", c.Subscribe != nil, c.Subscribe},
+		{"unsubscribe", c.Unsubscribe != nil, c.Unsubscribe},
+		{"publish", c.Publish != nil, c.Publish},
+		{"presence", c.Presence != nil, c.Presence},
+		{"presence_stats", c.PresenceStats != nil, c.PresenceStats},
+		{"history", c.History != nil, c.History},
+		{"ping", c.Ping != nil, c.Ping},
+		{"send", c.Send != nil, c.Send},
+		{"rpc", c.Rpc != nil, c.Rpc},
+		{"refresh", c.Refresh != nil, c.Refresh},
+		{"sub_refresh", c.SubRefresh != nil, c.SubRefresh},
+	}
+	n, found := 0, 0
+	for i, r := range requests {
+		if r.present {
+			n++
+			found = i
+		}
+	}
+	switch {
+	case n == 0:
+		return &Violation{Kind: ViolationNoRequest}
+	case n > 1:
+		return &Violation{Kind: ViolationSeveralRequests}
+	}
+	if err := requests[found].req.Validate(); err != nil {
+		return prefixed(requests[found].field, err)
+	}
+	return nil
+}
+
+// validator is implemented by every request type.
+type validator interface {
+	Validate() error
+}
+
+// Validate checks the request. Channels to subscribe to at connect are the keys
+// of subs, so those must be non-empty, while the channel field of the
+// subscription requests themselves is ignored.
+func (r *ConnectRequest) Validate() error {
+	for channel, sub := range r.Subs {
+		if channel == "" {
+			return &Violation{Kind: ViolationMissingChannel, Field: "subs"}
+		}
+		if sub != nil && sub.Limit < 0 {
+			return &Violation{Kind: ViolationNegativeLimit, Field: "subs.limit"}
+		}
+	}
+	return nil
+}
+
+// Validate checks the request.
+func (r *SubscribeRequest) Validate() error {
+	if r.Channel == "" {
+		return &Violation{Kind: ViolationMissingChannel, Field: "channel"}
+	}
+	if r.Limit < 0 {
+		return &Violation{Kind: ViolationNegativeLimit, Field: "limit"}
+	}
+	return nil
+}
+
+// Validate checks the request.
+func (r *UnsubscribeRequest) Validate() error {
+	return validateChannel(r.Channel)
+}
+
+// Validate checks the request.
+func (r *PublishRequest) Validate() error {
+	return validateChannel(r.Channel)
+}
+
+// Validate checks the request.
+func (r *PresenceRequest) Validate() error {
+	return validateChannel(r.Channel)
+}
+
+// Validate checks the request.
+func (r *PresenceStatsRequest) Validate() error {
+	return validateChannel(r.Channel)
+}
+
+// Validate checks the request.
+func (r *HistoryRequest) Validate() error {
+	if r.Channel == "" {
+		return &Violation{Kind: ViolationMissingChannel, Field: "channel"}
+	}
+	if r.Limit < 0 {
+		return &Violation{Kind: ViolationNegativeLimit, Field: "limit"}
+	}
+	return nil
+}
+
+// Validate checks the request. A ping carries nothing, so it is always valid.
+func (r *PingRequest) Validate() error { return nil }
+
+// Validate checks the request. The payload is opaque to the protocol, so a send
+// is always valid.
+func (r *SendRequest) Validate() error { return nil }
+
+// Validate checks the request. Both the payload and the method are up to the
+// application, so an RPC is always valid.
+func (r *RPCRequest) Validate() error { return nil }
+
+// Validate checks the request. Whether the token is acceptable is up to the
+// server, so a refresh is always valid as far as the protocol goes.
+func (r *RefreshRequest) Validate() error { return nil }
+
+// Validate checks the request.
+func (r *SubRefreshRequest) Validate() error {
+	if r.Channel == "" {
+		return &Violation{Kind: ViolationMissingChannel, Field: "channel"}
+	}
+	switch r.Type {
+	case SubRefreshTypeRefresh, SubRefreshTypeTrack, SubRefreshTypeUntrack:
+	default:
+		return &Violation{Kind: ViolationBadSubRefreshType, Field: "type"}
+	}
+	return nil
+}
+
+func validateChannel(channel string) error {
+	if channel == "" {
+		return &Violation{Kind: ViolationMissingChannel, Field: "channel"}
+	}
+	return nil
+}
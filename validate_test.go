@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func requireViolation(t *testing.T, err error, kind ViolationKind, field string) {
+	t.Helper()
+	var v *Violation
+	require.True(t, errors.As(err, &v), "expected a Violation, got %v", err)
+	require.Equal(t, kind, v.Kind)
+	require.Equal(t, field, v.Field)
+}
+
+func TestCommand_Validate(t *testing.T) {
+	valid := []*Command{
+		{Connect: &ConnectRequest{}},
+		{Connect: &ConnectRequest{Subs: map[string]*SubscribeRequest{"news": {}}}},
+		{Id: 1, Subscribe: &SubscribeRequest{Channel: "news"}},
+		{Id: 1, Unsubscribe: &UnsubscribeRequest{Channel: "news"}},
+		{Id: 1, Publish: &PublishRequest{Channel: "news", Data: []byte(`{}`)}},
+		{Id: 1, Presence: &PresenceRequest{Channel: "news"}},
+		{Id: 1, PresenceStats: &PresenceStatsRequest{Channel: "news"}},
+		{Id: 1, History: &HistoryRequest{Channel: "news", Limit: 10}},
+		{Id: 1, Ping: &PingRequest{}},
+		{Send: &SendRequest{Data: []byte(`{}`)}},
+		{Id: 1, Rpc: &RPCRequest{Method: "m"}},
+		{Id: 1, Refresh: &RefreshRequest{Token: "t"}},
+		{Id: 1, SubRefresh: &SubRefreshRequest{Channel: "news", Type: SubRefreshTypeUntrack}},
+	}
+	for _, cmd := range valid {
+		require.NoError(t, cmd.Validate(), "%v", cmd)
+	}
+
+	tests := []struct {
+		name  string
+		cmd   *Command
+		kind  ViolationKind
+		field string
+	}{
+		{"no request", &Command{Id: 1}, ViolationNoRequest, ""},
+		{"several requests", &Command{Connect: &ConnectRequest{}, Subscribe: &SubscribeRequest{Channel: "news"}}, ViolationSeveralRequests, ""},
+		{"subscribe without channel", &Command{Subscribe: &SubscribeRequest{}}, ViolationMissingChannel, "subscribe.channel"},
+		{"subscribe negative limit", &Command{Subscribe: &SubscribeRequest{Channel: "news", Limit: -1}}, ViolationNegativeLimit, "subscribe.limit"},
+		{"connect sub without channel", &Command{Connect: &ConnectRequest{Subs: map[string]*SubscribeRequest{"": {}}}}, ViolationMissingChannel, "connect.subs"},
+		{"unsubscribe without channel", &Command{Unsubscribe: &UnsubscribeRequest{}}, ViolationMissingChannel, "unsubscribe.channel"},
+		{"publish without channel", &Command{Publish: &PublishRequest{}}, ViolationMissingChannel, "publish.channel"},
+		{"presence without channel", &Command{Presence: &PresenceRequest{}}, ViolationMissingChannel, "presence.channel"},
+		{"presence stats without channel", &Command{PresenceStats: &PresenceStatsRequest{}}, ViolationMissingChannel, "presence_stats.channel"},
+		{"history without channel", &Command{History: &HistoryRequest{}}, ViolationMissingChannel, "history.channel"},
+		{"history negative limit", &Command{History: &HistoryRequest{Channel: "news", Limit: -1}}, ViolationNegativeLimit, "history.limit"},
+		{"sub refresh without channel", &Command{SubRefresh: &SubRefreshRequest{}}, ViolationMissingChannel, "sub_refresh.channel"},
+		{"sub refresh bad type", &Command{SubRefresh: &SubRefreshRequest{Channel: "news", Type: 3}}, ViolationBadSubRefreshType, "sub_refresh.type"},
+		{"sub refresh negative type", &Command{SubRefresh: &SubRefreshRequest{Channel: "news", Type: -1}}, ViolationBadSubRefreshType, "sub_refresh.type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requireViolation(t, tt.cmd.Validate(), tt.kind, tt.field)
+		})
+	}
+}
+
+func TestViolation_Error(t *testing.T) {
+	err := (&Command{History: &HistoryRequest{Channel: "news", Limit: -5}}).Validate()
+	require.EqualError(t, err, "centrifugal: invalid command: negative limit in history.limit")
+	err = (&Command{}).Validate()
+	require.EqualError(t, err, "centrifugal: invalid command: no request")
+	require.Equal(t, "violation(100)", ViolationKind(100).String())
+}
+
+// Requests validate on their own too, with fields relative to the request.
+func TestRequest_Validate(t *testing.T) {
+	requireViolation(t, (&SubscribeRequest{}).Validate(), ViolationMissingChannel, "channel")
+	requireViolation(t, (&SubRefreshRequest{Channel: "a", Type: 7}).Validate(), ViolationBadSubRefreshType, "type")
+	require.NoError(t, (&PingRequest{}).Validate())
+}
+
+func TestCommand_ValidateAllocs(t *testing.T) {
+	cmd := &Command{Id: 1, Publish: &PublishRequest{Channel: "news"}}
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = cmd.Validate()
+	})
+	require.Zero(t, allocs)
+}